@@ -0,0 +1,25 @@
+// Package codec registers a JSON gRPC codec. The message types in api/proto/* are plain
+// Go structs rather than generated protobuf messages, so services that are actually
+// served over gRPC marshal them as JSON (content-subtype "json").
+package codec
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the gRPC content-subtype of the JSON codec.
+const Name = "json"
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return Name }
+
+func init() { encoding.RegisterCodec(jsonCodec{}) }
+
+// CallOption selects the JSON codec for a client call.
+func CallOption() grpc.CallOption { return grpc.CallContentSubtype(Name) }
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"google.golang.org/grpc"
+
+	"clustering/api/proto/codec"
 )
 
 type Empty struct{}
@@ -17,10 +19,85 @@ type Node struct {
 
 type ListNodesResponse struct{ Nodes []*Node }
 
+type Usage struct {
+	CpuMillis int32
+	MemoryMib int32
+	DiskGib   int32
+}
+
+type HeartbeatRequest struct {
+	NodeId        string
+	Usage         *Usage
+	RunningVms    []string
+	DriverVersion string
+	UptimeSeconds int64
+}
+
+type HeartbeatResponse struct {
+	// IntervalSeconds is how often the control plane expects heartbeats.
+	IntervalSeconds int32
+}
+
 type NodeServiceServer interface {
 	ListNodes(context.Context, *Empty) (*ListNodesResponse, error)
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
 }
 
 type UnimplementedNodeServiceServer struct{}
 
-func RegisterNodeServiceServer(s *grpc.Server, srv NodeServiceServer) {}
+const serviceName = "cluster.v1.NodeService"
+
+func RegisterNodeServiceServer(s *grpc.Server, srv NodeServiceServer) {
+	s.RegisterService(&NodeService_ServiceDesc, srv)
+}
+
+func unaryHandler[Req any](call func(NodeServiceServer, context.Context, *Req) (any, error), method string) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(Req)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(NodeServiceServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + method}
+		return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+			return call(srv.(NodeServiceServer), ctx, req.(*Req))
+		})
+	}
+}
+
+var NodeService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*NodeServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "ListNodes", Handler: unaryHandler(func(s NodeServiceServer, ctx context.Context, in *Empty) (any, error) { return s.ListNodes(ctx, in) }, "ListNodes")},
+		{MethodName: "Heartbeat", Handler: unaryHandler(func(s NodeServiceServer, ctx context.Context, in *HeartbeatRequest) (any, error) {
+			return s.Heartbeat(ctx, in)
+		}, "Heartbeat")},
+	},
+	Metadata: "node.proto",
+}
+
+type NodeServiceClient interface {
+	ListNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListNodesResponse, error)
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+}
+
+type nodeServiceClient struct{ cc grpc.ClientConnInterface }
+
+func NewNodeServiceClient(cc grpc.ClientConnInterface) NodeServiceClient {
+	return &nodeServiceClient{cc: cc}
+}
+
+func (c *nodeServiceClient) ListNodes(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListNodesResponse, error) {
+	out := new(ListNodesResponse)
+	err := c.cc.Invoke(ctx, "/"+serviceName+"/ListNodes", in, out, append([]grpc.CallOption{codec.CallOption()}, opts...)...)
+	return out, err
+}
+
+func (c *nodeServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, "/"+serviceName+"/Heartbeat", in, out, append([]grpc.CallOption{codec.CallOption()}, opts...)...)
+	return out, err
+}
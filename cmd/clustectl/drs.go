@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+
+	"clustering/pkg/scheduler"
+)
+
+const drsUsage = "usage: clustectl drs [recommendations|apply <vm>]"
+
+// runDRS shows the rebalancer's recommendations or applies one of them.
+func runDRS(ui string, args []string) {
+	if len(args) == 0 {
+		fmt.Println(drsUsage)
+		return
+	}
+	switch {
+	case args[0] == "recommendations":
+		resp, err := http.Get(ui + "/api/drs/recommendations")
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		checkStatus(resp)
+		var plan scheduler.RebalancePlan
+		if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+			panic(err)
+		}
+		fmt.Printf("mode %s, imbalance %.3f (threshold %.3f)\n", plan.Mode, plan.Imbalance, plan.Threshold)
+		ids := make([]string, 0, len(plan.Nodes))
+		for id := range plan.Nodes {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			u := plan.Nodes[id]
+			fmt.Printf("  %-16s cpu %5.1f%%  memory %5.1f%%\n", id, u.CPU*100, u.Memory*100)
+		}
+		if len(plan.Migrations) == 0 {
+			fmt.Println("no migrations recommended")
+			return
+		}
+		fmt.Println("recommended migrations:")
+		for _, m := range plan.Migrations {
+			hot := ""
+			if m.Hot {
+				hot = "  (relieves overloaded node)"
+			}
+			fmt.Printf("  %-16s %s -> %s  imbalance %.3f%s\n", m.VMID, m.From, m.To, m.Imbalance, hot)
+		}
+	case args[0] == "apply" && len(args) > 1:
+		resp, err := http.Post(ui+"/api/drs/recommendations/"+args[1]+"/apply", "application/json", nil)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		checkStatus(resp)
+		var m scheduler.Migration
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			panic(err)
+		}
+		fmt.Printf("migrating vm %s from %s to %s\n", m.VMID, m.From, m.To)
+	default:
+		fmt.Println(drsUsage)
+	}
+}
+
+// checkStatus exits with the server's message unless the request succeeded.
+func checkStatus(resp *http.Response) {
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "%s: %s", resp.Status, msg)
+		os.Exit(1)
+	}
+}
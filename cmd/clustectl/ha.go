@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"clustering/pkg/scheduler"
+)
+
+const haUsage = "usage: clustectl ha status"
+
+// runHA shows the failover headroom under the HA admission control policy.
+func runHA(ui string, args []string) {
+	if len(args) == 0 || args[0] != "status" {
+		fmt.Println(haUsage)
+		return
+	}
+	resp, err := http.Get(ui + "/api/ha/status")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(resp)
+	var hs scheduler.HAStatus
+	if err := json.NewDecoder(resp.Body).Decode(&hs); err != nil {
+		panic(err)
+	}
+	policy := hs.Policy
+	if policy == "" {
+		policy = "none"
+	}
+	fmt.Printf("policy %s, satisfied %v, tolerates %d host failure(s)\n", policy, hs.Satisfied, hs.TolerableFailures)
+	fmt.Printf("  %-10s %10s %12s\n", "", "cpu", "memory MiB")
+	for _, row := range []struct {
+		name string
+		cpu  int
+		mem  int
+	}{
+		{"capacity", hs.Capacity.CPU, hs.Capacity.Memory},
+		{"reserved", hs.Reserved.CPU, hs.Reserved.Memory},
+		{"demand", hs.Demand.CPU, hs.Demand.Memory},
+		{"headroom", hs.Headroom.CPU, hs.Headroom.Memory},
+	} {
+		fmt.Printf("  %-10s %10d %12d\n", row.name, row.cpu, row.mem)
+	}
+	for _, sc := range hs.Scenarios {
+		if len(sc.Unrestartable) > 0 {
+			fmt.Printf("if %s fail(s): %d restarted, not restarted: %s\n", strings.Join(sc.Failed, ", "), sc.Restarted, strings.Join(sc.Unrestartable, ", "))
+		}
+	}
+	if len(hs.AtRisk) == 0 {
+		fmt.Println("every vm restarts after a failure")
+	}
+}
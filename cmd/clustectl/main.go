@@ -8,14 +8,19 @@ import (
 	"io"
 	"net/http"
 	"os"
+
+	"clustering/pkg/membership"
 )
 
 func main() {
-	var ui string
+	var ui, joinToken string
 	flag.StringVar(&ui, "ui", "http://localhost:8080", "UI base URL")
+	flag.StringVar(&joinToken, "join-token", os.Getenv("CLUSTER_JOIN_TOKEN"), "cluster join token, required to change gossip keys")
 	flag.Parse()
+	// subcommands are positional; drop global flags such as --ui
+	os.Args = append(os.Args[:1], flag.Args()...)
 	if len(os.Args) < 2 {
-		fmt.Println("usage: clustectl [nodes|vms|volumes|networks|storagepools|config|audit|metrics] ...")
+		fmt.Println("usage: clustectl [nodes|node|vms|vm|drs|ha|volumes|networks|storagepools|config|audit|metrics|gossip|broadcast] ...")
 		return
 	}
 	switch os.Args[1] {
@@ -33,6 +38,14 @@ func main() {
 		for _, n := range nodes {
 			fmt.Printf("- %s (%s) %s\n", n["name"], n["addr"], n["status"])
 		}
+	case "node":
+		runNode(ui, os.Args[2:])
+	case "vm":
+		runVM(ui, os.Args[2:])
+	case "drs":
+		runDRS(ui, os.Args[2:])
+	case "ha":
+		runHA(ui, os.Args[2:])
 	case "vms":
 		if len(os.Args) == 2 {
 			resp, err := http.Get(ui + "/api/vms")
@@ -351,5 +364,76 @@ func main() {
 			_ = resp.Body.Close()
 			fmt.Println("ok")
 		}
+	case "gossip":
+		if len(os.Args) < 3 || os.Args[2] != "keys" {
+			fmt.Println("usage: clustectl gossip keys [list|generate|fingerprint <key>|install <key>|use <key>|remove <key>]")
+			return
+		}
+		op := "list"
+		if len(os.Args) > 3 {
+			op = os.Args[3]
+		}
+		switch op {
+		case "generate":
+			key, err := membership.GenerateKey()
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(key)
+			return
+		case "fingerprint":
+			if len(os.Args) < 5 {
+				fmt.Println("usage: clustectl gossip keys fingerprint <key>")
+				return
+			}
+			fmt.Println(membership.KeyFingerprint(os.Args[4]))
+			return
+		case "list":
+			resp, err := http.Get(ui + "/api/gossip/keys")
+			if err != nil {
+				panic(err)
+			}
+			defer resp.Body.Close()
+			io.Copy(os.Stdout, resp.Body)
+		case "install", "use", "remove":
+			if len(os.Args) < 5 {
+				fmt.Printf("usage: clustectl gossip keys %s <key>\n", op)
+				return
+			}
+			b, _ := json.Marshal(map[string]string{"op": op, "key": os.Args[4]})
+			req, _ := http.NewRequest(http.MethodPost, ui+"/api/gossip/keys", bytes.NewReader(b))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+joinToken)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				panic(err)
+			}
+			defer resp.Body.Close()
+			io.Copy(os.Stdout, resp.Body)
+		}
+	case "broadcast":
+		// clustectl broadcast event <name> [json] | clustectl broadcast query <name> [json] [timeout]
+		if len(os.Args) < 4 || (os.Args[2] != "event" && os.Args[2] != "query") {
+			fmt.Println("usage: clustectl broadcast [event|query] <name> [payload-json] [timeout]")
+			return
+		}
+		req := map[string]any{"name": os.Args[3]}
+		if len(os.Args) > 4 {
+			req["payload"] = json.RawMessage(os.Args[4])
+		}
+		if len(os.Args) > 5 {
+			req["timeout"] = os.Args[5]
+		}
+		b, _ := json.Marshal(req)
+		resp, err := http.Post(ui+"/api/broadcast/"+os.Args[2], "application/json", bytes.NewReader(b))
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		if os.Args[2] == "event" {
+			fmt.Println(resp.Status)
+			return
+		}
+		io.Copy(os.Stdout, resp.Body)
 	}
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+const nodeUsage = "usage: clustectl node [cordon|uncordon|drain <id> [max-parallel]|drain-status|drain-cancel|maintenance enter|exit] <id>"
+
+// runNode handles cordon, uncordon, drain and maintenance mode of one node.
+func runNode(ui string, args []string) {
+	if len(args) < 2 {
+		fmt.Println(nodeUsage)
+		return
+	}
+	op, id := args[0], args[1]
+	if op == "maintenance" {
+		if len(args) < 3 || (args[1] != "enter" && args[1] != "exit") {
+			fmt.Println(nodeUsage)
+			return
+		}
+		id = args[2]
+	}
+	base := ui + "/api/nodes/" + id
+	switch op {
+	case "cordon", "uncordon":
+		nodeRequest(http.MethodPost, base+"/"+op, nil)
+		fmt.Printf("node %s %sed\n", id, op)
+	case "drain":
+		body := map[string]int{}
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil {
+				fmt.Println(nodeUsage)
+				return
+			}
+			body["maxParallel"] = n
+		}
+		b, _ := json.Marshal(body)
+		nodeRequest(http.MethodPost, base+"/drain", b)
+		waitDrain(base + "/drain")
+	case "drain-status":
+		printDrain(getDrain(base + "/drain"))
+	case "drain-cancel":
+		nodeRequest(http.MethodDelete, base+"/drain", nil)
+		fmt.Printf("drain of %s cancelled; the node stays cordoned\n", id)
+	case "maintenance":
+		if args[1] == "enter" {
+			nodeRequest(http.MethodPost, base+"/maintenance", nil)
+			waitDrain(base + "/drain")
+			fmt.Printf("node %s is in maintenance\n", id)
+		} else {
+			nodeRequest(http.MethodDelete, base+"/maintenance", nil)
+			fmt.Printf("node %s left maintenance\n", id)
+		}
+	default:
+		fmt.Println(nodeUsage)
+	}
+}
+
+func nodeRequest(method, url string, body []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "%s: %s", resp.Status, msg)
+		os.Exit(1)
+	}
+}
+
+func getDrain(url string) api.DrainStatus {
+	resp, err := http.Get(url)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "%s: %s", resp.Status, msg)
+		os.Exit(1)
+	}
+	var ds api.DrainStatus
+	if err := json.NewDecoder(resp.Body).Decode(&ds); err != nil {
+		panic(err)
+	}
+	return ds
+}
+
+func printDrain(ds api.DrainStatus) {
+	fmt.Printf("%s %s: %d/%d moved, in flight %v", ds.NodeID, ds.Phase, ds.Moved, ds.Total, ds.InFlight)
+	if ds.Message != "" {
+		fmt.Printf(" (%s)", ds.Message)
+	}
+	fmt.Println()
+}
+
+// waitDrain prints the drain's progress until it is no longer running.
+func waitDrain(url string) {
+	last := ""
+	for {
+		ds := getDrain(url)
+		if line := fmt.Sprint(ds.Phase, ds.Moved, ds.Total, ds.InFlight, ds.Message); line != last {
+			printDrain(ds)
+			last = line
+		}
+		if ds.Phase != api.DrainRunning {
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
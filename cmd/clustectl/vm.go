@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"clustering/pkg/scheduler"
+)
+
+const vmUsage = "usage: clustectl vm explain <id>"
+
+// runVM handles commands about one VM.
+func runVM(ui string, args []string) {
+	if len(args) < 2 || args[0] != "explain" {
+		fmt.Println(vmUsage)
+		return
+	}
+	b, _ := json.Marshal(scheduler.SimulateRequest{VMID: args[1]})
+	resp, err := http.Post(ui+"/api/v1/scheduler/simulate", "application/json", bytes.NewReader(b))
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "%s: %s", resp.Status, msg)
+		os.Exit(1)
+	}
+	var res scheduler.Result
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		panic(err)
+	}
+	fmt.Printf("vm %s (profile %s): %s\n", args[1], res.Profile, res.Summary())
+	for _, nr := range res.Nodes {
+		if !nr.Feasible {
+			fmt.Printf("  %-16s rejected  %s\n", nr.NodeID, strings.Join(nr.Reasons, "; "))
+			continue
+		}
+		plugins := make([]string, 0, len(nr.Scores))
+		for p := range nr.Scores {
+			plugins = append(plugins, p)
+		}
+		sort.Strings(plugins)
+		parts := make([]string, len(plugins))
+		for i, p := range plugins {
+			parts[i] = fmt.Sprintf("%s=%d", p, nr.Scores[p])
+		}
+		fmt.Printf("  %-16s score %-5d %s\n", nr.NodeID, nr.Score, strings.Join(parts, " "))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+
+	"clustering/pkg/consensus"
+)
+
+// gracefulLeave has the leader demote and remove this server from the raft configuration
+// and leaves serf so that peers see it as Left rather than Failed. A leader first hands
+// leadership to another voter.
+func gracefulLeave(rft *raft.Raft, s *serf.Serf, nodeID string) {
+	if rft.State() == raft.Leader {
+		if err := rft.LeadershipTransfer().Error(); err != nil {
+			log.Printf("leave: leadership transfer: %v", err)
+		}
+	}
+	leaderAddr := ""
+	if consensus.WaitForLeader(rft, 10*time.Second) {
+		_, leaderID := rft.LeaderWithID()
+		if string(leaderID) != nodeID {
+			leaderAddr = memberHTTPAddr(s, string(leaderID))
+		}
+	}
+
+	// Leave serf before asking for removal so the membership controller does not re-add us.
+	if err := s.Leave(); err != nil {
+		log.Printf("leave: serf leave: %v", err)
+	}
+
+	if leaderAddr == "" {
+		log.Printf("leave: no other leader reachable; %s stays in the raft configuration", nodeID)
+		return
+	}
+	body, _ := json.Marshal(map[string]string{"id": nodeID})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post("http://"+leaderAddr+"/api/raft/leave", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("leave: ask leader %s to remove %s: %v", leaderAddr, nodeID, err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		log.Printf("leave: leader %s refused removal of %s: %s", leaderAddr, nodeID, resp.Status)
+		return
+	}
+	log.Printf("leave: removed %s from the raft configuration", nodeID)
+}
+
+// memberHTTPAddr returns the host:port of a serf member's advertised HTTP API.
+func memberHTTPAddr(s *serf.Serf, name string) string {
+	for _, m := range s.Members() {
+		if m.Name == name && m.Tags["http"] != "" {
+			return net.JoinHostPort(m.Addr.String(), m.Tags["http"])
+		}
+	}
+	return ""
+}
+
+// raftLeaveHandler lets a departing server ask the leader to remove it from the configuration.
+// A voter is demoted first, so the quorum shrinks before the server goes away.
+func raftLeaveHandler(rft *raft.Raft) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", 405)
+			return
+		}
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+			http.Error(w, "id required", 400)
+			return
+		}
+		if rft.State() != raft.Leader {
+			addr, _ := rft.LeaderWithID()
+			http.Error(w, fmt.Sprintf("not leader (leader=%s)", addr), 503)
+			return
+		}
+		cfg, err := consensus.GetConfiguration(rft)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		for _, srv := range cfg.Servers {
+			if srv.ID != raft.ServerID(req.ID) || srv.Suffrage != raft.Voter {
+				continue
+			}
+			if err := consensus.DemoteVoter(rft, srv.ID); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+		}
+		if err := consensus.RemoveServer(rft, raft.ServerID(req.ID)); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(204)
+	}
+}
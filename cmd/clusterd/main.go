@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -25,17 +27,30 @@ import (
 	vmpb "clustering/api/proto/vm"
 	"clustering/pkg/api"
 	grpcapi "clustering/pkg/api/grpc"
+	httphandlers "clustering/pkg/api/http"
 	"clustering/pkg/consensus"
+	dpmctrl "clustering/pkg/controllers/dpm"
+	drainctrl "clustering/pkg/controllers/drain"
+	drsctrl "clustering/pkg/controllers/drs"
 	fsctrl "clustering/pkg/controllers/failover"
 	hcctrl "clustering/pkg/controllers/health"
+	hbctrl "clustering/pkg/controllers/heartbeat"
 	mc "clustering/pkg/controllers/membership"
 	nsync "clustering/pkg/controllers/nodesync"
+	schedctrl "clustering/pkg/controllers/scheduler"
+	taintctrl "clustering/pkg/controllers/taint"
 	"clustering/pkg/membership"
 	"clustering/pkg/metrics"
+	"clustering/pkg/scheduler"
 	"clustering/pkg/store"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		runRecover(os.Args[2:])
+		return
+	}
+
 	var (
 		nodeID    string
 		dataDir   string
@@ -46,6 +61,15 @@ func main() {
 		serfJoin  string
 		wipeData  bool
 		joinToken string
+		leave     bool
+		encrypt   string
+		keyring   string
+		// failover
+		foGrace   time.Duration
+		fenceCmd  string
+		fenceWait time.Duration
+		// power management
+		powerCmd string
 	)
 
 	flag.StringVar(&nodeID, "node-id", "node-1", "unique node ID")
@@ -59,6 +83,13 @@ func main() {
 	flag.StringVar(&serfBind, "serf-bind", ":7946", "serf bind address host:port")
 	flag.StringVar(&serfJoin, "serf-join", "", "comma-separated serf peers to join")
 	flag.StringVar(&joinToken, "join-token", "", "shared join token that node agents must present")
+	flag.StringVar(&encrypt, "encrypt", "", "initial base64 gossip encryption key (see: clustectl gossip keys generate)")
+	flag.StringVar(&keyring, "keyring-file", "", "gossip keyring file (default <data-dir>/serf/keyring)")
+	flag.BoolVar(&leave, "leave-on-terminate", false, "on SIGTERM transfer leadership, leave raft and serf instead of just stopping")
+	flag.DurationVar(&foGrace, "failover-grace", fsctrl.DefaultGrace, "how long an agent node must be NotReady before it is fenced and its VMs restarted elsewhere")
+	flag.StringVar(&fenceCmd, "fence-command", "", "shell command that powers off a failed node (NODE_ID, NODE_ADDRESS in env); default relies on agent self-fencing")
+	flag.DurationVar(&fenceWait, "self-fence-timeout", 60*time.Second, "node agents' --self-fence-timeout, used when no fence command is set")
+	flag.StringVar(&powerCmd, "power-command", "", "shell command that puts a node in standby or wakes it (POWER_ACTION=standby|wake, NODE_ID, NODE_ADDRESS in env); default leaves nodes running")
 	flag.Parse()
 
 	if wipeData {
@@ -73,10 +104,15 @@ func main() {
 	defer transport.Close()
 
 	// Membership (Serf)
-	sconf := membership.Config{NodeID: nodeID, BindAddr: serfBind}
+	if keyring == "" {
+		keyring = filepath.Join(dataDir, "serf", "keyring")
+	}
+	bcast := membership.NewBroadcaster()
+	bcast.HandleQuery(membership.QueryDiagnostics, membership.DiagnosticsHandler(nodeID, "control-plane"))
+	sconf := membership.Config{NodeID: nodeID, BindAddr: serfBind, KeyringFile: keyring, EncryptKey: encrypt, Broadcaster: bcast}
 	s, events := membership.MustStartSerf(sconf)
 	// Tag this process as control-plane
-	if err := s.SetTags(map[string]string{"role": "control-plane", "raft": string(transport.LocalAddr())}); err != nil {
+	if err := s.SetTags(map[string]string{"role": "control-plane", "raft": string(transport.LocalAddr()), "http": membership.PortOf(uiAddr)}); err != nil {
 		log.Printf("serf set tags: %v", err)
 	}
 	if serfJoin != "" {
@@ -106,6 +142,14 @@ func main() {
 	storeManager := store.NewManager(rft)
 	storeManager.SetFSM(fsm)
 
+	// Document a configuration forced by `clusterd recover` in the audit log
+	if rec, err := consensus.ConsumeRecoveryRecord(dataDir); err != nil {
+		log.Printf("read recovery record: %v", err)
+	} else if rec != nil {
+		storeManager.RecordAudit(store.AuditEvent{Type: "RecoverCluster", Info: rec.Summary()})
+		log.Printf("started from recovered raft configuration: %s", rec.Summary())
+	}
+
 	// Controllers
 	membershipCtrl := mc.NewController(rft, func() []mc.AliveMember {
 		var out []mc.AliveMember
@@ -118,7 +162,16 @@ func main() {
 			}
 		}
 		return out
-	}).WithDesiredVotersFunc(func() int { return fsm.GetStateCopy().Config.DesiredVoters })
+	}).WithDesiredVotersFunc(func() int { return fsm.GetStateCopy().Config.DesiredVoters }).WithRTTFunc(func() map[string]time.Duration {
+		local := membership.CoordinateOf(s, nodeID)
+		rtt := map[string]time.Duration{}
+		for _, m := range s.Members() {
+			if d, ok := local.RTT(membership.CoordinateOf(s, m.Name)); ok {
+				rtt[m.Name] = d
+			}
+		}
+		return rtt
+	})
 
 	nodesyncCtrl := nsync.NewController(func() []nsync.MemberInfo {
 		var out []nsync.MemberInfo
@@ -132,28 +185,37 @@ func main() {
 			if m.Tags["http"] != "" {
 				addr = m.Addr.String() + ":" + m.Tags["http"]
 			}
-			out = append(out, nsync.MemberInfo{ID: m.Name, Addr: addr, Role: role, Status: status, Tags: m.Tags})
+			out = append(out, nsync.MemberInfo{ID: m.Name, Addr: addr, Role: role, Status: status, Tags: m.Tags, Coordinate: membership.CoordinateOf(s, m.Name)})
 		}
 		return out
 	}, storeManager, func() bool { return rft.State() == raft.Leader })
 
-	healthCtrl := hcctrl.NewController(func() error {
-		state := storeManager.GetStateCopy()
-		for _, n := range state.Nodes {
-			if n.Role != "node" {
-				continue
-			}
-			// Simple health check - in production would make HTTP request
-			if n.Status != "Alive" {
-				// Update node status
-				n.Status = "Failed"
-				storeManager.Apply(context.Background(), store.NewCommand("UpsertNode", n))
-			}
-		}
-		return nil
-	})
+	// probe agent nodes with the configured health checks (default: the agent's /healthz)
+	prober := hcctrl.NewProber(storeManager, func() bool { return rft.State() == raft.Leader })
+	healthCtrl := hcctrl.NewController(prober.Probe).WithInterval(10 * time.Second)
 
-	failoverCtrl := fsctrl.NewController(storeManager, func() bool { return rft.State() == raft.Leader })
+	var fencer fsctrl.Fencer = fsctrl.NewSelfFence(fenceWait + grpcapi.HeartbeatInterval)
+	if fenceCmd != "" {
+		fencer = &fsctrl.ExecFence{Command: fenceCmd}
+	}
+	failoverCtrl := fsctrl.NewController(storeManager, func() bool { return rft.State() == raft.Leader }).WithGrace(foGrace).WithFencer(fencer)
+
+	// a node is NotReady after missing three heartbeats
+	heartbeatCtrl := hbctrl.NewController(storeManager, 3*grpcapi.HeartbeatInterval, func() bool { return rft.State() == raft.Leader })
+
+	// evict VMs from NoExecute-tainted nodes once their tolerations run out
+	taintCtrl := taintctrl.NewController(storeManager, func() bool { return rft.State() == raft.Leader })
+	drainCtrl := drainctrl.NewController(storeManager, func() bool { return rft.State() == raft.Leader })
+	// place VMs without a node; unplaceable ones get a FailedScheduling status
+	schedCtrl := schedctrl.NewController(storeManager, storeManager, func() bool { return rft.State() == raft.Leader })
+	// live-migrate VMs to even out node load, as far as the DRS mode allows
+	drsCtrl := drsctrl.NewController(storeManager, func() bool { return rft.State() == raft.Leader })
+	// park idle nodes and wake them under pressure, when enabled in the config
+	var power dpmctrl.PowerManager = &dpmctrl.LocalPower{}
+	if powerCmd != "" {
+		power = &dpmctrl.ExecPower{Command: powerCmd}
+	}
+	dpmCtrl := dpmctrl.NewController(storeManager, func() bool { return rft.State() == raft.Leader }).WithPower(power)
 
 	// Start controllers
 	stopCh := make(chan struct{})
@@ -161,6 +223,12 @@ func main() {
 	go nodesyncCtrl.Run(stopCh)
 	go healthCtrl.Run(stopCh)
 	go failoverCtrl.Run(stopCh)
+	go heartbeatCtrl.Run(stopCh)
+	go taintCtrl.Run(stopCh)
+	go drainCtrl.Run(stopCh)
+	go schedCtrl.Run(stopCh)
+	go drsCtrl.Run(stopCh)
+	go dpmCtrl.Run(stopCh)
 
 	// HTTP server
 	mux := http.NewServeMux()
@@ -173,17 +241,80 @@ func main() {
 		}
 	})
 
+	mux.HandleFunc("GET /api/nodes/{id}/coordinate", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		coord := membership.CoordinateOf(s, id)
+		if coord == nil {
+			http.Error(w, "no coordinate for "+id, 404)
+			return
+		}
+		rtt := map[string]string{}
+		for _, m := range s.Members() {
+			if d, ok := coord.RTT(membership.CoordinateOf(s, m.Name)); ok && m.Name != id {
+				rtt[m.Name] = d.String()
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "coordinate": coord, "rtt": rtt})
+	})
+
+	mux.HandleFunc("/api/nodes/{id}/taints", httphandlers.NodeTaints(storeManager, storeManager))
+	mux.HandleFunc("POST /api/nodes/{id}/cordon", httphandlers.NodeCordon(storeManager, storeManager))
+	mux.HandleFunc("POST /api/nodes/{id}/uncordon", httphandlers.NodeUncordon(storeManager, storeManager))
+	mux.HandleFunc("/api/nodes/{id}/drain", httphandlers.NodeDrain(storeManager, storeManager))
+	mux.HandleFunc("/api/nodes/{id}/maintenance", httphandlers.NodeMaintenance(storeManager, storeManager))
+
+	mux.HandleFunc("GET /api/events", httphandlers.EventsGet(storeManager))
+	mux.HandleFunc("POST /api/v1/scheduler/simulate", httphandlers.SchedulerSimulate(storeManager))
+	mux.HandleFunc("GET /api/priorityclasses", httphandlers.PriorityClassesGet(storeManager))
+	mux.HandleFunc("POST /api/priorityclasses", httphandlers.PriorityClassesPost(storeManager))
+	mux.HandleFunc("DELETE /api/priorityclasses/{name}", httphandlers.PriorityClassDelete(storeManager, storeManager))
+	mux.HandleFunc("GET /api/drs/recommendations", httphandlers.DRSRecommendations(storeManager))
+	mux.HandleFunc("POST /api/drs/recommendations/{vm}/apply", httphandlers.DRSApply(storeManager, storeManager))
+	mux.HandleFunc("GET /api/ha/status", httphandlers.HAStatus(storeManager))
+
 	mux.HandleFunc("/api/vms", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
-			state := storeManager.GetStateCopy()
-			json.NewEncoder(w).Encode(state.VMs)
+			httphandlers.VMsGet(storeManager)(w, r)
 		} else if r.Method == "POST" {
 			var vm api.VM
 			if err := json.NewDecoder(r.Body).Decode(&vm); err != nil {
 				http.Error(w, err.Error(), 400)
 				return
 			}
-			if err := storeManager.Apply(r.Context(), store.NewCommand("UpsertVM", vm)); err != nil {
+			state := storeManager.GetStateCopy()
+			if err := vm.Restart.Validate(vm.ID, state.VMs); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := vm.ValidateLimits(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := vm.Stop.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := vm.Policy.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if _, ok := state.PriorityClasses[vm.Policy.PriorityClass]; vm.Policy.PriorityClass != "" && !ok {
+				http.Error(w, "unknown priority class "+vm.Policy.PriorityClass, 400)
+				return
+			}
+			if _, ok := scheduler.Profiles(state.Config)[vm.Policy.Profile]; vm.Policy.Profile != "" && !ok {
+				http.Error(w, "unknown scheduler profile "+vm.Policy.Profile, 400)
+				return
+			}
+			if why := scheduler.HAAdmitStart(state, vm); why != "" {
+				http.Error(w, why, 409)
+				return
+			}
+			// a VM posted onto a new node is bound, so the node is checked like the scheduler's picks
+			if err := storeManager.Apply(r.Context(), store.UpsertVMCommand(state, vm)); errors.Is(err, store.ErrBindConflict) {
+				http.Error(w, err.Error(), 409)
+				return
+			} else if err != nil {
 				http.Error(w, err.Error(), 500)
 				return
 			}
@@ -202,10 +333,37 @@ func main() {
 				http.Error(w, err.Error(), 400)
 				return
 			}
+			if _, err := hcctrl.NewChecks(cfg.HealthChecks); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := scheduler.ValidateProfiles(cfg.SchedulerProfiles); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := cfg.Overcommit.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := cfg.DRS.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := cfg.DPM.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := cfg.HA.Validate(); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
 			if err := storeManager.Apply(r.Context(), store.NewCommand("SetConfig", cfg)); err != nil {
 				http.Error(w, err.Error(), 500)
 				return
 			}
+			if err := bcast.Broadcast(membership.EventConfigChanged, map[string]int{"version": storeManager.GetStateCopy().ConfigVersion}); err != nil {
+				log.Printf("broadcast config change: %v", err)
+			}
 			w.WriteHeader(204)
 		}
 	})
@@ -315,6 +473,8 @@ func main() {
 	})
 
 	// VM operations
+	mux.HandleFunc("/api/vms/status", httphandlers.VMStatusPost(storeManager))
+
 	mux.HandleFunc("/api/vms/clone", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
 			var req struct {
@@ -372,6 +532,16 @@ func main() {
 		w.Write([]byte("ok"))
 	})
 
+	// Cluster-wide events and queries over serf
+	mux.HandleFunc("/api/broadcast/event", httphandlers.BroadcastEvent(bcast))
+	mux.HandleFunc("/api/broadcast/query", httphandlers.BroadcastQuery(bcast))
+
+	// Gossip encryption keyring (install/use/remove propagate to every member)
+	mux.HandleFunc("/api/gossip/keys", httphandlers.GossipKeys(s.KeyManager(), joinToken))
+
+	// Raft membership: departing servers ask the leader to remove them
+	mux.HandleFunc("/api/raft/leave", raftLeaveHandler(rft))
+
 	// Audit endpoint
 	mux.HandleFunc("/api/audit", func(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(storeManager.Audit())
@@ -394,7 +564,7 @@ func main() {
 
 	// Register services
 	clusterpb.RegisterClusterServiceServer(grpcServer, grpcapi.NewClusterServer(rft))
-	nodepb.RegisterNodeServiceServer(grpcServer, grpcapi.NewNodeServer(storeManager))
+	nodepb.RegisterNodeServiceServer(grpcServer, grpcapi.NewNodeServer(storeManager, fsm))
 	vmpb.RegisterVMServiceServer(grpcServer, grpcapi.NewVMServer(storeManager, fsm))
 	templatepb.RegisterTemplateServiceServer(grpcServer, grpcapi.NewTemplateServer(storeManager, fsm))
 
@@ -419,7 +589,7 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	<-sigChan
+	sig := <-sigChan
 	log.Println("Shutting down gracefully...")
 
 	// Create snapshot before shutdown
@@ -434,6 +604,11 @@ func main() {
 	// Stop controllers
 	close(stopCh)
 
+	// Leave the cluster so peers see Left and the raft configuration shrinks
+	if leave && sig == syscall.SIGTERM {
+		gracefulLeave(rft, s, nodeID)
+	}
+
 	// Shutdown HTTP server
 	if err := httpSrv.Shutdown(ctx); err != nil {
 		log.Printf("Failed to shutdown HTTP server: %v", err)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"clustering/pkg/consensus"
+)
+
+// runRecover implements `clusterd recover`: an offline rewrite of the raft configuration
+// for disaster recovery after permanent quorum loss. clusterd must be stopped on the node.
+func runRecover(args []string) {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	var (
+		nodeID    string
+		dataDirs  string
+		peersFile string
+		raftAddr  string
+		dryRun    bool
+		yes       bool
+	)
+	fs.StringVar(&nodeID, "node-id", "node-1", "ID of the local surviving node")
+	fs.StringVar(&dataDirs, "data-dir", "./data", "data directory of the survivor (comma-separated for several survivors with --dry-run)")
+	fs.StringVar(&peersFile, "peers", "", "peers.json with the new configuration ([{\"id\",\"address\",\"non_voter\"}])")
+	fs.StringVar(&raftAddr, "raft-addr", "", "recover as a single-voter cluster with this node at the given raft address")
+	fs.BoolVar(&dryRun, "dry-run", false, "print latest index/term and known configuration per survivor without changing anything")
+	fs.BoolVar(&yes, "yes", false, "confirm the configuration rewrite")
+	_ = fs.Parse(args)
+
+	if dryRun {
+		fmt.Printf("%-20s %-10s %-10s %-10s %s\n", "DATA-DIR", "INDEX", "TERM", "CUR-TERM", "CONFIGURATION")
+		for _, dir := range splitCSV(dataDirs) {
+			info, err := inspectDir(dir)
+			if err != nil {
+				fmt.Printf("%-20s error: %v\n", dir, err)
+				continue
+			}
+			fmt.Printf("%-20s %-10d %-10d %-10d %s\n", dir, info.LastIndex, info.LastTerm, info.CurrentTerm, consensus.FormatServers(info.Configuration))
+		}
+		fmt.Println("recover on the survivor with the highest index (ties: highest term)")
+		return
+	}
+
+	dirs := splitCSV(dataDirs)
+	if len(dirs) != 1 {
+		log.Fatalf("recover: exactly one --data-dir is required outside --dry-run")
+	}
+	dataDir := dirs[0]
+
+	var cfg raft.Configuration
+	switch {
+	case peersFile != "" && raftAddr != "":
+		log.Fatalf("recover: use either --peers or --raft-addr, not both")
+	case peersFile != "":
+		c, err := raft.ReadConfigJSON(peersFile)
+		if err != nil {
+			log.Fatalf("recover: read peers file: %v", err)
+		}
+		cfg = c
+	case raftAddr != "":
+		cfg = raft.Configuration{Servers: []raft.Server{{ID: raft.ServerID(nodeID), Address: raft.ServerAddress(raftAddr), Suffrage: raft.Voter}}}
+	default:
+		log.Fatalf("recover: --peers or --raft-addr is required")
+	}
+	if err := consensus.ValidateRecoveryConfiguration(nodeID, cfg); err != nil {
+		log.Fatalf("recover: %v", err)
+	}
+
+	stores, err := consensus.OpenStores(dataDir, time.Second)
+	if err != nil {
+		log.Fatalf("recover: %v", err)
+	}
+	defer stores.Close()
+	if ok, err := raft.HasExistingState(stores.Logs, stores.Stable, stores.Snapshots); err != nil || !ok {
+		log.Fatalf("recover: refusing to recover %s: no existing raft state (err=%v)", dataDir, err)
+	}
+	info, err := consensus.Inspect(stores)
+	if err != nil {
+		log.Fatalf("recover: %v", err)
+	}
+	fmt.Printf("current: index=%d term=%d configuration=%s\n", info.LastIndex, info.LastTerm, consensus.FormatServers(info.Configuration))
+	fmt.Printf("new:     configuration=%s\n", consensus.FormatServers(cfg))
+	if !yes {
+		fmt.Println("re-run with --yes to rewrite the configuration; every server listed must be recovered with the same peers file")
+		os.Exit(1)
+	}
+
+	rec, err := consensus.Recover(nodeID, stores, cfg)
+	if err != nil {
+		log.Fatalf("recover: %v", err)
+	}
+	if err := consensus.WriteRecoveryRecord(dataDir, rec); err != nil {
+		log.Printf("recover: write recovery record: %v", err)
+	}
+	fmt.Println("recovery complete; start clusterd normally (without --bootstrap)")
+}
+
+func inspectDir(dir string) (consensus.RaftStateInfo, error) {
+	stores, err := consensus.OpenStores(dir, time.Second)
+	if err != nil {
+		return consensus.RaftStateInfo{}, err
+	}
+	defer stores.Close()
+	return consensus.Inspect(stores)
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"clustering/pkg/agent"
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/agent/runtime/mock"
+	"clustering/pkg/agent/runtime/process"
+	"clustering/pkg/api"
+	httphandlers "clustering/pkg/api/http"
 	"clustering/pkg/membership"
 )
 
@@ -20,6 +33,20 @@ func main() {
 		cpu       int
 		memory    int
 		disk      int
+		encrypt   string
+		keyring   string
+		cpAddrs   string
+		cpGRPC    string
+		rtName    string
+		rtDir     string
+		procCmds  string
+		cgroup    string
+		memPress  float64
+		diskPress float64
+		scripts   string
+		fenceTO   time.Duration
+		reserved  string
+		ocRatios  string
 	)
 	flag.StringVar(&httpAddr, "http", ":9090", "node agent http addr")
 	flag.StringVar(&nodeID, "node-id", "node-1", "node id")
@@ -29,30 +56,143 @@ func main() {
 	flag.IntVar(&cpu, "cpu", 8000, "capacity CPU (millicores)")
 	flag.IntVar(&memory, "memory", 32768, "capacity memory (MiB)")
 	flag.IntVar(&disk, "disk", 512, "capacity disk (GiB)")
+	flag.StringVar(&reserved, "system-reserved", "", "resources kept for the host, e.g. cpu=500,memory=2048,disk=20")
+	flag.StringVar(&ocRatios, "overcommit", "", "overcommit ratios overriding the cluster's, e.g. cpu=4,memory=1.2")
+	flag.StringVar(&encrypt, "encrypt", "", "initial base64 gossip encryption key")
+	flag.StringVar(&keyring, "keyring-file", "", "gossip keyring file; key rotations are persisted here (default <runtime-dir>/serf/keyring)")
+	flag.StringVar(&cpAddrs, "control-plane", "localhost:8080", "comma separated clusterd HTTP addresses")
+	flag.StringVar(&cpGRPC, "control-plane-grpc", "localhost:8081", "comma separated clusterd gRPC addresses for heartbeats")
+	flag.StringVar(&rtName, "runtime", "mock", "VM runtime: mock or process")
+	flag.StringVar(&rtDir, "runtime-dir", "./agent-data", "per-VM state directory for the process runtime")
+	flag.StringVar(&procCmds, "process-commands", "", "process runtime commands per image, e.g. ubuntu=sleep infinity,web=python3 -m http.server")
+	flag.StringVar(&cgroup, "cgroup-root", "", "cgroup v2 directory for per-VM CPU/memory limits (process runtime)")
+	flag.Float64Var(&memPress, "memory-pressure", 0.9, "used memory share above which the node reports MemoryPressure (0 disables)")
+	flag.Float64Var(&diskPress, "disk-pressure", 0.9, "used disk share of the runtime dir above which the node reports DiskPressure (0 disables)")
+	flag.StringVar(&scripts, "health-scripts", "", "extra health checks as name=shell command, comma separated; DiskPressure/MemoryPressure names feed those conditions")
+	flag.DurationVar(&fenceTO, "self-fence-timeout", 60*time.Second, "stop all VMs after losing leader contact for this long (0 disables)")
 	flag.Parse()
+	if keyring == "" {
+		keyring = filepath.Join(rtDir, "serf", "keyring")
+	}
+	if _, err := api.ParseResources(reserved); err != nil {
+		log.Fatalf("--system-reserved: %v", err)
+	}
+	if _, err := api.ParseOvercommit(ocRatios); err != nil {
+		log.Fatalf("--overcommit: %v", err)
+	}
 
-	s, _ := membership.MustStartSerf(membership.Config{NodeID: nodeID, BindAddr: serfBind})
-	// advertise HTTP port via tag
-	httpPort := httpAddr
-	if strings.HasPrefix(httpPort, ":") {
-		httpPort = strings.TrimPrefix(httpPort, ":")
-	} else {
-		if i := strings.LastIndex(httpPort, ":"); i >= 0 && i+1 < len(httpPort) {
-			httpPort = httpPort[i+1:]
+	var (
+		rt       runtime.VMRuntime
+		injector *mock.Driver
+	)
+	switch rtName {
+	case "mock":
+		injector = mock.New()
+		rt = injector
+	case "process":
+		cmds, err := process.ParseCommands(procCmds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// exited VMs are restarted by the reconciler according to their restart policy
+		d, err := process.New(process.Config{StateDir: rtDir, Commands: cmds, CgroupRoot: cgroup, NoRestart: true})
+		if err != nil {
+			log.Fatal(err)
 		}
+		rt = d
+	default:
+		log.Fatalf("unknown runtime %q", rtName)
 	}
-	tags := map[string]string{"role": "node", "http": httpPort, "cpu": strconv.Itoa(cpu), "memory": strconv.Itoa(memory), "disk": strconv.Itoa(disk)}
+	reconciler := agent.NewReconciler(nodeID, rt, agent.NewHTTPControlPlane(strings.Split(cpAddrs, ",")))
+
+	bcast := membership.NewBroadcaster()
+	bcast.HandleQuery(membership.QueryDiagnostics, membership.DiagnosticsHandler(nodeID, "node"))
+	bcast.HandleQuery(membership.QueryVMRunning, func(p []byte) (any, error) {
+		var req struct {
+			VMID string `json:"vmId"`
+		}
+		if err := json.Unmarshal(p, &req); err != nil {
+			return nil, err
+		}
+		return map[string]bool{"running": reconciler.Running(req.VMID)}, nil
+	})
+	bcast.HandleEvent(membership.EventConfigChanged, func(p []byte) { log.Printf("cluster config changed: %s", p) })
+	bcast.HandleEvent(membership.EventCacheInvalidate, func(p []byte) { log.Printf("cache invalidated: %s", p) })
+	s, events := membership.MustStartSerf(membership.Config{NodeID: nodeID, BindAddr: serfBind, KeyringFile: keyring, EncryptKey: encrypt, Broadcaster: bcast})
+	go func() {
+		for e := range events {
+			log.Printf("serf: %s %s", e.Type, e.Node)
+		}
+	}()
+	// advertise HTTP port via tag
+	httpPort := membership.PortOf(httpAddr)
+	tags := map[string]string{"role": "node", "http": httpPort, "cpu": strconv.Itoa(cpu), "memory": strconv.Itoa(memory), "disk": strconv.Itoa(disk), "caps": strings.Join(rt.Capabilities().Names(), ",")}
 	if joinToken != "" {
 		tags["token"] = joinToken
 	}
+	if reserved != "" {
+		tags["reserved"] = reserved
+	}
+	if ocRatios != "" {
+		tags["overcommit"] = ocRatios
+	}
 	if err := s.SetTags(tags); err != nil {
 		log.Printf("serf set tags: %v", err)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
-	log.Printf("nodeagent listening on %s", httpAddr)
-	if err := http.ListenAndServe(httpAddr, mux); err != nil {
+	healthScripts, err := agent.ParseScripts(scripts)
+	if err != nil {
 		log.Fatal(err)
 	}
+	checks := agent.NewLocalChecks(agent.NewUsageSampler(rtDir), memPress, diskPress, healthScripts)
+
+	stopCh := make(chan struct{})
+	hb := agent.NewHeartbeater(nodeID, rt, agent.NewUsageSampler(rtDir), strings.Split(cpGRPC, ","))
+	if fenceTO > 0 {
+		fence := agent.NewSelfFence(rt, fenceTO)
+		reconciler.WithFence(fence)
+		hb.WithSelfFence(fence)
+	}
+	go reconciler.Run(stopCh)
+	go hb.Run(stopCh)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", httphandlers.AgentHealthz(checks))
+	mux.HandleFunc("GET /api/runtime", httphandlers.RuntimeInfo(rt))
+	mux.HandleFunc("GET /api/vms/{id}/console", httphandlers.VMConsole(rt))
+	mux.HandleFunc("POST /api/vms/{id}/reboot", httphandlers.VMReboot(rt))
+	if injector != nil {
+		mux.HandleFunc("/debug/faults", httphandlers.Faults(injector))
+		mux.HandleFunc("/debug/faults/crash", httphandlers.FaultsCrash(injector))
+	}
+	httpSrv := &http.Server{Addr: httpAddr, Handler: mux}
+	go func() {
+		log.Printf("nodeagent listening on %s", httpAddr)
+		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Shutting down gracefully...")
+
+	// Stop reconciling and heartbeating; VMs keep running
+	close(stopCh)
+
+	// Leave serf so the control plane sees the node as Left rather than Failed
+	if err := s.Leave(); err != nil {
+		log.Printf("serf leave: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpSrv.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shutdown HTTP server: %v", err)
+	}
+	if err := s.Shutdown(); err != nil {
+		log.Printf("Failed to shutdown Serf: %v", err)
+	}
+	log.Println("Shutdown complete")
 }
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+// Script is an operator health check run with sh -c; exit status 0 is healthy. A script
+// named after a pressure condition (DiskPressure, MemoryPressure) feeds that condition
+// instead of failing the node's health.
+type Script struct {
+	Name    string
+	Command string
+}
+
+// ParseScripts reads "name=command,..." as given on the command line.
+func ParseScripts(s string) ([]Script, error) {
+	var out []Script
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, cmd, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(cmd) == "" {
+			return nil, fmt.Errorf("invalid health script %q, want name=command", entry)
+		}
+		out = append(out, Script{Name: strings.TrimSpace(name), Command: strings.TrimSpace(cmd)})
+	}
+	return out, nil
+}
+
+// LocalChecks are the node-local checks the agent reports on /healthz: memory and disk
+// pressure against used-share thresholds, plus operator scripts.
+type LocalChecks struct {
+	usage           *UsageSampler
+	memoryThreshold float64
+	diskThreshold   float64
+	scripts         []Script
+	timeout         time.Duration
+}
+
+// NewLocalChecks reports pressure when more than the given share (0..1) of memory or of
+// the data directory's disk is used; a threshold of 0 disables that check.
+func NewLocalChecks(usage *UsageSampler, memoryThreshold, diskThreshold float64, scripts []Script) *LocalChecks {
+	return &LocalChecks{usage: usage, memoryThreshold: memoryThreshold, diskThreshold: diskThreshold, scripts: scripts, timeout: 10 * time.Second}
+}
+
+func (c *LocalChecks) Run(ctx context.Context) []api.AgentCheck {
+	var out []api.AgentCheck
+	if mem, disk, ok := c.usage.UsedFractions(); ok {
+		if c.memoryThreshold > 0 {
+			out = append(out, pressureCheck("memory", api.NodeMemoryPressure, mem, c.memoryThreshold))
+		}
+		if c.diskThreshold > 0 {
+			out = append(out, pressureCheck("disk", api.NodeDiskPressure, disk, c.diskThreshold))
+		}
+	}
+	for _, s := range c.scripts {
+		out = append(out, c.runScript(ctx, s))
+	}
+	return out
+}
+
+func pressureCheck(name, cond string, used, threshold float64) api.AgentCheck {
+	return api.AgentCheck{Name: name, Condition: cond, OK: used <= threshold,
+		Message: fmt.Sprintf("%.0f%% used, threshold %.0f%%", used*100, threshold*100)}
+}
+
+func (c *LocalChecks) runScript(ctx context.Context, s Script) api.AgentCheck {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	ac := api.AgentCheck{Name: s.Name}
+	if s.Name == api.NodeDiskPressure || s.Name == api.NodeMemoryPressure {
+		ac.Condition = s.Name
+	}
+	b, err := exec.CommandContext(ctx, "sh", "-c", s.Command).CombinedOutput()
+	ac.OK = err == nil
+	ac.Message = lastLine(string(b))
+	if err != nil && ac.Message == "" {
+		ac.Message = err.Error()
+	}
+	return ac
+}
+
+// lastLine returns the last non-empty output line, which scripts use as their message.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+	if len(line) > 200 {
+		line = line[:200]
+	}
+	return line
+}
@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func TestLocalChecksRunScripts(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	scripts, err := ParseScripts("ntp=echo synced, DiskPressure=echo scratch full; exit 1")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	checks := NewLocalChecks(NewUsageSampler(t.TempDir()), 0, 0, scripts).Run(context.Background())
+	if len(checks) != 2 {
+		t.Fatalf("want 2 checks got %+v", checks)
+	}
+	if c := checks[0]; !c.OK || c.Message != "synced" || c.Condition != "" {
+		t.Fatalf("unexpected ntp check: %+v", c)
+	}
+	if c := checks[1]; c.OK || c.Message != "scratch full" || c.Condition != api.NodeDiskPressure {
+		t.Fatalf("unexpected pressure script: %+v", c)
+	}
+	if _, err := ParseScripts("broken"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPressureCheck(t *testing.T) {
+	if c := pressureCheck("disk", api.NodeDiskPressure, 0.95, 0.9); c.OK || c.Message != "95% used, threshold 90%" {
+		t.Fatalf("unexpected: %+v", c)
+	}
+	if c := pressureCheck("memory", api.NodeMemoryPressure, 0.5, 0.9); !c.OK {
+		t.Fatalf("unexpected: %+v", c)
+	}
+}
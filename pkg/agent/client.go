@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+// ControlPlane is the node agent's view of the cluster API.
+type ControlPlane interface {
+	// VMs returns the VMs assigned to nodeID, or every VM when nodeID is empty.
+	VMs(ctx context.Context, nodeID string) (map[string]api.VM, error)
+	ReportStatus(ctx context.Context, st api.VMStatus) error
+}
+
+// HTTPControlPlane talks to clusterd's HTTP API. Reads go to the first endpoint that
+// answers; writes are retried on every endpoint because only the leader can apply them.
+type HTTPControlPlane struct {
+	endpoints []string
+	client    *http.Client
+}
+
+// NewHTTPControlPlane takes clusterd HTTP addresses (host:port or full URLs).
+func NewHTTPControlPlane(endpoints []string) *HTTPControlPlane {
+	var eps []string
+	for _, e := range endpoints {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "://") {
+			e = "http://" + e
+		}
+		eps = append(eps, strings.TrimRight(e, "/"))
+	}
+	return &HTTPControlPlane{endpoints: eps, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *HTTPControlPlane) VMs(ctx context.Context, nodeID string) (map[string]api.VM, error) {
+	var lastErr error = fmt.Errorf("no control plane endpoints configured")
+	for _, ep := range c.endpoints {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep+"/api/vms?node="+url.QueryEscape(nodeID), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var vms map[string]api.VM
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("%s: %s", ep, resp.Status)
+		} else if lastErr = json.NewDecoder(resp.Body).Decode(&vms); lastErr == nil {
+			_ = resp.Body.Close()
+			return vms, nil
+		}
+		_ = resp.Body.Close()
+	}
+	return nil, lastErr
+}
+
+func (c *HTTPControlPlane) ReportStatus(ctx context.Context, st api.VMStatus) error {
+	body, _ := json.Marshal(st)
+	var lastErr error = fmt.Errorf("no control plane endpoints configured")
+	for _, ep := range c.endpoints {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep+"/api/vms/status", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %s", ep, resp.Status)
+	}
+	return lastErr
+}
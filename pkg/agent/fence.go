@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+)
+
+// SelfFence force-stops every local VM once the agent has lost contact with the
+// control-plane leader for longer than its timeout, so the failover controller can restart
+// them elsewhere without running them twice. While fenced the reconciler starts nothing;
+// the next successful heartbeat lifts the fence.
+type SelfFence struct {
+	rt      runtime.VMRuntime
+	timeout time.Duration
+	now     func() time.Time
+
+	mu          sync.Mutex
+	lastContact time.Time
+	fenced      bool
+}
+
+func NewSelfFence(rt runtime.VMRuntime, timeout time.Duration) *SelfFence {
+	return &SelfFence{rt: rt, timeout: timeout, now: time.Now, lastContact: time.Now()}
+}
+
+// Contact records a successful heartbeat to the leader.
+func (f *SelfFence) Contact() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fenced {
+		log.Printf("self-fence: leader contact restored")
+	}
+	f.lastContact, f.fenced = f.now(), false
+}
+
+// Fenced reports whether the node has fenced itself.
+func (f *SelfFence) Fenced() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fenced
+}
+
+// Check fences the node if leader contact was lost for longer than the timeout. It is
+// called after every failed heartbeat.
+func (f *SelfFence) Check(ctx context.Context) {
+	f.mu.Lock()
+	if f.fenced || f.now().Sub(f.lastContact) < f.timeout {
+		f.mu.Unlock()
+		return
+	}
+	f.fenced = true
+	f.mu.Unlock()
+
+	log.Printf("self-fence: no leader contact for %s, stopping all vms", f.timeout)
+	vms, err := f.rt.List(ctx)
+	if err != nil {
+		log.Printf("self-fence: list vms: %v", err)
+		return
+	}
+	for _, st := range vms {
+		if err := f.rt.Stop(ctx, st.VMID, runtime.StopOptions{Force: true}); err != nil {
+			log.Printf("self-fence: stop vm %s: %v", st.VMID, err)
+		}
+	}
+}
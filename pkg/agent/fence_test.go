@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/agent/runtime/mock"
+)
+
+func TestSelfFenceStopsVMsAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	rt := mock.New()
+	if err := rt.Start(ctx, runtime.Spec{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	f := NewSelfFence(rt, time.Minute)
+	f.now = func() time.Time { return now }
+	f.Contact()
+
+	now = now.Add(30 * time.Second)
+	f.Check(ctx)
+	if f.Fenced() {
+		t.Fatal("fenced before the timeout")
+	}
+	now = now.Add(time.Minute)
+	f.Check(ctx)
+	if vms, _ := rt.List(ctx); !f.Fenced() || len(vms) != 0 {
+		t.Fatalf("want fenced with vm stopped, got fenced=%v vms=%+v", f.Fenced(), vms)
+	}
+
+	r := NewReconciler("n1", rt, nil).WithFence(f)
+	r.reconcileOnce(ctx) // nil control plane: must not be consulted while fenced
+	f.Contact()
+	if f.Fenced() {
+		t.Fatal("contact should lift the fence")
+	}
+}
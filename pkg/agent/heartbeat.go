@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	nodepb "clustering/api/proto/node"
+	"clustering/pkg/agent/runtime"
+)
+
+// Heartbeater periodically reports this node's usage and VM inventory to the leader
+// over the NodeService gRPC API, trying each control-plane address in turn.
+type Heartbeater struct {
+	nodeID    string
+	rt        runtime.VMRuntime
+	usage     *UsageSampler
+	endpoints []string
+	interval  time.Duration
+	started   time.Time
+	clients   map[string]nodepb.NodeServiceClient
+	fence     *SelfFence
+}
+
+func NewHeartbeater(nodeID string, rt runtime.VMRuntime, usage *UsageSampler, grpcEndpoints []string) *Heartbeater {
+	var eps []string
+	for _, e := range grpcEndpoints {
+		if e = strings.TrimSpace(e); e != "" {
+			eps = append(eps, e)
+		}
+	}
+	return &Heartbeater{nodeID: nodeID, rt: rt, usage: usage, endpoints: eps, interval: 10 * time.Second, started: time.Now(), clients: map[string]nodepb.NodeServiceClient{}}
+}
+
+// WithSelfFence makes heartbeat outcomes drive f: successful sends record leader contact
+// and failed ones may trigger the self-fence.
+func (h *Heartbeater) WithSelfFence(f *SelfFence) *Heartbeater {
+	h.fence = f
+	return h
+}
+
+func (h *Heartbeater) Run(stop <-chan struct{}) {
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			err := h.send(context.Background())
+			if err != nil {
+				log.Printf("heartbeat: %v", err)
+			}
+			if h.fence != nil {
+				if err == nil {
+					h.fence.Contact()
+				} else {
+					h.fence.Check(context.Background())
+				}
+			}
+			t.Reset(h.interval)
+		}
+	}
+}
+
+func (h *Heartbeater) request(ctx context.Context) *nodepb.HeartbeatRequest {
+	caps := h.rt.Capabilities()
+	req := &nodepb.HeartbeatRequest{NodeId: h.nodeID, DriverVersion: caps.Driver + "/" + caps.Version, UptimeSeconds: int64(time.Since(h.started) / time.Second)}
+	u := h.usage.Sample()
+	req.Usage = &nodepb.Usage{CpuMillis: int32(u.CPU), MemoryMib: int32(u.Memory), DiskGib: int32(u.Disk)}
+	if vms, err := h.rt.List(ctx); err == nil {
+		for _, st := range vms {
+			if st.State == runtime.StateRunning || st.State == runtime.StatePaused {
+				req.RunningVms = append(req.RunningVms, st.VMID)
+			}
+		}
+	}
+	return req
+}
+
+func (h *Heartbeater) client(ep string) (nodepb.NodeServiceClient, error) {
+	if c, ok := h.clients[ep]; ok {
+		return c, nil
+	}
+	cc, err := grpc.NewClient(ep, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	c := nodepb.NewNodeServiceClient(cc)
+	h.clients[ep] = c
+	return c, nil
+}
+
+// send delivers one heartbeat, adopting the interval the control plane asks for.
+func (h *Heartbeater) send(ctx context.Context) error {
+	req := h.request(ctx)
+	var lastErr error = fmt.Errorf("no control plane gRPC endpoints configured")
+	for _, ep := range h.endpoints {
+		c, err := h.client(ep)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		resp, err := c.Heartbeat(cctx, req)
+		cancel()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", ep, err)
+			continue
+		}
+		if resp.IntervalSeconds > 0 {
+			h.interval = time.Duration(resp.IntervalSeconds) * time.Second
+		}
+		return nil
+	}
+	return lastErr
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	nodepb "clustering/api/proto/node"
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/agent/runtime/mock"
+)
+
+type fakeNodeService struct {
+	leader bool
+	got    []*nodepb.HeartbeatRequest
+}
+
+func (f *fakeNodeService) ListNodes(context.Context, *nodepb.Empty) (*nodepb.ListNodesResponse, error) {
+	return &nodepb.ListNodesResponse{}, nil
+}
+
+func (f *fakeNodeService) Heartbeat(_ context.Context, req *nodepb.HeartbeatRequest) (*nodepb.HeartbeatResponse, error) {
+	if !f.leader {
+		return nil, status.Error(codes.Unavailable, "not leader")
+	}
+	f.got = append(f.got, req)
+	return &nodepb.HeartbeatResponse{IntervalSeconds: 7}, nil
+}
+
+func serveNodeService(t *testing.T, svc nodepb.NodeServiceServer) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	nodepb.RegisterNodeServiceServer(srv, svc)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestHeartbeatReachesLeader(t *testing.T) {
+	follower, leader := &fakeNodeService{}, &fakeNodeService{leader: true}
+	eps := []string{serveNodeService(t, follower), serveNodeService(t, leader)}
+
+	rt := mock.New()
+	_ = rt.Start(context.Background(), runtime.Spec{ID: "vm1"})
+	h := NewHeartbeater("n1", rt, NewUsageSampler(t.TempDir()), eps)
+	if err := h.send(context.Background()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if len(leader.got) != 1 {
+		t.Fatalf("leader got %d heartbeats", len(leader.got))
+	}
+	hb := leader.got[0]
+	if hb.NodeId != "n1" || len(hb.RunningVms) != 1 || hb.RunningVms[0] != "vm1" || hb.DriverVersion != "mock/1.0" || hb.Usage == nil {
+		t.Fatalf("unexpected heartbeat: %+v", hb)
+	}
+	if h.interval.Seconds() != 7 {
+		t.Fatalf("interval not adopted: %v", h.interval)
+	}
+}
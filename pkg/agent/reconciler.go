@@ -0,0 +1,293 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/api"
+)
+
+// Reconciler converges the VMs assigned to this node onto the local VMRuntime and
+// reports the observed phase back to the control plane.
+type Reconciler struct {
+	nodeID   string
+	rt       runtime.VMRuntime
+	cp       ControlPlane
+	interval time.Duration
+	// opTimeout bounds every runtime call so a hung driver cannot stall reconciliation.
+	opTimeout time.Duration
+	fence     *SelfFence
+}
+
+func NewReconciler(nodeID string, rt runtime.VMRuntime, cp ControlPlane) *Reconciler {
+	return &Reconciler{nodeID: nodeID, rt: rt, cp: cp, interval: 3 * time.Second, opTimeout: 30 * time.Second}
+}
+
+// WithOpTimeout overrides the per-operation runtime timeout.
+func (r *Reconciler) WithOpTimeout(d time.Duration) *Reconciler {
+	r.opTimeout = d
+	return r
+}
+
+// WithFence pauses reconciliation while f has the node self-fenced.
+func (r *Reconciler) WithFence(f *SelfFence) *Reconciler {
+	r.fence = f
+	return r
+}
+
+func (r *Reconciler) Run(stop <-chan struct{}) {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			r.reconcileOnce(context.Background())
+		}
+	}
+}
+
+// Running reports whether the runtime currently runs (or holds paused) the VM.
+func (r *Reconciler) Running(vmID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opTimeout)
+	defer cancel()
+	st, err := r.rt.Status(ctx, vmID)
+	return err == nil && (st.State == runtime.StateRunning || st.State == runtime.StatePaused)
+}
+
+func (r *Reconciler) reconcileOnce(ctx context.Context) {
+	if r.fence != nil && r.fence.Fenced() {
+		return
+	}
+	lctx, cancel := context.WithTimeout(ctx, r.opTimeout)
+	list, err := r.rt.List(lctx)
+	cancel()
+	if err != nil {
+		log.Printf("reconcile: list runtime vms: %v", err)
+		return
+	}
+	observed := map[string]runtime.Status{}
+	for _, st := range list {
+		observed[st.VMID] = st
+	}
+	assigned, err := r.cp.VMs(ctx, r.nodeID)
+	if err != nil {
+		log.Printf("reconcile: list vms: %v", err)
+		return
+	}
+	ids := make([]string, 0, len(assigned))
+	var all map[string]api.VM
+	for id, vm := range assigned {
+		ids = append(ids, id)
+		if len(vm.Restart.DependsOn) > 0 && all == nil {
+			// start order needs the phase of VMs on other nodes
+			if all, err = r.cp.VMs(ctx, ""); err != nil {
+				log.Printf("reconcile: list all vms: %v", err)
+				return
+			}
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		var st *runtime.Status
+		if o, ok := observed[id]; ok {
+			st = &o
+		}
+		r.converge(ctx, assigned[id], st, all)
+	}
+	r.releaseOrphans(ctx, assigned, observed)
+}
+
+// converge drives one assigned VM (observed is nil when the runtime does not know it)
+// to its desired state and reports a changed status.
+func (r *Reconciler) converge(ctx context.Context, vm api.VM, observed *runtime.Status, all map[string]api.VM) {
+	octx, cancel := context.WithTimeout(ctx, r.opTimeout)
+	defer cancel()
+	st := api.VMStatus{VMID: vm.ID, NodeID: r.nodeID}
+	if vm.Status != nil {
+		st.Restarts, st.LastRestartAt = vm.Status.Restarts, vm.Status.LastRestartAt
+	}
+	if vm.DesiredState == "Stopped" || r.admit(octx, vm, observed, all, &st) {
+		if observed != nil && runtime.Down(observed.State) {
+			observed = nil // admitted for a restart
+		}
+		st.Phase, st.Message = r.apply(octx, vm, observed)
+	}
+	if prev := vm.Status; prev != nil && vm.Phase == st.Phase && prev.Message == st.Message && prev.Reason == st.Reason && prev.Restarts == st.Restarts {
+		return
+	}
+	st.ObservedAt = time.Now().UTC()
+	if err := r.cp.ReportStatus(ctx, st); err != nil {
+		log.Printf("reconcile: report %s %s: %v", vm.ID, st.Phase, err)
+	}
+}
+
+// admit applies the VM's restart policy and start order before it is (re)started. It
+// returns false, with the status to report in st, when the VM must stay down for now; a
+// restart it admits is counted in st.
+func (r *Reconciler) admit(ctx context.Context, vm api.VM, observed *runtime.Status, all map[string]api.VM, st *api.VMStatus) bool {
+	if observed != nil && !runtime.Down(observed.State) {
+		return true
+	}
+	pol := vm.Restart
+	if observed == nil {
+		// a VM given up on earlier stays down until its status is reset
+		if prev := vm.Status; prev != nil && prev.NodeID == r.nodeID && (prev.Reason == api.VMReasonRestartPolicy || prev.Reason == api.VMReasonRestartLimit) {
+			st.Phase, st.Message, st.Reason = vm.Phase, prev.Message, prev.Reason
+			return false
+		}
+	}
+	failed := observed != nil && observed.State == runtime.StateCrashed
+	phase, msg := "Stopped", "exited"
+	switch {
+	case observed == nil:
+		phase = "Pending"
+	case failed:
+		phase, msg = "Failed", "exited unexpectedly"
+		if observed.Message != "" {
+			msg += ": " + observed.Message
+		}
+	}
+	if waiting := dependenciesDown(vm, all); len(waiting) > 0 {
+		st.Phase, st.Message, st.Reason = phase, "waiting for "+strings.Join(waiting, ", ")+" to run", api.VMReasonWaitingForDeps
+		return false
+	}
+	if observed == nil {
+		return true
+	}
+	st.Phase, st.Message = phase, msg
+	switch {
+	case !pol.RestartsAfter(failed):
+		st.Message, st.Reason = msg+"; not restarted (restart policy "+pol.Policy+")", api.VMReasonRestartPolicy
+	case pol.Exhausted(st.Restarts):
+		st.Message, st.Reason = fmt.Sprintf("%s; not restarted after %d restarts", msg, st.Restarts), api.VMReasonRestartLimit
+	default:
+		next := st.LastRestartAt.Add(pol.Backoff(st.Restarts))
+		if vm.Phase == phase && !time.Now().Before(next) {
+			st.Restarts++
+			st.LastRestartAt = time.Now().UTC()
+			return true
+		}
+		// report the exit first; the VM is started again on a later pass
+		if time.Now().Before(next) {
+			st.Message, st.Reason = msg+"; restarting after "+next.UTC().Format(time.RFC3339), api.VMReasonBackOff
+		}
+		return false
+	}
+	// given up: release the VM so the runtime does not hold or restart it
+	if err := r.stop(ctx, vm); err != nil {
+		log.Printf("reconcile: release exited %s: %v", vm.ID, err)
+	}
+	return false
+}
+
+// dependenciesDown returns the VMs the given one depends on that are not Running; unknown
+// VMs are ignored.
+func dependenciesDown(vm api.VM, all map[string]api.VM) []string {
+	var out []string
+	for _, id := range vm.Restart.DependsOn {
+		if dep, ok := all[id]; ok && dep.Phase != "Running" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// apply performs at most a few runtime calls for one VM and returns the phase to report.
+func (r *Reconciler) apply(ctx context.Context, vm api.VM, observed *runtime.Status) (phase, msg string) {
+	if vm.DesiredState == "Stopped" {
+		if observed != nil {
+			if err := r.stop(ctx, vm); err != nil {
+				return "Failed", "stop: " + err.Error()
+			}
+		}
+		return "Stopped", ""
+	}
+	if observed == nil {
+		if err := r.rt.Start(ctx, runtime.SpecFor(vm)); err != nil {
+			return "Failed", "start: " + err.Error()
+		}
+		observed = &runtime.Status{VMID: vm.ID, State: runtime.StateRunning, Resources: vm.Limit()}
+	}
+
+	caps := r.rt.Capabilities()
+	if vm.DesiredState == "Paused" {
+		if observed.State != runtime.StatePaused {
+			if !caps.Pause {
+				return "Running", "pause: " + runtime.ErrUnsupported.Error()
+			}
+			if err := r.rt.Pause(ctx, vm.ID); err != nil {
+				return "Failed", "pause: " + err.Error()
+			}
+		}
+		return "Paused", ""
+	}
+	if observed.State == runtime.StatePaused {
+		if err := r.rt.Resume(ctx, vm.ID); err != nil {
+			return "Failed", "resume: " + err.Error()
+		}
+	}
+	if observed.Resources != vm.Limit() {
+		if !caps.Resize {
+			return "Running", "resize pending: runtime cannot resize a running VM"
+		}
+		if err := r.rt.Resize(ctx, vm.ID, vm.Limit()); err != nil {
+			return "Running", "resize: " + err.Error()
+		}
+	}
+	return "Running", ""
+}
+
+// stop stops the VM according to its stop policy. A graceful timeout that does not fit
+// in opTimeout extends the call, so the runtime gets to kill the VM itself.
+func (r *Reconciler) stop(ctx context.Context, vm api.VM) error {
+	opts := runtime.StopOptionsFor(vm)
+	if !opts.Force && opts.Timeout >= r.opTimeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), opts.Timeout+r.opTimeout)
+		defer cancel()
+	}
+	return r.rt.Stop(ctx, vm.ID, opts)
+}
+
+// releaseOrphans hands off VMs the runtime holds that are assigned elsewhere: a VM that is
+// migrating is migrated to its new node, anything else (deleted or reassigned) is stopped.
+func (r *Reconciler) releaseOrphans(ctx context.Context, assigned map[string]api.VM, observed map[string]runtime.Status) {
+	var orphans []string
+	for id := range observed {
+		if _, ok := assigned[id]; !ok {
+			orphans = append(orphans, id)
+		}
+	}
+	if len(orphans) == 0 {
+		return
+	}
+	sort.Strings(orphans)
+	all, err := r.cp.VMs(ctx, "")
+	if err != nil {
+		log.Printf("reconcile: list all vms: %v", err)
+		return
+	}
+	for _, id := range orphans {
+		vm, ok := all[id]
+		if !ok {
+			vm = api.VM{ID: id}
+		}
+		octx, cancel := context.WithTimeout(ctx, r.opTimeout)
+		if ok && vm.Phase == "Migrating" && vm.NodeID != "" && !runtime.Down(observed[id].State) {
+			err = r.rt.Migrate(octx, id, vm.NodeID)
+		} else {
+			err = r.stop(octx, vm)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("reconcile: release %s: %v", id, err)
+		}
+	}
+}
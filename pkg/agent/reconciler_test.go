@@ -0,0 +1,313 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/agent/runtime/mock"
+	"clustering/pkg/api"
+)
+
+// fakeRuntime records lifecycle calls on top of the in-memory mock driver.
+type fakeRuntime struct {
+	*mock.Driver
+	calls    []string
+	startErr error
+	stops    map[string]runtime.StopOptions
+	// stopDeadlines records how long each Stop call was given
+	stopDeadlines map[string]time.Duration
+}
+
+func newFakeRuntime() *fakeRuntime {
+	return &fakeRuntime{Driver: mock.New(), stops: map[string]runtime.StopOptions{}, stopDeadlines: map[string]time.Duration{}}
+}
+
+func (f *fakeRuntime) Start(ctx context.Context, spec runtime.Spec) error {
+	f.calls = append(f.calls, "start "+spec.ID)
+	if f.startErr != nil {
+		return f.startErr
+	}
+	return f.Driver.Start(ctx, spec)
+}
+func (f *fakeRuntime) Stop(ctx context.Context, id string, opts runtime.StopOptions) error {
+	f.calls = append(f.calls, "stop "+id)
+	f.stops[id] = opts
+	if dl, ok := ctx.Deadline(); ok {
+		f.stopDeadlines[id] = time.Until(dl)
+	}
+	return f.Driver.Stop(ctx, id, opts)
+}
+func (f *fakeRuntime) Migrate(ctx context.Context, id, target string) error {
+	f.calls = append(f.calls, "migrate "+id+" "+target)
+	return f.Driver.Migrate(ctx, id, target)
+}
+func (f *fakeRuntime) Pause(ctx context.Context, id string) error {
+	f.calls = append(f.calls, "pause "+id)
+	return f.Driver.Pause(ctx, id)
+}
+func (f *fakeRuntime) Resize(ctx context.Context, id string, res api.Resources) error {
+	f.calls = append(f.calls, "resize "+id)
+	return f.Driver.Resize(ctx, id, res)
+}
+
+type fakeControlPlane struct {
+	vms     map[string]api.VM
+	reports []api.VMStatus
+}
+
+func (f *fakeControlPlane) VMs(_ context.Context, nodeID string) (map[string]api.VM, error) {
+	out := map[string]api.VM{}
+	for id, vm := range f.vms {
+		if nodeID == "" || vm.NodeID == nodeID {
+			out[id] = vm
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeControlPlane) ReportStatus(_ context.Context, st api.VMStatus) error {
+	f.reports = append(f.reports, st)
+	vm := f.vms[st.VMID]
+	vm.Phase, vm.Status = st.Phase, &st
+	f.vms[st.VMID] = vm
+	return nil
+}
+
+func TestReconcileStartsAssignedAndReportsOnce(t *testing.T) {
+	rt := newFakeRuntime()
+	cp := &fakeControlPlane{vms: map[string]api.VM{
+		"a": {ID: "a", NodeID: "n1", Phase: "Pending"},
+		"b": {ID: "b", NodeID: "n2", Phase: "Pending"},
+	}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+	r.reconcileOnce(context.Background())
+
+	if len(rt.calls) != 1 || rt.calls[0] != "start a" {
+		t.Fatalf("unexpected runtime calls: %v", rt.calls)
+	}
+	if len(cp.reports) != 1 || cp.reports[0].Phase != "Running" || cp.reports[0].NodeID != "n1" {
+		t.Fatalf("unexpected reports: %+v", cp.reports)
+	}
+	if !r.Running("a") || r.Running("b") {
+		t.Fatal("running set does not match assignments")
+	}
+}
+
+func TestReconcileStopsAndMigrates(t *testing.T) {
+	rt := newFakeRuntime()
+	cp := &fakeControlPlane{vms: map[string]api.VM{
+		"a": {ID: "a", NodeID: "n1"},
+		"b": {ID: "b", NodeID: "n1"},
+		"c": {ID: "c", NodeID: "n1"},
+	}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+
+	cp.vms["a"] = api.VM{ID: "a", NodeID: "n1", Phase: "Running", DesiredState: "Stopped"}
+	cp.vms["b"] = api.VM{ID: "b", NodeID: "n2", Phase: "Migrating"}
+	delete(cp.vms, "c")
+	rt.calls = nil
+	r.reconcileOnce(context.Background())
+
+	want := []string{"stop a", "migrate b n2", "stop c"}
+	if len(rt.calls) != len(want) {
+		t.Fatalf("want %v got %v", want, rt.calls)
+	}
+	for i := range want {
+		if rt.calls[i] != want[i] {
+			t.Fatalf("want %v got %v", want, rt.calls)
+		}
+	}
+	if cp.vms["a"].Phase != "Stopped" || r.Running("b") || r.Running("c") {
+		t.Fatalf("unexpected state: %+v", cp.vms)
+	}
+}
+
+func TestReconcileStopsWithStopPolicy(t *testing.T) {
+	rt := newFakeRuntime()
+	cp := &fakeControlPlane{vms: map[string]api.VM{
+		"a": {ID: "a", NodeID: "n1"},
+		"b": {ID: "b", NodeID: "n1"},
+		"c": {ID: "c", NodeID: "n1"},
+		"d": {ID: "d", NodeID: "n1"},
+	}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+
+	cp.vms["a"] = api.VM{ID: "a", NodeID: "n1", DesiredState: "Stopped", Stop: api.StopPolicy{Force: true}}
+	cp.vms["b"] = api.VM{ID: "b", NodeID: "n1", DesiredState: "Stopped", Stop: api.StopPolicy{TimeoutSeconds: 120}}
+	cp.vms["c"] = api.VM{ID: "c", NodeID: "n2", Stop: api.StopPolicy{TimeoutSeconds: 5}}
+	cp.vms["d"] = api.VM{ID: "d", NodeID: "n1", DesiredState: "Stopped", Stop: api.StopPolicy{TimeoutSeconds: 3600}}
+	r.reconcileOnce(context.Background())
+
+	if opts := rt.stops["a"]; !opts.Force {
+		t.Fatalf("a: want a forced stop, got %+v", opts)
+	}
+	if opts := rt.stops["b"]; opts.Force || opts.Timeout != 2*time.Minute || rt.stopDeadlines["b"] < 2*time.Minute {
+		t.Fatalf("b: want 2m graceful stop with time to finish, got %+v within %v", opts, rt.stopDeadlines["b"])
+	}
+	if opts := rt.stops["c"]; opts.Timeout != 5*time.Second {
+		t.Fatalf("c: reassigned vm must be stopped with its policy, got %+v", opts)
+	}
+	// a long graceful stop must not hold up the reconcile pass for an hour
+	if opts := rt.stops["d"]; opts.Timeout != api.MaxStopTimeoutSeconds*time.Second {
+		t.Fatalf("d: want the timeout capped, got %+v", opts)
+	}
+}
+
+func TestReconcileReportsStartFailure(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.startErr = errors.New("no image")
+	cp := &fakeControlPlane{vms: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Phase: "Pending"}}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+	if cp.vms["a"].Phase != "Failed" || cp.vms["a"].Status.Message != "start: no image" || r.Running("a") {
+		t.Fatalf("unexpected vm: %+v", cp.vms["a"])
+	}
+}
+
+func TestReconcileReportsCrashAndRestarts(t *testing.T) {
+	rt := mock.New()
+	cp := &fakeControlPlane{vms: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Phase: "Scheduled"}}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+	_ = rt.Crash("a")
+
+	r.reconcileOnce(context.Background())
+	if vm := cp.vms["a"]; vm.Phase != "Failed" || !strings.HasPrefix(vm.Status.Message, "exited unexpectedly") {
+		t.Fatalf("crash not reported: %+v", vm)
+	}
+	r.reconcileOnce(context.Background())
+	if vm := cp.vms["a"]; vm.Phase != "Running" || !r.Running("a") {
+		t.Fatalf("vm not restarted: %+v", vm)
+	}
+}
+
+func TestReconcileTimesOutHungStart(t *testing.T) {
+	rt := mock.New()
+	_ = rt.SetFaults(mock.Faults{Ops: map[string]mock.OpFault{mock.OpStart: {HangRate: 1}}})
+	cp := &fakeControlPlane{vms: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Phase: "Scheduled"}}}
+	r := NewReconciler("n1", rt, cp).WithOpTimeout(20 * time.Millisecond)
+	r.reconcileOnce(context.Background())
+	if vm := cp.vms["a"]; vm.Phase != "Failed" || r.Running("a") {
+		t.Fatalf("hung start not failed: %+v", vm)
+	}
+}
+
+func TestReconcilePausesAndResizes(t *testing.T) {
+	rt := newFakeRuntime()
+	cp := &fakeControlPlane{vms: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Resources: api.Resources{CPU: 500}}}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+
+	cp.vms["a"] = api.VM{ID: "a", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 1000}}
+	r.reconcileOnce(context.Background())
+	if st, _ := rt.Status(context.Background(), "a"); st.Resources.CPU != 1000 {
+		t.Fatalf("not resized: %+v", st)
+	}
+
+	vm := cp.vms["a"]
+	vm.DesiredState = "Paused"
+	cp.vms["a"] = vm
+	r.reconcileOnce(context.Background())
+	if st, _ := rt.Status(context.Background(), "a"); st.State != runtime.StatePaused || cp.vms["a"].Phase != "Paused" {
+		t.Fatalf("not paused: %+v / %+v", st, cp.vms["a"])
+	}
+	want := []string{"start a", "resize a", "pause a"}
+	if len(rt.calls) != len(want) || rt.calls[1] != want[1] || rt.calls[2] != want[2] {
+		t.Fatalf("want %v got %v", want, rt.calls)
+	}
+}
+
+func TestReconcileEnforcesLimits(t *testing.T) {
+	rt := newFakeRuntime()
+	cp := &fakeControlPlane{vms: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Resources: api.Resources{CPU: 500, Memory: 256}, Limits: &api.Resources{CPU: 2000}}}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+	if st, _ := rt.Status(context.Background(), "a"); st.Resources.CPU != 2000 || st.Resources.Memory != 256 {
+		t.Fatalf("want the cpu limit and the memory request, got %+v", st.Resources)
+	}
+	r.reconcileOnce(context.Background())
+	if len(rt.calls) != 1 {
+		t.Fatalf("limits differing from requests must not cause resizes: %v", rt.calls)
+	}
+}
+
+func TestReconcileAppliesRestartPolicy(t *testing.T) {
+	rt := mock.New()
+	cp := &fakeControlPlane{vms: map[string]api.VM{
+		"always": {ID: "always", NodeID: "n1"},
+		"onfail": {ID: "onfail", NodeID: "n1", Restart: api.RestartPolicy{Policy: api.RestartOnFailure}},
+		"never":  {ID: "never", NodeID: "n1", Restart: api.RestartPolicy{Policy: api.RestartNever}},
+	}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+	_ = rt.Exit("always")
+	_ = rt.Exit("onfail")
+	_ = rt.Crash("never")
+	for i := 0; i < 3; i++ {
+		r.reconcileOnce(context.Background())
+	}
+	if vm := cp.vms["always"]; vm.Phase != "Running" || vm.Status.Restarts != 1 || !r.Running("always") {
+		t.Fatalf("always: want restarted once, got %+v", vm.Status)
+	}
+	if vm := cp.vms["onfail"]; vm.Phase != "Stopped" || vm.Status.Reason != api.VMReasonRestartPolicy || r.Running("onfail") {
+		t.Fatalf("onfail: clean exit must not restart, got %+v", vm.Status)
+	}
+	if vm := cp.vms["never"]; vm.Phase != "Failed" || vm.Status.Reason != api.VMReasonRestartPolicy || r.Running("never") {
+		t.Fatalf("never: want failed and down, got %+v", vm.Status)
+	}
+}
+
+func TestReconcileRestartBackoffAndLimit(t *testing.T) {
+	rt := mock.New()
+	cp := &fakeControlPlane{vms: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Restart: api.RestartPolicy{MaxRestarts: 2, BackoffSeconds: 3600}}}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+	_ = rt.Crash("a")
+	r.reconcileOnce(context.Background())
+	r.reconcileOnce(context.Background()) // first restart is immediate
+	_ = rt.Crash("a")
+	r.reconcileOnce(context.Background())
+	r.reconcileOnce(context.Background())
+	if vm := cp.vms["a"]; vm.Status.Reason != api.VMReasonBackOff || vm.Status.Restarts != 1 || r.Running("a") {
+		t.Fatalf("want back-off after the first restart, got %+v", vm.Status)
+	}
+
+	vm := cp.vms["a"]
+	vm.Status.LastRestartAt = time.Now().Add(-2 * time.Hour)
+	cp.vms["a"] = vm
+	r.reconcileOnce(context.Background())
+	_ = rt.Crash("a")
+	for i := 0; i < 3; i++ {
+		r.reconcileOnce(context.Background())
+	}
+	if vm := cp.vms["a"]; vm.Status.Reason != api.VMReasonRestartLimit || vm.Status.Restarts != 2 || r.Running("a") {
+		t.Fatalf("want restart limit after 2 restarts, got %+v", vm.Status)
+	}
+}
+
+func TestReconcileWaitsForDependencies(t *testing.T) {
+	rt := newFakeRuntime()
+	cp := &fakeControlPlane{vms: map[string]api.VM{
+		"app": {ID: "app", NodeID: "n1", Restart: api.RestartPolicy{DependsOn: []string{"db"}}},
+		"db":  {ID: "db", NodeID: "n2", Phase: "Scheduled"},
+	}}
+	r := NewReconciler("n1", rt, cp)
+	r.reconcileOnce(context.Background())
+	if vm := cp.vms["app"]; len(rt.calls) != 0 || vm.Status.Reason != api.VMReasonWaitingForDeps {
+		t.Fatalf("started before db: calls %v status %+v", rt.calls, vm.Status)
+	}
+	db := cp.vms["db"]
+	db.Phase = "Running"
+	cp.vms["db"] = db
+	r.reconcileOnce(context.Background())
+	if vm := cp.vms["app"]; vm.Phase != "Running" || vm.Status.Reason != "" {
+		t.Fatalf("app not started after db: %+v", vm.Status)
+	}
+}
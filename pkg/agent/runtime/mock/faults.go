@@ -0,0 +1,182 @@
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Operations that faults can target.
+const (
+	OpStart   = "start"
+	OpStop    = "stop"
+	OpMigrate = "migrate"
+	OpPause   = "pause"
+	OpResume  = "resume"
+	OpReboot  = "reboot"
+	OpResize  = "resize"
+)
+
+// Step results for per-VM scripts.
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+	ResultHang  = "hang"
+	ResultCrash = "crash" // the operation succeeds and the VM crashes Delay later
+)
+
+// Duration is a time.Duration that reads and writes JSON as "250ms", "2s", ...
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Latency describes how long an operation takes. Distribution is fixed (Mean), uniform
+// (Min..Max), normal (Mean, StdDev) or exponential (Mean); Max caps every distribution.
+type Latency struct {
+	Distribution string   `json:"distribution,omitempty"`
+	Mean         Duration `json:"mean,omitempty"`
+	Min          Duration `json:"min,omitempty"`
+	Max          Duration `json:"max,omitempty"`
+	StdDev       Duration `json:"stdDev,omitempty"`
+}
+
+// OpFault applies to every call of one operation.
+type OpFault struct {
+	Latency   Latency `json:"latency"`
+	ErrorRate float64 `json:"errorRate,omitempty"` // 0..1
+	HangRate  float64 `json:"hangRate,omitempty"`  // 0..1; a hung call blocks until its context ends
+	Error     string  `json:"error,omitempty"`     // message for injected errors
+}
+
+// Step is one scripted outcome for a VM. Steps are consumed in order by the next call
+// of a matching operation (any operation when Op is empty) and override OpFault.
+type Step struct {
+	Op     string   `json:"op,omitempty"`
+	Result string   `json:"result"`
+	Delay  Duration `json:"delay,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Faults configures the mock runtime. The zero value injects nothing.
+type Faults struct {
+	// Seed makes random decisions reproducible; 0 uses the current time.
+	Seed int64              `json:"seed,omitempty"`
+	Ops  map[string]OpFault `json:"ops,omitempty"`
+	// MeanTimeToCrash makes each started VM crash after an exponentially distributed delay.
+	MeanTimeToCrash Duration          `json:"meanTimeToCrash,omitempty"`
+	Scripts         map[string][]Step `json:"scripts,omitempty"`
+}
+
+// Validate reports configuration errors before the faults are installed.
+func (f Faults) Validate() error {
+	for op, of := range f.Ops {
+		switch op {
+		case OpStart, OpStop, OpMigrate, OpPause, OpResume, OpReboot, OpResize:
+		default:
+			return fmt.Errorf("unknown op %q", op)
+		}
+		if of.ErrorRate < 0 || of.ErrorRate > 1 || of.HangRate < 0 || of.HangRate > 1 {
+			return fmt.Errorf("op %s: rates must be within [0,1]", op)
+		}
+		switch of.Latency.Distribution {
+		case "", "fixed", "uniform", "normal", "exponential":
+		default:
+			return fmt.Errorf("op %s: unknown latency distribution %q", op, of.Latency.Distribution)
+		}
+	}
+	for vm, steps := range f.Scripts {
+		for _, s := range steps {
+			switch s.Result {
+			case ResultOK, ResultError, ResultHang, ResultCrash:
+			default:
+				return fmt.Errorf("script %s: unknown result %q", vm, s.Result)
+			}
+		}
+	}
+	return nil
+}
+
+// outcome is what one call should do.
+type outcome struct {
+	delay      time.Duration
+	hang       bool
+	err        error
+	crashAfter time.Duration // >0 schedules a crash after a successful start
+}
+
+func (l Latency) sample(rng *rand.Rand) time.Duration {
+	var d time.Duration
+	switch l.Distribution {
+	case "uniform":
+		if l.Max > l.Min {
+			d = time.Duration(l.Min) + time.Duration(rng.Int63n(int64(l.Max-l.Min)))
+		} else {
+			d = time.Duration(l.Min)
+		}
+	case "normal":
+		d = time.Duration(float64(l.Mean) + rng.NormFloat64()*float64(l.StdDev))
+	case "exponential":
+		d = time.Duration(rng.ExpFloat64() * float64(l.Mean))
+	default:
+		d = time.Duration(l.Mean)
+	}
+	if d < 0 {
+		d = 0
+	}
+	if l.Max > 0 && d > time.Duration(l.Max) {
+		d = time.Duration(l.Max)
+	}
+	return d
+}
+
+func (of OpFault) decide(rng *rand.Rand, op string) outcome {
+	o := outcome{delay: of.Latency.sample(rng)}
+	switch {
+	case of.HangRate > 0 && rng.Float64() < of.HangRate:
+		o.hang = true
+	case of.ErrorRate > 0 && rng.Float64() < of.ErrorRate:
+		o.err = injected(op, of.Error)
+	}
+	return o
+}
+
+func (s Step) outcome(op string) outcome {
+	o := outcome{delay: time.Duration(s.Delay)}
+	switch s.Result {
+	case ResultError:
+		o.err = injected(op, s.Error)
+	case ResultHang:
+		o.hang, o.delay = true, 0
+	case ResultCrash:
+		o.delay, o.crashAfter = 0, time.Duration(s.Delay)
+		if o.crashAfter <= 0 {
+			o.crashAfter = time.Nanosecond
+		}
+	}
+	return o
+}
+
+// ErrInjected wraps every error produced by fault injection.
+var ErrInjected = errors.New("injected fault")
+
+func injected(op, msg string) error {
+	if msg == "" {
+		msg = op + " failed"
+	}
+	return fmt.Errorf("%w: %s", ErrInjected, msg)
+}
@@ -2,22 +2,286 @@ package mock
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/api"
 )
 
-type Driver struct{}
+type vm struct {
+	status runtime.Status
+	crash  *time.Timer // pending simulated crash, if any
+}
 
-func New() *Driver { return &Driver{} }
+// Driver is an in-memory VMRuntime. By default every operation succeeds instantly;
+// SetFaults injects latency, errors, hangs, crashes and per-VM scripted outcomes.
+type Driver struct {
+	mu      sync.Mutex
+	faults  Faults
+	rng     *rand.Rand
+	scripts map[string][]Step
+	vms     map[string]*vm
+	crashes map[string]int
+}
 
-func (d *Driver) Start(ctx context.Context, vmID string) error {
-	log.Printf("mock start vm %s", vmID)
+func New() *Driver {
+	d := &Driver{vms: map[string]*vm{}, crashes: map[string]int{}}
+	_ = d.SetFaults(Faults{})
+	return d
+}
+
+// SetFaults replaces the fault configuration and restarts scripts and the random source.
+func (d *Driver) SetFaults(f Faults) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	seed := f.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	scripts := map[string][]Step{}
+	for vm, steps := range f.Scripts {
+		scripts[vm] = append([]Step(nil), steps...)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.faults, d.rng, d.scripts = f, rand.New(rand.NewSource(seed)), scripts
 	return nil
 }
-func (d *Driver) Stop(ctx context.Context, vmID string) error {
-	log.Printf("mock stop vm %s", vmID)
+
+// Faults returns the active fault configuration.
+func (d *Driver) Faults() Faults {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.faults
+}
+
+// decide consumes the next matching script step for the VM or rolls the op's random faults.
+func (d *Driver) decide(op, vmID string) outcome {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	steps := d.scripts[vmID]
+	for i, s := range steps {
+		if s.Op == "" || s.Op == op {
+			d.scripts[vmID] = append(steps[:i:i], steps[i+1:]...)
+			return s.outcome(op)
+		}
+	}
+	o := d.faults.Ops[op].decide(d.rng, op)
+	if op == OpStart && d.faults.MeanTimeToCrash > 0 {
+		o.crashAfter = time.Duration(d.rng.ExpFloat64() * float64(d.faults.MeanTimeToCrash))
+	}
+	return o
+}
+
+// inject waits out the chosen latency or hang and returns the injected error, if any.
+func (d *Driver) inject(ctx context.Context, op, vmID string) (outcome, error) {
+	o := d.decide(op, vmID)
+	if o.hang {
+		log.Printf("mock %s vm %s: hanging", op, vmID)
+		<-ctx.Done()
+		return o, ctx.Err()
+	}
+	if o.delay > 0 {
+		t := time.NewTimer(o.delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return o, ctx.Err()
+		}
+	}
+	if o.err != nil {
+		log.Printf("mock %s vm %s: %v", op, vmID, o.err)
+	}
+	return o, o.err
+}
+
+// lookup returns a known VM; callers hold d.mu.
+func (d *Driver) lookup(vmID string) (*vm, error) {
+	v, ok := d.vms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", runtime.ErrNotFound, vmID)
+	}
+	return v, nil
+}
+
+func (d *Driver) Start(ctx context.Context, spec runtime.Spec) error {
+	o, err := d.inject(ctx, OpStart, spec.ID)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if v, ok := d.vms[spec.ID]; ok && !runtime.Down(v.status.State) {
+		return nil
+	}
+	log.Printf("mock start vm %s", spec.ID)
+	v := &vm{status: runtime.Status{VMID: spec.ID, State: runtime.StateRunning, Resources: spec.Resources, StartedAt: time.Now().UTC()}}
+	if prev, ok := d.vms[spec.ID]; ok {
+		v.status.Restarts = prev.status.Restarts + 1
+	}
+	if o.crashAfter > 0 {
+		v.crash = time.AfterFunc(o.crashAfter, func() { _ = d.Crash(spec.ID) })
+	}
+	d.vms[spec.ID] = v
+	return nil
+}
+
+func (d *Driver) Stop(ctx context.Context, vmID string, opts runtime.StopOptions) error {
+	if _, err := d.inject(ctx, OpStop, vmID); err != nil {
+		return err
+	}
+	log.Printf("mock stop vm %s (force=%v)", vmID, opts.Force)
+	d.remove(vmID)
 	return nil
 }
+
 func (d *Driver) Migrate(ctx context.Context, vmID string, targetNode string) error {
+	if _, err := d.inject(ctx, OpMigrate, vmID); err != nil {
+		return err
+	}
 	log.Printf("mock migrate vm %s -> %s", vmID, targetNode)
+	d.remove(vmID)
 	return nil
 }
+
+func (d *Driver) remove(vmID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if v, ok := d.vms[vmID]; ok && v.crash != nil {
+		v.crash.Stop()
+	}
+	delete(d.vms, vmID)
+}
+
+// transition moves a VM from one of the allowed states to a new state after fault injection.
+func (d *Driver) transition(ctx context.Context, op, vmID, to string, from ...string) error {
+	if _, err := d.inject(ctx, op, vmID); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(vmID)
+	if err != nil {
+		return err
+	}
+	for _, s := range from {
+		if v.status.State == s {
+			log.Printf("mock %s vm %s", op, vmID)
+			v.status.State = to
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot %s vm %s in state %s", op, vmID, v.status.State)
+}
+
+func (d *Driver) Pause(ctx context.Context, vmID string) error {
+	return d.transition(ctx, OpPause, vmID, runtime.StatePaused, runtime.StateRunning, runtime.StatePaused)
+}
+
+func (d *Driver) Resume(ctx context.Context, vmID string) error {
+	return d.transition(ctx, OpResume, vmID, runtime.StateRunning, runtime.StatePaused, runtime.StateRunning)
+}
+
+func (d *Driver) Reboot(ctx context.Context, vmID string) error {
+	if err := d.transition(ctx, OpReboot, vmID, runtime.StateRunning, runtime.StateRunning, runtime.StatePaused); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.vms[vmID].status.StartedAt = time.Now().UTC()
+	return nil
+}
+
+func (d *Driver) Resize(ctx context.Context, vmID string, res api.Resources) error {
+	if _, err := d.inject(ctx, OpResize, vmID); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(vmID)
+	if err != nil {
+		return err
+	}
+	log.Printf("mock resize vm %s to %+v", vmID, res)
+	v.status.Resources = res
+	return nil
+}
+
+func (d *Driver) Console(ctx context.Context, vmID string) (io.ReadCloser, error) {
+	st, err := d.Status(ctx, vmID)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(fmt.Sprintf("mock console %s: %s since %s\n", vmID, st.State, st.StartedAt.Format(time.RFC3339)))), nil
+}
+
+func (d *Driver) Status(ctx context.Context, vmID string) (runtime.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(vmID)
+	if err != nil {
+		return runtime.Status{}, err
+	}
+	return v.status, nil
+}
+
+func (d *Driver) List(ctx context.Context) ([]runtime.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]runtime.Status, 0, len(d.vms))
+	for _, v := range d.vms {
+		out = append(out, v.status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].VMID < out[j].VMID })
+	return out, nil
+}
+
+// Capabilities: the mock supports every operation.
+func (d *Driver) Capabilities() runtime.Capabilities {
+	return runtime.Capabilities{Driver: "mock", Version: "1.0", Pause: true, Reboot: true, Resize: true, LiveMigrate: true, Console: true}
+}
+
+// Crash makes a running VM exit unexpectedly, as a guest or hypervisor failure would.
+func (d *Driver) Crash(vmID string) error {
+	return d.exit(vmID, runtime.StateCrashed, "simulated crash")
+}
+
+// Exit makes a running VM shut down cleanly, as a guest powering itself off would.
+func (d *Driver) Exit(vmID string) error {
+	return d.exit(vmID, runtime.StateExited, "")
+}
+
+func (d *Driver) exit(vmID, state, msg string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(vmID)
+	if err != nil || runtime.Down(v.status.State) {
+		return err
+	}
+	if v.crash != nil {
+		v.crash.Stop()
+	}
+	v.status.State = state
+	v.status.Message = msg
+	if state == runtime.StateCrashed {
+		d.crashes[vmID]++
+	}
+	log.Printf("mock %s vm %s", strings.ToLower(state), vmID)
+	return nil
+}
+
+// Crashes returns how many times the VM has crashed.
+func (d *Driver) Crashes(vmID string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.crashes[vmID]
+}
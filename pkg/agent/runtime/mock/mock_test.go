@@ -0,0 +1,103 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+)
+
+func TestSeededErrorRateIsReproducible(t *testing.T) {
+	run := func() []bool {
+		d := New()
+		if err := d.SetFaults(Faults{Seed: 42, Ops: map[string]OpFault{OpStart: {ErrorRate: 0.5}}}); err != nil {
+			t.Fatalf("set faults: %v", err)
+		}
+		var out []bool
+		for i := 0; i < 20; i++ {
+			out = append(out, d.Start(context.Background(), runtime.Spec{ID: "vm"}) == nil)
+		}
+		return out
+	}
+	a, b := run(), run()
+	failures := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("run differs at %d: %v vs %v", i, a, b)
+		}
+		if !a[i] {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(a) {
+		t.Fatalf("error rate 0.5 produced %d/%d failures", failures, len(a))
+	}
+}
+
+func TestHangHonoursContext(t *testing.T) {
+	d := New()
+	_ = d.SetFaults(Faults{Ops: map[string]OpFault{OpStop: {HangRate: 1}}})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Stop(ctx, "vm", runtime.StopOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+}
+
+func TestScriptedStepsAndCrash(t *testing.T) {
+	var f Faults
+	if err := json.Unmarshal([]byte(`{"scripts": {"vm1": [
+		{"op": "start", "result": "error", "error": "disk missing"},
+		{"op": "start", "result": "crash", "delay": "10ms"}
+	]}}`), &f); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	d := New()
+	if err := d.SetFaults(f); err != nil {
+		t.Fatalf("set faults: %v", err)
+	}
+	if err := d.Start(context.Background(), runtime.Spec{ID: "vm1"}); !errors.Is(err, ErrInjected) {
+		t.Fatalf("first start should fail, got %v", err)
+	}
+	if err := d.Start(context.Background(), runtime.Spec{ID: "vm1"}); err != nil {
+		t.Fatalf("second start: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for d.Crashes("vm1") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("vm1 never crashed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sts, _ := d.List(context.Background()); len(sts) != 1 || sts[0].State != runtime.StateCrashed {
+		t.Fatalf("crashed vm not reported: %+v", sts)
+	}
+	// script exhausted: later starts succeed
+	if err := d.Start(context.Background(), runtime.Spec{ID: "vm1"}); err != nil {
+		t.Fatalf("third start: %v", err)
+	}
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	d := New()
+	_ = d.SetFaults(Faults{Seed: 1})
+	for _, l := range []Latency{
+		{Mean: Duration(5 * time.Millisecond)},
+		{Distribution: "uniform", Min: Duration(time.Millisecond), Max: Duration(3 * time.Millisecond)},
+		{Distribution: "normal", Mean: Duration(2 * time.Millisecond), StdDev: Duration(time.Millisecond), Max: Duration(4 * time.Millisecond)},
+		{Distribution: "exponential", Mean: Duration(time.Millisecond), Max: Duration(10 * time.Millisecond)},
+	} {
+		for i := 0; i < 50; i++ {
+			v := l.sample(d.rng)
+			if v < 0 || (l.Max > 0 && v > time.Duration(l.Max)) || (l.Distribution == "uniform" && v < time.Duration(l.Min)) {
+				t.Fatalf("%s sample %v out of range", l.Distribution, v)
+			}
+		}
+	}
+	if err := (Faults{Ops: map[string]OpFault{"snapshot": {}}}).Validate(); err == nil {
+		t.Fatal("expected unknown op error")
+	}
+}
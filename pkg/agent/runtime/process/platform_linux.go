@@ -0,0 +1,84 @@
+//go:build linux
+
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"clustering/pkg/api"
+)
+
+// sysProcAttr puts each VM in its own process group so signals reach its children too.
+func sysProcAttr() *syscall.SysProcAttr { return &syscall.SysProcAttr{Setpgid: true} }
+
+// signalsSupported reports whether pause/resume via job-control signals work here.
+const signalsSupported = true
+
+func terminate(pid int) error { return syscall.Kill(-pid, syscall.SIGTERM) }
+func kill(pid int) error      { return syscall.Kill(-pid, syscall.SIGKILL) }
+func pause(pid int) error     { return syscall.Kill(-pid, syscall.SIGSTOP) }
+func resume(pid int) error    { return syscall.Kill(-pid, syscall.SIGCONT) }
+
+// alive reports whether pid still leads its own process group, as VM processes do; a
+// reused pid belongs to some other group.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	pgid, err := syscall.Getpgid(pid)
+	return err == nil && pgid == pid
+}
+
+const cgroupPeriod = 100000 // µs
+
+// applyCgroup creates <root>/<vmID>, sets cpu.max and memory.max from the VM resources and
+// moves pid into it. It returns the group path, or "" when cgroup v2 is unavailable.
+func applyCgroup(root, vmID string, pid int, res api.Resources) (string, error) {
+	if root == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	dir := filepath.Join(root, vmID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := setCgroupLimits(dir, res); err != nil {
+		return dir, err
+	}
+	return dir, writeCgroup(dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// setCgroupLimits writes cpu.max and memory.max; zero values mean unlimited.
+func setCgroupLimits(dir string, res api.Resources) error {
+	cpu := "max"
+	if res.CPU > 0 {
+		cpu = strconv.Itoa(res.CPU * cgroupPeriod / 1000)
+	}
+	if err := writeCgroup(dir, "cpu.max", cpu+" "+strconv.Itoa(cgroupPeriod)); err != nil {
+		return err
+	}
+	mem := "max"
+	if res.Memory > 0 {
+		mem = strconv.Itoa(res.Memory << 20)
+	}
+	return writeCgroup(dir, "memory.max", mem)
+}
+
+func writeCgroup(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644)
+}
+
+func removeCgroup(dir string) {
+	if dir != "" {
+		_ = os.Remove(dir)
+	}
+}
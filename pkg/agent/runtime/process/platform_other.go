@@ -0,0 +1,43 @@
+//go:build !linux
+
+package process
+
+import (
+	"os"
+	"syscall"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/api"
+)
+
+func sysProcAttr() *syscall.SysProcAttr { return nil }
+
+const signalsSupported = false
+
+func pause(pid int) error  { return runtime.ErrUnsupported }
+func resume(pid int) error { return nil }
+
+func terminate(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(os.Interrupt)
+}
+
+// alive is always false: without process groups a recorded pid cannot be told apart
+// from a reused one, so processes are never adopted.
+func alive(pid int) bool { return false }
+
+func kill(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+// cgroup limits are Linux-only.
+func applyCgroup(root, vmID string, pid int, res api.Resources) (string, error) { return "", nil }
+func setCgroupLimits(dir string, res api.Resources) error                       { return runtime.ErrUnsupported }
+func removeCgroup(dir string)                                                   {}
@@ -0,0 +1,514 @@
+// Package process implements a VMRuntime that runs each "VM" as a supervised child
+// process. It needs no hypervisor, which makes it useful for end-to-end tests.
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/api"
+)
+
+// Config selects what to run and where to keep per-VM state.
+type Config struct {
+	// StateDir holds one directory per VM with state.json, stdout.log and stderr.log.
+	StateDir string
+	// Commands maps a template BaseImage to the argv to run; DefaultCommand is used otherwise.
+	Commands       map[string][]string
+	DefaultCommand []string
+	// CgroupRoot is the cgroup v2 directory under which per-VM groups are created.
+	// Limits are skipped when it is empty or cgroup v2 is not available.
+	CgroupRoot string
+	// RestartBackoff is the initial delay before restarting an exited process; it doubles
+	// up to MaxRestartBackoff.
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+	// NoRestart leaves exited processes down so the caller applies its own restart policy;
+	// Start runs them again.
+	NoRestart bool
+	// StopTimeout is how long Stop waits after SIGTERM before killing the process.
+	StopTimeout time.Duration
+}
+
+// State is the persisted view of one VM process.
+type State struct {
+	VMID         string        `json:"vmId"`
+	Image        string        `json:"image"`
+	Resources    api.Resources `json:"resources"`
+	Command      []string      `json:"command"`
+	PID          int           `json:"pid"`
+	Running      bool          `json:"running"`
+	Paused       bool          `json:"paused,omitempty"`
+	Restarts     int           `json:"restarts"`
+	LastExitCode int           `json:"lastExitCode"`
+	LastError    string        `json:"lastError,omitempty"`
+	StartedAt    time.Time     `json:"startedAt"`
+	ExitedAt     time.Time     `json:"exitedAt,omitempty"`
+	Cgroup       string        `json:"cgroup,omitempty"`
+}
+
+type proc struct {
+	spec      runtime.Spec
+	state     State
+	cmd       *exec.Cmd     // nil for a process adopted from a previous driver
+	rebooting bool          // the next exit is a requested reboot: restart at once
+	stop      chan struct{} // closed by Stop to end supervision
+	done      chan struct{} // closed when the supervisor exits
+}
+
+type Driver struct {
+	cfg Config
+
+	mu    sync.Mutex
+	procs map[string]*proc
+}
+
+func New(cfg Config) (*Driver, error) {
+	if cfg.StateDir == "" {
+		return nil, errors.New("process runtime: state dir required")
+	}
+	if len(cfg.DefaultCommand) == 0 {
+		cfg.DefaultCommand = []string{"sleep", "infinity"}
+	}
+	if cfg.RestartBackoff <= 0 {
+		cfg.RestartBackoff = time.Second
+	}
+	if cfg.MaxRestartBackoff < cfg.RestartBackoff {
+		cfg.MaxRestartBackoff = 30 * time.Second
+	}
+	if cfg.StopTimeout <= 0 {
+		cfg.StopTimeout = 10 * time.Second
+	}
+	if err := os.MkdirAll(cfg.StateDir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &Driver{cfg: cfg, procs: map[string]*proc{}}
+	d.adopt()
+	return d, nil
+}
+
+// adoptPoll is how often an adopted process is checked for exit.
+const adoptPoll = 500 * time.Millisecond
+
+// adopt takes over the VM processes a previous driver left running: VM process groups
+// outlive the agent, and starting them again would run each VM twice. A process is
+// adopted when state.json records it as running and its process group still exists.
+func (d *Driver) adopt() {
+	paths, _ := filepath.Glob(filepath.Join(d.cfg.StateDir, "*", "state.json"))
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		var st State
+		if err != nil || json.Unmarshal(b, &st) != nil || st.VMID == "" || !st.Running {
+			continue
+		}
+		if !alive(st.PID) {
+			removeCgroup(st.Cgroup)
+			continue
+		}
+		log.Printf("process runtime: adopting %s (pid %d)", st.VMID, st.PID)
+		p := &proc{spec: runtime.Spec{ID: st.VMID, Image: st.Image, Resources: st.Resources}, state: st, stop: make(chan struct{}), done: make(chan struct{})}
+		d.procs[st.VMID] = p
+		go d.supervise(p)
+	}
+}
+
+func (d *Driver) command(image string) []string {
+	if c, ok := d.cfg.Commands[image]; ok && len(c) > 0 {
+		return c
+	}
+	return d.cfg.DefaultCommand
+}
+
+func (d *Driver) Start(ctx context.Context, spec runtime.Spec) error {
+	vmID := spec.ID
+	d.mu.Lock()
+	if p, ok := d.procs[vmID]; ok {
+		select {
+		case <-p.done:
+		default:
+			// still supervised (running or waiting to restart)
+			d.mu.Unlock()
+			return nil
+		}
+	}
+	p := &proc{spec: spec, stop: make(chan struct{}), done: make(chan struct{})}
+	p.state = State{VMID: vmID, Image: spec.Image, Resources: spec.Resources, Command: d.command(spec.Image)}
+	if prev, ok := d.procs[vmID]; ok {
+		p.state.Restarts = prev.state.Restarts
+	}
+	d.procs[vmID] = p
+	d.mu.Unlock()
+
+	if err := os.MkdirAll(d.vmDir(vmID), 0o755); err != nil {
+		return err
+	}
+	if err := d.spawn(p); err != nil {
+		d.mu.Lock()
+		p.state.LastError = err.Error()
+		d.mu.Unlock()
+		d.persist(p)
+		close(p.done)
+		return err
+	}
+	go d.supervise(p)
+	return nil
+}
+
+// spawn starts the process with output appended to the VM's log files.
+func (d *Driver) spawn(p *proc) error {
+	dir := d.vmDir(p.state.VMID)
+	stdout, err := os.OpenFile(filepath.Join(dir, "stdout.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	stderr, err := os.OpenFile(filepath.Join(dir, "stderr.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer stderr.Close()
+
+	argv := p.state.Command
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.Env = append(os.Environ(),
+		"VM_ID="+p.state.VMID,
+		"VM_IMAGE="+p.spec.Image,
+		"VM_CPU="+strconv.Itoa(p.spec.Resources.CPU),
+		"VM_MEMORY="+strconv.Itoa(p.spec.Resources.Memory),
+		"VM_STATE_DIR="+dir,
+	)
+	cmd.SysProcAttr = sysProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	cg, err := applyCgroup(d.cfg.CgroupRoot, p.state.VMID, cmd.Process.Pid, p.spec.Resources)
+	if err != nil {
+		log.Printf("process runtime: cgroup for %s: %v", p.state.VMID, err)
+	}
+
+	d.mu.Lock()
+	p.cmd = cmd
+	p.state.PID = cmd.Process.Pid
+	p.state.Running = true
+	p.state.Paused = false
+	p.state.StartedAt = time.Now().UTC()
+	p.state.Cgroup = cg
+	d.mu.Unlock()
+	d.persist(p)
+	select {
+	case <-p.stop:
+		// Stop raced with a restart; it did not see this pid.
+		_ = terminate(cmd.Process.Pid)
+	default:
+	}
+	return nil
+}
+
+// wait blocks until the process exits and returns its exit code. An adopted process is
+// not a child of this driver, so it is polled and its exit status is unknown (-1).
+func (d *Driver) wait(p *proc) (int, error) {
+	if p.cmd != nil {
+		err := p.cmd.Wait()
+		return p.cmd.ProcessState.ExitCode(), err
+	}
+	for alive(p.state.PID) {
+		time.Sleep(adoptPoll)
+	}
+	return -1, errors.New("adopted process exited")
+}
+
+// supervise waits for the process and restarts it with exponential backoff until Stop.
+func (d *Driver) supervise(p *proc) {
+	defer close(p.done)
+	backoff := d.cfg.RestartBackoff
+	for {
+		code, err := d.wait(p)
+		d.mu.Lock()
+		p.state.Running = false
+		p.state.PID = 0
+		p.state.ExitedAt = time.Now().UTC()
+		p.state.LastExitCode = code
+		p.state.LastError = ""
+		if err != nil {
+			p.state.LastError = err.Error()
+		}
+		d.mu.Unlock()
+		d.persist(p)
+
+		d.mu.Lock()
+		reboot := p.rebooting
+		p.rebooting = false
+		d.mu.Unlock()
+		if !reboot && d.cfg.NoRestart {
+			removeCgroup(p.state.Cgroup)
+			return
+		}
+		if !reboot {
+			select {
+			case <-p.stop:
+				removeCgroup(p.state.Cgroup)
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > d.cfg.MaxRestartBackoff {
+				backoff = d.cfg.MaxRestartBackoff
+			}
+			d.mu.Lock()
+			p.state.Restarts++
+			d.mu.Unlock()
+		}
+		if err := d.spawn(p); err != nil {
+			log.Printf("process runtime: restart %s: %v", p.state.VMID, err)
+			d.mu.Lock()
+			p.state.LastError = err.Error()
+			d.mu.Unlock()
+			d.persist(p)
+			return
+		}
+	}
+}
+
+func (d *Driver) Stop(ctx context.Context, vmID string, opts runtime.StopOptions) error {
+	d.mu.Lock()
+	p, ok := d.procs[vmID]
+	if !ok {
+		d.mu.Unlock()
+		return nil
+	}
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	var pid int
+	if p.state.Running {
+		pid = p.state.PID
+	}
+	d.mu.Unlock()
+
+	timeout := d.cfg.StopTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	if pid != 0 {
+		if opts.Force {
+			_ = kill(pid)
+		} else {
+			_ = resume(pid) // a stopped process cannot handle SIGTERM
+			_ = terminate(pid)
+		}
+	}
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		if pid != 0 {
+			_ = kill(pid)
+		}
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	d.mu.Lock()
+	if d.procs[vmID] == p {
+		delete(d.procs, vmID)
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// Migrate stops the local process; the target node's agent starts it from the same spec.
+// Process state cannot be transferred, so this is a cold migration.
+func (d *Driver) Migrate(ctx context.Context, vmID string, targetNode string) error {
+	log.Printf("process runtime: cold-migrating %s to %s", vmID, targetNode)
+	return d.Stop(ctx, vmID, runtime.StopOptions{})
+}
+
+// status converts process state; callers hold d.mu.
+func (p *proc) status() runtime.Status {
+	st := runtime.Status{VMID: p.state.VMID, Resources: p.spec.Resources, StartedAt: p.state.StartedAt, Restarts: p.state.Restarts, Message: p.state.LastError}
+	switch {
+	case p.state.Running && p.state.Paused:
+		st.State = runtime.StatePaused
+	case p.state.Running:
+		st.State = runtime.StateRunning
+	case !p.state.ExitedAt.IsZero() && p.state.LastExitCode == 0 && p.state.LastError == "":
+		st.State = runtime.StateExited
+	default:
+		st.State = runtime.StateCrashed // exited and waiting to restart, or failed to spawn
+	}
+	return st
+}
+
+func (d *Driver) Status(ctx context.Context, vmID string) (runtime.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.procs[vmID]
+	if !ok {
+		return runtime.Status{}, fmt.Errorf("%w: %s", runtime.ErrNotFound, vmID)
+	}
+	return p.status(), nil
+}
+
+func (d *Driver) List(ctx context.Context) ([]runtime.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]runtime.Status, 0, len(d.procs))
+	for _, p := range d.procs {
+		out = append(out, p.status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].VMID < out[j].VMID })
+	return out, nil
+}
+
+// running returns the pid of a running VM process.
+func (d *Driver) running(vmID string) (*proc, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.procs[vmID]
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s", runtime.ErrNotFound, vmID)
+	}
+	if !p.state.Running {
+		return nil, 0, fmt.Errorf("vm %s is not running", vmID)
+	}
+	return p, p.state.PID, nil
+}
+
+// Pause freezes the process group with SIGSTOP.
+func (d *Driver) Pause(ctx context.Context, vmID string) error {
+	p, pid, err := d.running(vmID)
+	if err != nil {
+		return err
+	}
+	if err := pause(pid); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	p.state.Paused = true
+	d.mu.Unlock()
+	d.persist(p)
+	return nil
+}
+
+func (d *Driver) Resume(ctx context.Context, vmID string) error {
+	p, pid, err := d.running(vmID)
+	if err != nil {
+		return err
+	}
+	if err := resume(pid); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	p.state.Paused = false
+	d.mu.Unlock()
+	d.persist(p)
+	return nil
+}
+
+// Reboot terminates the process; the supervisor starts it again without backoff.
+func (d *Driver) Reboot(ctx context.Context, vmID string) error {
+	p, pid, err := d.running(vmID)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	p.rebooting = true
+	d.mu.Unlock()
+	_ = resume(pid)
+	return terminate(pid)
+}
+
+// Resize rewrites the VM's cgroup limits; it needs cgroup v2.
+func (d *Driver) Resize(ctx context.Context, vmID string, res api.Resources) error {
+	p, _, err := d.running(vmID)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	cg := p.state.Cgroup
+	d.mu.Unlock()
+	if cg == "" {
+		return runtime.ErrUnsupported
+	}
+	if err := setCgroupLimits(cg, res); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	p.spec.Resources = res
+	d.mu.Unlock()
+	return nil
+}
+
+// Console returns the VM's captured stdout.
+func (d *Driver) Console(ctx context.Context, vmID string) (io.ReadCloser, error) {
+	d.mu.Lock()
+	_, ok := d.procs[vmID]
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", runtime.ErrNotFound, vmID)
+	}
+	return os.Open(filepath.Join(d.vmDir(vmID), "stdout.log"))
+}
+
+func (d *Driver) Capabilities() runtime.Capabilities {
+	return runtime.Capabilities{Driver: "process", Version: "1.0", Pause: signalsSupported, Reboot: true, Resize: signalsSupported && d.cfg.CgroupRoot != "", Console: true}
+}
+
+// State returns the current state of a VM process.
+func (d *Driver) State(vmID string) (State, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.procs[vmID]
+	if !ok {
+		return State{}, false
+	}
+	return p.state, true
+}
+
+func (d *Driver) vmDir(vmID string) string { return filepath.Join(d.cfg.StateDir, vmID) }
+
+func (d *Driver) persist(p *proc) {
+	d.mu.Lock()
+	b, _ := json.MarshalIndent(p.state, "", "  ")
+	d.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(d.vmDir(p.state.VMID), "state.json"), b, 0o644); err != nil {
+		log.Printf("process runtime: persist %s: %v", p.state.VMID, err)
+	}
+}
+
+// ParseCommands parses "image=command args,image2=command" into a Commands map.
+func ParseCommands(s string) (map[string][]string, error) {
+	out := map[string][]string{}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		image, cmd, ok := strings.Cut(part, "=")
+		image = strings.TrimSpace(image)
+		argv := strings.Fields(cmd)
+		if !ok || image == "" || len(argv) == 0 {
+			return nil, fmt.Errorf("invalid process command %q (want image=command)", part)
+		}
+		out[image] = argv
+	}
+	return out, nil
+}
@@ -0,0 +1,193 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/api"
+)
+
+var _ runtime.VMRuntime = (*Driver)(nil)
+
+func newDriver(t *testing.T, cmds map[string][]string) *Driver {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	d, err := New(Config{StateDir: t.TempDir(), Commands: cmds, DefaultCommand: []string{"sleep", "60"}, RestartBackoff: 10 * time.Millisecond, StopTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return d
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartCapturesOutputAndStops(t *testing.T) {
+	d := newDriver(t, map[string][]string{"echo": {"sh", "-c", `echo "hello $VM_ID"; exec sleep 60`}})
+	if err := d.Start(context.Background(), runtime.Spec{ID: "vm1", Image: "echo"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	st, ok := d.State("vm1")
+	if !ok || !st.Running || st.PID == 0 {
+		t.Fatalf("not running: %+v", st)
+	}
+	logPath := filepath.Join(d.cfg.StateDir, "vm1", "stdout.log")
+	waitFor(t, func() bool {
+		b, _ := os.ReadFile(logPath)
+		return strings.Contains(string(b), "hello vm1")
+	})
+
+	if err := d.Stop(context.Background(), "vm1", runtime.StopOptions{}); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if _, err := d.Status(context.Background(), "vm1"); !errors.Is(err, runtime.ErrNotFound) {
+		t.Fatalf("stopped vm still known: %v", err)
+	}
+	var persisted State
+	b, err := os.ReadFile(filepath.Join(d.cfg.StateDir, "vm1", "state.json"))
+	if err != nil || json.Unmarshal(b, &persisted) != nil || persisted.Running {
+		t.Fatalf("state.json not updated: %s (%v)", b, err)
+	}
+}
+
+func TestExitedProcessIsRestarted(t *testing.T) {
+	d := newDriver(t, map[string][]string{"crash": {"sh", "-c", "exit 3"}})
+	if err := d.Start(context.Background(), runtime.Spec{ID: "vm1", Image: "crash"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitFor(t, func() bool {
+		st, _ := d.State("vm1")
+		return st.Restarts >= 2
+	})
+	if st, _ := d.State("vm1"); st.LastExitCode != 3 {
+		t.Fatalf("want exit code 3 got %+v", st)
+	}
+	if err := d.Stop(context.Background(), "vm1", runtime.StopOptions{}); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestNoRestartLeavesExitedProcessDown(t *testing.T) {
+	d := newDriver(t, map[string][]string{"ok": {"sh", "-c", "exit 0"}, "crash": {"sh", "-c", "exit 3"}})
+	d.cfg.NoRestart = true
+	for _, id := range []string{"ok", "crash"} {
+		if err := d.Start(context.Background(), runtime.Spec{ID: id, Image: id}); err != nil {
+			t.Fatalf("start %s: %v", id, err)
+		}
+	}
+	waitFor(t, func() bool {
+		ok, _ := d.Status(context.Background(), "ok")
+		crash, _ := d.Status(context.Background(), "crash")
+		return ok.State == runtime.StateExited && crash.State == runtime.StateCrashed
+	})
+	time.Sleep(50 * time.Millisecond)
+	if st, _ := d.State("crash"); st.Restarts != 0 || st.Running {
+		t.Fatalf("restarted despite NoRestart: %+v", st)
+	}
+}
+
+func TestRestartedDriverAdoptsRunningProcesses(t *testing.T) {
+	if !signalsSupported {
+		t.Skip("needs process groups")
+	}
+	d := newDriver(t, nil)
+	d.cfg.NoRestart = true
+	if err := d.Start(context.Background(), runtime.Spec{ID: "vm1", Resources: api.Resources{CPU: 500}}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	before, _ := d.State("vm1")
+
+	// a new driver on the same state dir stands in for a restarted agent
+	d2, err := New(d.cfg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	st, err := d2.Status(context.Background(), "vm1")
+	if err != nil || st.State != runtime.StateRunning || st.Resources.CPU != 500 {
+		t.Fatalf("want vm1 adopted, got %+v, %v", st, err)
+	}
+	if err := d2.Start(context.Background(), runtime.Spec{ID: "vm1"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if after, _ := d2.State("vm1"); after.PID != before.PID {
+		t.Fatalf("started a second copy: pid %d, was %d", after.PID, before.PID)
+	}
+
+	if err := d2.Stop(context.Background(), "vm1", runtime.StopOptions{}); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if alive(before.PID) {
+		t.Fatalf("adopted process %d still running", before.PID)
+	}
+	// nothing left to adopt
+	d3, _ := New(d.cfg)
+	if _, err := d3.Status(context.Background(), "vm1"); !errors.Is(err, runtime.ErrNotFound) {
+		t.Fatalf("stopped vm adopted again: %v", err)
+	}
+}
+
+func TestParseCommands(t *testing.T) {
+	cmds, err := ParseCommands("ubuntu=sleep infinity, web=python3 -m http.server")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(cmds["web"]) != 3 || cmds["ubuntu"][0] != "sleep" {
+		t.Fatalf("unexpected: %v", cmds)
+	}
+	if _, err := ParseCommands("broken"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPauseResumeAndReboot(t *testing.T) {
+	if !signalsSupported {
+		t.Skip("pause needs job-control signals")
+	}
+	d := newDriver(t, nil)
+	ctx := context.Background()
+	if err := d.Start(ctx, runtime.Spec{ID: "vm1"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer d.Stop(ctx, "vm1", runtime.StopOptions{Force: true})
+	if err := d.Pause(ctx, "vm1"); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if st, _ := d.Status(ctx, "vm1"); st.State != runtime.StatePaused {
+		t.Fatalf("want paused got %+v", st)
+	}
+	if err := d.Resume(ctx, "vm1"); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	before, _ := d.State("vm1")
+	if err := d.Reboot(ctx, "vm1"); err != nil {
+		t.Fatalf("reboot: %v", err)
+	}
+	waitFor(t, func() bool {
+		st, _ := d.State("vm1")
+		return st.Running && st.PID != before.PID
+	})
+	if st, _ := d.State("vm1"); st.Restarts != 0 {
+		t.Fatalf("reboot counted as crash restart: %+v", st)
+	}
+	if err := d.Resize(ctx, "vm1", api.Resources{CPU: 100}); !errors.Is(err, runtime.ErrUnsupported) {
+		t.Fatalf("resize without cgroup should be unsupported, got %v", err)
+	}
+}
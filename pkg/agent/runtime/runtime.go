@@ -1,10 +1,136 @@
 package runtime
 
-import "context"
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"clustering/pkg/api"
+)
 
 // VMRuntime abstracts VM lifecycle operations on a node.
 type VMRuntime interface {
-	Start(ctx context.Context, vmID string) error
-	Stop(ctx context.Context, vmID string) error
+	// Start creates and boots the VM described by spec; starting a running VM is a no-op.
+	Start(ctx context.Context, spec Spec) error
+	Stop(ctx context.Context, vmID string, opts StopOptions) error
 	Migrate(ctx context.Context, vmID string, targetNode string) error
+
+	// Status returns the observed state of one VM, or ErrNotFound.
+	Status(ctx context.Context, vmID string) (Status, error)
+	// List returns every VM the runtime knows about, sorted by ID.
+	List(ctx context.Context) ([]Status, error)
+
+	Pause(ctx context.Context, vmID string) error
+	Resume(ctx context.Context, vmID string) error
+	Reboot(ctx context.Context, vmID string) error
+	// Resize changes the resources of a running VM without restarting it.
+	Resize(ctx context.Context, vmID string, res api.Resources) error
+	// Console returns the VM's console output; the caller closes it.
+	Console(ctx context.Context, vmID string) (io.ReadCloser, error)
+
+	// Capabilities reports which optional operations the driver supports; the others
+	// return ErrUnsupported.
+	Capabilities() Capabilities
+}
+
+var (
+	ErrNotFound    = errors.New("vm not found")
+	ErrUnsupported = errors.New("operation not supported by runtime")
+)
+
+// Spec describes what a runtime should run for a VM.
+type Spec struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name,omitempty"`
+	Image     string            `json:"image,omitempty"`
+	Resources api.Resources     `json:"resources"`
+	Networks  []string          `json:"networks,omitempty"`
+	Volumes   []string          `json:"volumes,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// SpecFor builds the runtime spec of a VM; the runtime enforces the VM's limits.
+func SpecFor(vm api.VM) Spec {
+	return Spec{ID: vm.ID, Name: vm.Name, Image: vm.Image, Resources: vm.Limit(), Networks: vm.Networks, Volumes: vm.Volumes, Labels: vm.Labels}
+}
+
+// StopOptions selects a graceful stop (shutdown request, then kill after Timeout) or a
+// forced one (kill immediately). A zero Timeout uses the driver default.
+type StopOptions struct {
+	Force   bool
+	Timeout time.Duration
+}
+
+// StopOptionsFor returns the stop options of a VM's stop policy; the timeout is capped
+// at api.MaxStopTimeoutSeconds.
+func StopOptionsFor(vm api.VM) StopOptions {
+	secs := min(vm.Stop.TimeoutSeconds, api.MaxStopTimeoutSeconds)
+	return StopOptions{Force: vm.Stop.Force, Timeout: time.Duration(secs) * time.Second}
+}
+
+// Observed VM states.
+const (
+	StateRunning = "Running"
+	StatePaused  = "Paused"
+	StateCrashed = "Crashed" // exited without being stopped
+	StateExited  = "Exited"  // the guest shut down cleanly without being stopped
+)
+
+// Down reports whether a VM the runtime still holds has exited on its own.
+func Down(state string) bool { return state == StateCrashed || state == StateExited }
+
+// Status is a runtime's view of one VM.
+type Status struct {
+	VMID      string        `json:"vmId"`
+	State     string        `json:"state"`
+	Resources api.Resources `json:"resources"`
+	StartedAt time.Time     `json:"startedAt,omitempty"`
+	Restarts  int           `json:"restarts,omitempty"`
+	Message   string        `json:"message,omitempty"`
+}
+
+// Capabilities identifies the driver and lists the optional operations it supports.
+type Capabilities struct {
+	Driver      string `json:"driver"`
+	Version     string `json:"version"`
+	Pause       bool   `json:"pause"` // Pause and Resume
+	Reboot      bool   `json:"reboot"`
+	Resize      bool   `json:"resize"`
+	LiveMigrate bool   `json:"liveMigrate"`
+	Console     bool   `json:"console"`
+}
+
+// Capability names as advertised in the "caps" serf tag and api.Node.Capabilities.
+const (
+	CapPause       = api.CapPause
+	CapReboot      = api.CapReboot
+	CapResize      = api.CapResize
+	CapLiveMigrate = api.CapLiveMigrate
+	CapConsole     = api.CapConsole
+)
+
+// Names returns the supported capabilities by name, sorted.
+func (c Capabilities) Names() []string {
+	var out []string
+	for name, ok := range map[string]bool{CapPause: c.Pause, CapReboot: c.Reboot, CapResize: c.Resize, CapLiveMigrate: c.LiveMigrate, CapConsole: c.Console} {
+		if ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseCapabilities reads a comma separated capability list such as the "caps" tag.
+func ParseCapabilities(s string) []string {
+	var out []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, c)
+		}
+	}
+	return out
 }
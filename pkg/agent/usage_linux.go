@@ -0,0 +1,112 @@
+//go:build linux
+
+package agent
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"clustering/pkg/api"
+)
+
+// UsageSampler measures host CPU, memory and disk usage. CPU usage is averaged over the
+// time since the previous Sample call.
+type UsageSampler struct {
+	diskPath        string
+	prevIdle, prevT uint64
+}
+
+func NewUsageSampler(diskPath string) *UsageSampler { return &UsageSampler{diskPath: diskPath} }
+
+func (u *UsageSampler) Sample() api.Resources {
+	var r api.Resources
+	if idle, total, ok := readCPUTimes(); ok {
+		if u.prevT != 0 && total > u.prevT {
+			busy := 1 - float64(idle-u.prevIdle)/float64(total-u.prevT)
+			r.CPU = int(busy * float64(runtime.NumCPU()) * 1000)
+		}
+		u.prevIdle, u.prevT = idle, total
+	}
+	totalKB, availKB := readMemInfo()
+	r.Memory = (totalKB - availKB) / 1024
+	if fs, ok := u.statfs(); ok {
+		r.Disk = int((fs.Blocks - fs.Bfree) * uint64(fs.Bsize) >> 30)
+	}
+	return r
+}
+
+// UsedFractions returns the used share (0..1) of host memory and of the disk holding the
+// data directory; ok is false when they cannot be measured.
+func (u *UsageSampler) UsedFractions() (memory, disk float64, ok bool) {
+	totalKB, availKB := readMemInfo()
+	fs, fsOK := u.statfs()
+	if totalKB == 0 || !fsOK || fs.Blocks == 0 {
+		return 0, 0, false
+	}
+	return float64(totalKB-availKB) / float64(totalKB), float64(fs.Blocks-fs.Bavail) / float64(fs.Blocks), true
+}
+
+func (u *UsageSampler) statfs() (syscall.Statfs_t, bool) {
+	var fs syscall.Statfs_t
+	err := syscall.Statfs(u.diskPath, &fs)
+	if err != nil {
+		err = syscall.Statfs("/", &fs) // data dir not created yet
+	}
+	return fs, err == nil
+}
+
+// readCPUTimes returns idle and total jiffies from the aggregate line of /proc/stat.
+func readCPUTimes() (idle, total uint64, ok bool) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return 0, 0, false
+	}
+	fields := strings.Fields(sc.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, false
+	}
+	for i, v := range fields[1:] {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		total += n
+		if i == 3 || i == 4 { // idle, iowait
+			idle += n
+		}
+	}
+	return idle, total, true
+}
+
+// readMemInfo returns MemTotal and MemAvailable in KiB.
+func readMemInfo() (totalKB, availKB int) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, _ := strconv.Atoi(fields[1])
+		switch fields[0] {
+		case "MemTotal:":
+			totalKB = v
+		case "MemAvailable:":
+			availKB = v
+		}
+	}
+	return totalKB, availKB
+}
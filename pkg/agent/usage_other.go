@@ -0,0 +1,14 @@
+//go:build !linux
+
+package agent
+
+import "clustering/pkg/api"
+
+// UsageSampler reports no usage on platforms without /proc.
+type UsageSampler struct{}
+
+func NewUsageSampler(diskPath string) *UsageSampler { return &UsageSampler{} }
+
+func (u *UsageSampler) Sample() api.Resources { return api.Resources{} }
+
+func (u *UsageSampler) UsedFractions() (memory, disk float64, ok bool) { return 0, 0, false }
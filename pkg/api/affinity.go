@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Label requirement operators.
+const (
+	LabelIn           = "In"
+	LabelNotIn        = "NotIn"
+	LabelExists       = "Exists"
+	LabelDoesNotExist = "DoesNotExist"
+)
+
+// Topology spread behaviours.
+const (
+	DoNotSchedule  = "DoNotSchedule"
+	ScheduleAnyway = "ScheduleAnyway"
+)
+
+// Matches reports whether labels satisfy the requirement.
+func (r LabelRequirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+	switch r.Operator {
+	case LabelIn:
+		return ok && slices.Contains(r.Values, v)
+	case LabelNotIn:
+		return !ok || !slices.Contains(r.Values, v)
+	case LabelExists:
+		return ok
+	case LabelDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// MatchLabels reports whether labels satisfy every requirement.
+func MatchLabels(reqs []LabelRequirement, labels map[string]string) bool {
+	for _, r := range reqs {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateRequirements(reqs []LabelRequirement) error {
+	for _, r := range reqs {
+		if r.Key == "" {
+			return fmt.Errorf("label requirement without key")
+		}
+		switch r.Operator {
+		case LabelIn, LabelNotIn:
+			if len(r.Values) == 0 {
+				return fmt.Errorf("label requirement %s %s needs values", r.Key, r.Operator)
+			}
+		case LabelExists, LabelDoesNotExist:
+		default:
+			return fmt.Errorf("unknown label operator %q", r.Operator)
+		}
+	}
+	return nil
+}
+
+func validateWeight(w int) error {
+	if w < 1 || w > 100 {
+		return fmt.Errorf("weight %d out of range 1-100", w)
+	}
+	return nil
+}
+
+// Validate checks the affinity and spread rules of a policy.
+func (p VMSchedulingPolicy) Validate() error {
+	if na := p.NodeAffinity; na != nil {
+		if err := validateRequirements(na.Required); err != nil {
+			return err
+		}
+		for _, t := range na.Preferred {
+			if err := validateWeight(t.Weight); err != nil {
+				return err
+			}
+			if err := validateRequirements(t.Match); err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range append(append([]VMAffinityTerm(nil), p.VMAffinity...), p.VMAntiAffinity...) {
+		if len(t.Selector) == 0 {
+			return fmt.Errorf("vm affinity term needs a selector")
+		}
+		if err := validateRequirements(t.Selector); err != nil {
+			return err
+		}
+		if t.Weight != 0 {
+			if err := validateWeight(t.Weight); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range p.TopologySpread {
+		if c.TopologyKey == "" || c.MaxSkew < 0 {
+			return fmt.Errorf("topology spread needs a topologyKey and maxSkew >= 0")
+		}
+		switch c.WhenUnsatisfiable {
+		case "", DoNotSchedule, ScheduleAnyway:
+		default:
+			return fmt.Errorf("unknown whenUnsatisfiable %q", c.WhenUnsatisfiable)
+		}
+		if err := validateRequirements(c.Selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package api
+
+// Condition returns the node's condition of the given type.
+func (n Node) Condition(t string) (NodeCondition, bool) {
+	for _, c := range n.Conditions {
+		if c.Type == t {
+			return c, true
+		}
+	}
+	return NodeCondition{}, false
+}
+
+// SetCondition adds or replaces the condition of c.Type. The previous transition time is
+// kept while the status does not change.
+func SetCondition(conds []NodeCondition, c NodeCondition) []NodeCondition {
+	out := make([]NodeCondition, 0, len(conds)+1)
+	found := false
+	for _, old := range conds {
+		if old.Type != c.Type {
+			out = append(out, old)
+			continue
+		}
+		if old.Status == c.Status {
+			c.LastTransitionTime = old.LastTransitionTime
+		}
+		out = append(out, c)
+		found = true
+	}
+	if !found {
+		out = append(out, c)
+	}
+	return out
+}
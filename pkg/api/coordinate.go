@@ -0,0 +1,36 @@
+package api
+
+import (
+	"math"
+	"time"
+)
+
+// valid reports whether every component is a finite number.
+func (c *Coordinate) valid() bool {
+	finite := func(f float64) bool { return !math.IsInf(f, 0) && !math.IsNaN(f) }
+	for _, v := range c.Vec {
+		if !finite(v) {
+			return false
+		}
+	}
+	return finite(c.Error) && finite(c.Adjustment) && finite(c.Height)
+}
+
+// RTT estimates the round-trip time to another coordinate the way serf's Vivaldi
+// implementation does; ok is false when either is missing or invalid or they have
+// different dimensionality.
+func (c *Coordinate) RTT(other *Coordinate) (time.Duration, bool) {
+	if c == nil || other == nil || len(c.Vec) != len(other.Vec) || !c.valid() || !other.valid() {
+		return 0, false
+	}
+	var sq float64
+	for i := range c.Vec {
+		d := c.Vec[i] - other.Vec[i]
+		sq += d * d
+	}
+	dist := math.Sqrt(sq) + c.Height + other.Height
+	if adjusted := dist + c.Adjustment + other.Adjustment; adjusted > 0 {
+		dist = adjusted
+	}
+	return time.Duration(dist * float64(time.Second)), true
+}
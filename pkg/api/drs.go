@@ -0,0 +1,63 @@
+package api
+
+import "fmt"
+
+// DRS automation modes.
+const (
+	// DRSManual only recommends migrations; operators apply them.
+	DRSManual = "Manual"
+	// DRSPartial applies the migrations that relieve an overloaded node and only
+	// recommends the others.
+	DRSPartial = "Partial"
+	// DRSAutomated applies every recommended migration.
+	DRSAutomated = "Automated"
+)
+
+// DRSConfig tunes the rebalancer, which evens out node CPU and memory utilisation by
+// live-migrating VMs. Zero fields take the defaults of WithDefaults.
+type DRSConfig struct {
+	Mode string `json:"mode,omitempty"`
+	// Threshold is the imbalance tolerated before migrations are recommended: the mean of
+	// the standard deviations of node CPU and memory utilisation (default 0.1).
+	Threshold float64 `json:"threshold,omitempty"`
+	// MinImprovement is how much a migration must lower the imbalance (default 0.01).
+	MinImprovement float64 `json:"minImprovement,omitempty"`
+	// MaxMigrations bounds the migrations recommended per pass and those in flight at
+	// once (default 2).
+	MaxMigrations int `json:"maxMigrations,omitempty"`
+	// HotUtilization marks a node as overloaded when its CPU or memory utilisation is
+	// above it (default 0.8).
+	HotUtilization float64 `json:"hotUtilization,omitempty"`
+}
+
+// WithDefaults fills in unset fields.
+func (c DRSConfig) WithDefaults() DRSConfig {
+	if c.Mode == "" {
+		c.Mode = DRSManual
+	}
+	if c.Threshold == 0 {
+		c.Threshold = 0.1
+	}
+	if c.MinImprovement == 0 {
+		c.MinImprovement = 0.01
+	}
+	if c.MaxMigrations == 0 {
+		c.MaxMigrations = 2
+	}
+	if c.HotUtilization == 0 {
+		c.HotUtilization = 0.8
+	}
+	return c
+}
+
+func (c DRSConfig) Validate() error {
+	switch c.Mode {
+	case "", DRSManual, DRSPartial, DRSAutomated:
+	default:
+		return fmt.Errorf("unknown drs mode %q", c.Mode)
+	}
+	if c.Threshold < 0 || c.MinImprovement < 0 || c.MaxMigrations < 0 || c.HotUtilization < 0 {
+		return fmt.Errorf("drs settings must not be negative")
+	}
+	return nil
+}
@@ -3,17 +3,30 @@ package grpcapi
 import (
 	clusterpb "clustering/api/proto/node"
 	"clustering/pkg/api"
+	"clustering/pkg/store"
 	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// HeartbeatInterval is how often node agents are asked to send heartbeats.
+const HeartbeatInterval = 10 * time.Second
+
 type fsmReader interface{ GetStateCopy() api.ClusterState }
 
 type NodeServer struct {
 	clusterpb.UnimplementedNodeServiceServer
+	st  *store.Manager
 	fsm fsmReader
 }
 
-func NewNodeServer(fsm fsmReader) *NodeServer { return &NodeServer{fsm: fsm} }
+func NewNodeServer(st *store.Manager, fsm fsmReader) *NodeServer {
+	return &NodeServer{st: st, fsm: fsm}
+}
 
 func (s *NodeServer) ListNodes(ctx context.Context, _ *clusterpb.Empty) (*clusterpb.ListNodesResponse, error) {
 	st := s.fsm.GetStateCopy()
@@ -23,3 +36,22 @@ func (s *NodeServer) ListNodes(ctx context.Context, _ *clusterpb.Empty) (*cluste
 	}
 	return resp, nil
 }
+
+// Heartbeat records a node agent's usage report. Only the leader can apply it; followers
+// answer Unavailable so the agent tries the next control-plane server.
+func (s *NodeServer) Heartbeat(ctx context.Context, req *clusterpb.HeartbeatRequest) (*clusterpb.HeartbeatResponse, error) {
+	if req.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id required")
+	}
+	hb := api.NodeHeartbeat{NodeID: req.NodeId, Time: time.Now().UTC(), RunningVMs: req.RunningVms, DriverVersion: req.DriverVersion, UptimeSeconds: req.UptimeSeconds}
+	if u := req.Usage; u != nil {
+		hb.Usage = api.Resources{CPU: int(u.CpuMillis), Memory: int(u.MemoryMib), Disk: int(u.DiskGib)}
+	}
+	if err := s.st.Apply(ctx, store.NewCommand("NodeHeartbeat", hb)); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &clusterpb.HeartbeatResponse{IntervalSeconds: int32(HeartbeatInterval / time.Second)}, nil
+}
@@ -0,0 +1,45 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	nodepb "clustering/api/proto/node"
+	"clustering/pkg/api"
+	"clustering/pkg/store"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestNodeServiceOverGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	fsm := &fakeFSM{st: api.ClusterState{Nodes: map[string]api.Node{"n1": {ID: "n1", Status: "Alive"}}}}
+	nodepb.RegisterNodeServiceServer(srv, NewNodeServer(store.NewManager(nil), fsm))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cc, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer cc.Close()
+	client := nodepb.NewNodeServiceClient(cc)
+
+	nodes, err := client.ListNodes(context.Background(), &nodepb.Empty{})
+	if err != nil || len(nodes.Nodes) != 1 || nodes.Nodes[0].Id != "n1" {
+		t.Fatalf("list nodes: %+v (%v)", nodes, err)
+	}
+	resp, err := client.Heartbeat(context.Background(), &nodepb.HeartbeatRequest{NodeId: "n1", Usage: &nodepb.Usage{CpuMillis: 100}})
+	if err != nil || resp.IntervalSeconds == 0 {
+		t.Fatalf("heartbeat: %+v (%v)", resp, err)
+	}
+	if _, err := client.Heartbeat(context.Background(), &nodepb.HeartbeatRequest{}); err == nil {
+		t.Fatal("expected error without node id")
+	}
+}
@@ -5,7 +5,6 @@ import (
 
 	templatepb "clustering/api/proto/template"
 	"clustering/pkg/api"
-	"clustering/pkg/scheduler"
 	"clustering/pkg/store"
 )
 
@@ -52,11 +51,8 @@ func (s *TemplateServer) Instantiate(ctx context.Context, req *templatepb.Instan
 	if !ok {
 		return &templatepb.Empty{}, nil
 	}
-	vm := api.VM{ID: req.NewId, Name: tpl.Name + "-inst", Resources: tpl.Resources, Phase: "Pending"}
-	if nid, ok := scheduler.ChooseNode(stCopy, vm); ok {
-		vm.NodeID = nid
-	}
-	if err := s.st.Apply(ctx, store.NewCommand("UpsertVM", vm)); err != nil {
+	vm := api.VM{ID: req.NewId, Name: tpl.Name + "-inst", Image: tpl.BaseImage, Resources: tpl.Resources, Phase: "Pending"}
+	if err := place(ctx, s.st, stCopy, vm); err != nil {
 		return nil, err
 	}
 	return &templatepb.Empty{}, nil
@@ -6,6 +6,10 @@ import (
 	"clustering/pkg/scheduler"
 	"clustering/pkg/store"
 	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type fsmVMReader interface{ GetStateCopy() api.ClusterState }
@@ -29,17 +33,42 @@ func (s *VMServer) ListVMs(ctx context.Context, _ *vmpb.Empty) (*vmpb.ListVMsRes
 func (s *VMServer) UpsertVM(ctx context.Context, req *vmpb.UpsertVMRequest) (*vmpb.Empty, error) {
 	v := req.Vm
 	vm := api.VM{ID: v.Id, Name: v.Name, NodeID: v.NodeId, Phase: v.Phase, Resources: api.Resources{CPU: int(v.Cpu), Memory: int(v.Memory), Disk: int(v.Disk)}}
+	state := s.fsm.GetStateCopy()
 	if vm.NodeID == "" {
-		st := s.fsm.GetStateCopy()
-		if nid, ok := scheduler.ChooseNode(st, vm); ok {
-			vm.NodeID = nid
+		if err := place(ctx, s.st, state, vm); err != nil {
+			return nil, err
 		}
+		return &vmpb.Empty{}, nil
 	}
-	if err := s.st.Apply(ctx, store.NewCommand("UpsertVM", vm)); err != nil {
+	if why := scheduler.HAAdmitStart(state, vm); why != "" {
+		return nil, status.Error(codes.ResourceExhausted, why)
+	}
+	// a VM the client places on a new node is bound like one placed by the scheduler
+	if err := s.st.Apply(ctx, store.UpsertVMCommand(state, vm)); errors.Is(err, store.ErrBindConflict) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	} else if err != nil {
 		return nil, err
 	}
 	return &vmpb.Empty{}, nil
 }
+
+// place stores vm bound to the node the scheduler picks from state. When no node fits, or
+// the node filled up since state was read, the VM is stored unplaced and left to the
+// scheduler controller. VMs refused by HA admission control are not stored.
+func place(ctx context.Context, st *store.Manager, state api.ClusterState, vm api.VM) error {
+	if why := scheduler.HAAdmitStart(state, vm); why != "" {
+		return status.Error(codes.ResourceExhausted, why)
+	}
+	if nid, ok := scheduler.ChooseNode(state, vm); ok {
+		vm.NodeID = nid
+		err := st.Apply(ctx, store.NewCommand("BindVM", vm))
+		if !errors.Is(err, store.ErrBindConflict) {
+			return err
+		}
+		vm.NodeID = ""
+	}
+	return st.Apply(ctx, store.NewCommand("UpsertVM", vm))
+}
 func (s *VMServer) DeleteVM(ctx context.Context, req *vmpb.DeleteVMRequest) (*vmpb.Empty, error) {
 	if err := s.st.Apply(ctx, store.NewCommand("DeleteVM", req.Id)); err != nil {
 		return nil, err
@@ -60,7 +89,9 @@ func (s *VMServer) Migrate(ctx context.Context, req *vmpb.MigrateRequest) (*vmpb
 		}
 	}
 	vm.Phase = "Migrating"
-	if err := s.st.Apply(ctx, store.NewCommand("UpsertVM", vm)); err != nil {
+	if err := s.st.Apply(ctx, store.NewCommand("BindVM", vm)); errors.Is(err, store.ErrBindConflict) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	} else if err != nil {
 		return nil, err
 	}
 	return &vmpb.Empty{}, nil
@@ -84,11 +115,8 @@ func (s *VMServer) CloneFromTemplate(ctx context.Context, templateId, newId stri
 	if !ok {
 		return &vmpb.Empty{}, nil
 	}
-	vm := api.VM{ID: newId, Name: tpl.Name + "-inst", Resources: tpl.Resources, Phase: "Pending"}
-	if nid, ok := scheduler.ChooseNode(st, vm); ok {
-		vm.NodeID = nid
-	}
-	if err := s.st.Apply(ctx, store.NewCommand("UpsertVM", vm)); err != nil {
+	vm := api.VM{ID: newId, Name: tpl.Name + "-inst", Image: tpl.BaseImage, Resources: tpl.Resources, Phase: "Pending"}
+	if err := place(ctx, s.st, st, vm); err != nil {
 		return nil, err
 	}
 	return &vmpb.Empty{}, nil
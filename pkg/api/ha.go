@@ -0,0 +1,49 @@
+package api
+
+import "fmt"
+
+// HA admission control policies.
+const (
+	// HAPolicyHostFailures reserves enough capacity to restart every VM after the
+	// HostFailures largest nodes fail.
+	HAPolicyHostFailures = "HostFailures"
+	// HAPolicyReserve reserves CPUPercent and MemoryPercent of the cluster's capacity.
+	HAPolicyReserve = "Reserve"
+)
+
+// HAConfig sets the admission control policy that keeps failover capacity free. New VMs,
+// and stopped VMs being started, are refused when they would use reserved capacity. An
+// empty Policy turns admission control off.
+type HAConfig struct {
+	Policy string `json:"policy,omitempty"`
+	// HostFailures is how many node failures the cluster must tolerate (default 1).
+	HostFailures int `json:"hostFailures,omitempty"`
+	// CPUPercent and MemoryPercent are the shares of capacity the Reserve policy keeps free.
+	CPUPercent    int `json:"cpuPercent,omitempty"`
+	MemoryPercent int `json:"memoryPercent,omitempty"`
+}
+
+// WithDefaults fills in unset fields.
+func (c HAConfig) WithDefaults() HAConfig {
+	if c.Policy == HAPolicyHostFailures && c.HostFailures == 0 {
+		c.HostFailures = 1
+	}
+	return c
+}
+
+func (c HAConfig) Validate() error {
+	switch c.Policy {
+	case "", HAPolicyHostFailures, HAPolicyReserve:
+	default:
+		return fmt.Errorf("unknown ha policy %q", c.Policy)
+	}
+	if c.HostFailures < 0 {
+		return fmt.Errorf("ha hostFailures must not be negative")
+	}
+	for _, pct := range []int{c.CPUPercent, c.MemoryPercent} {
+		if pct < 0 || pct > 100 {
+			return fmt.Errorf("ha reserve percentages must be between 0 and 100")
+		}
+	}
+	return nil
+}
@@ -2,10 +2,22 @@ package httphandlers
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/hashicorp/serf/serf"
+
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/agent/runtime/mock"
 	"clustering/pkg/api"
+	"clustering/pkg/controllers/drs"
+	"clustering/pkg/controllers/health"
+	"clustering/pkg/membership"
+	"clustering/pkg/scheduler"
 	"clustering/pkg/store"
 )
 
@@ -39,6 +51,34 @@ func ConfigPost(st applier) http.HandlerFunc {
 			http.Error(w, "desiredNonVoters must be >= 0", 400)
 			return
 		}
+		if cfg.HealthFailureThreshold < 0 {
+			http.Error(w, "healthFailureThreshold must be >= 0", 400)
+			return
+		}
+		if _, err := health.NewChecks(cfg.HealthChecks); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := scheduler.ValidateProfiles(cfg.SchedulerProfiles); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := cfg.Overcommit.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := cfg.DRS.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := cfg.DPM.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := cfg.HA.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
 		if err := st.Apply(r.Context(), store.NewCommand("SetConfig", cfg)); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
@@ -104,3 +144,607 @@ func VolumesPost(st applier) http.HandlerFunc {
 	}
 }
 
+// Priority classes
+func PriorityClassesGet(fsm fsmReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := fsm.GetStateCopy().PriorityClasses
+		if out == nil {
+			out = map[string]api.PriorityClass{}
+		}
+		writeJSON(w, out)
+	}
+}
+func PriorityClassesPost(st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var pc api.PriorityClass
+		if err := json.NewDecoder(r.Body).Decode(&pc); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := pc.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := st.Apply(r.Context(), store.NewCommand("UpsertPriorityClass", pc)); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(204)
+	}
+}
+
+// PriorityClassDelete removes a priority class no VM refers to.
+func PriorityClassDelete(fsm fsmReader, st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		state := fsm.GetStateCopy()
+		if _, ok := state.PriorityClasses[name]; !ok {
+			http.Error(w, "priority class not found", 404)
+			return
+		}
+		for _, vm := range state.VMs {
+			if vm.Policy.PriorityClass == name {
+				http.Error(w, "priority class in use by vm "+vm.ID, 409)
+				return
+			}
+		}
+		if err := st.Apply(r.Context(), store.NewCommand("DeletePriorityClass", name)); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(204)
+	}
+}
+
+// VMs (optionally ?node=<id>) and status reported by node agents
+func VMsGet(fsm fsmReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		node := r.URL.Query().Get("node")
+		out := map[string]api.VM{}
+		for id, vm := range fsm.GetStateCopy().VMs {
+			if node == "" || vm.NodeID == node {
+				out[id] = vm
+			}
+		}
+		writeJSON(w, out)
+	}
+}
+func VMStatusPost(st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var vs api.VMStatus
+		if err := json.NewDecoder(r.Body).Decode(&vs); err != nil || vs.VMID == "" || vs.NodeID == "" || vs.Phase == "" {
+			http.Error(w, "vmId, nodeId and phase required", 400)
+			return
+		}
+		if vs.ObservedAt.IsZero() {
+			vs.ObservedAt = time.Now().UTC()
+		}
+		if err := st.Apply(r.Context(), store.NewCommand("UpdateVMStatus", vs)); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(204)
+	}
+}
+
+// NodeTaints lists (GET) or replaces (PUT [taints]) a node's operator taints (path value
+// "id"). Condition-derived taints are listed but cannot be set.
+func NodeTaints(fsm fsmReader, st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		n, ok := fsm.GetStateCopy().Nodes[id]
+		if !ok {
+			http.Error(w, "unknown node "+id, 404)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, n.Taints)
+		case http.MethodPut:
+			var taints []api.Taint
+			if err := json.NewDecoder(r.Body).Decode(&taints); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			now := time.Now().UTC()
+			for i, t := range taints {
+				if t.Key == "" || api.IsConditionTaint(t) {
+					http.Error(w, "taint key must be set and outside "+api.TaintPrefix, 400)
+					return
+				}
+				switch t.Effect {
+				case api.TaintNoSchedule, api.TaintPreferNoSchedule, api.TaintNoExecute:
+				default:
+					http.Error(w, "unknown taint effect "+t.Effect, 400)
+					return
+				}
+				if t.TimeAdded.IsZero() {
+					taints[i].TimeAdded = now
+				}
+			}
+			if err := st.Apply(r.Context(), store.NewCommand("SetNodeTaints", api.NodeTaints{NodeID: id, Taints: taints})); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.WriteHeader(204)
+		default:
+			http.Error(w, "method not allowed", 405)
+		}
+	}
+}
+
+// Node maintenance: cordon, drain and maintenance mode (path value "id")
+
+func pathNode(w http.ResponseWriter, r *http.Request, fsm fsmReader) (api.Node, bool) {
+	id := r.PathValue("id")
+	n, ok := fsm.GetStateCopy().Nodes[id]
+	if !ok {
+		http.Error(w, "unknown node "+id, 404)
+	}
+	return n, ok
+}
+
+func applyOr500(w http.ResponseWriter, r *http.Request, st applier, cmds ...store.Command) bool {
+	for _, c := range cmds {
+		if err := st.Apply(r.Context(), c); err != nil {
+			http.Error(w, err.Error(), 500)
+			return false
+		}
+	}
+	return true
+}
+
+func startDrain(n api.Node, maxParallel int) store.Command {
+	now := time.Now().UTC()
+	return store.NewCommand("SetNodeDrain", api.DrainStatus{NodeID: n.ID, Phase: api.DrainRunning, MaxParallel: maxParallel, StartedAt: now, UpdatedAt: now})
+}
+
+// cancelDrain returns the command stopping a running drain, if there is one.
+func cancelDrain(n api.Node) []store.Command {
+	if n.Drain == nil || n.Drain.Phase != api.DrainRunning {
+		return nil
+	}
+	ds := *n.Drain
+	ds.Phase, ds.UpdatedAt = api.DrainCancelled, time.Now().UTC()
+	return []store.Command{store.NewCommand("SetNodeDrain", ds)}
+}
+
+// NodeCordon keeps new VMs off a node (POST).
+func NodeCordon(fsm fsmReader, st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, ok := pathNode(w, r, fsm)
+		if !ok {
+			return
+		}
+		if applyOr500(w, r, st, store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID, Unschedulable: true, Maintenance: n.Maintenance})) {
+			w.WriteHeader(204)
+		}
+	}
+}
+
+// NodeUncordon makes a node schedulable again and cancels a running drain (POST). A node
+// in maintenance stays cordoned until maintenance is exited.
+func NodeUncordon(fsm fsmReader, st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, ok := pathNode(w, r, fsm)
+		if !ok {
+			return
+		}
+		if n.Maintenance {
+			http.Error(w, "node "+n.ID+" is in maintenance; exit maintenance instead", 409)
+			return
+		}
+		cmds := append(cancelDrain(n), store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID}))
+		if applyOr500(w, r, st, cmds...) {
+			w.WriteHeader(204)
+		}
+	}
+}
+
+// NodeDrain cordons a node and moves its VMs elsewhere (POST {"maxParallel"}), shows the
+// drain's progress (GET) or cancels it (DELETE; the node stays cordoned).
+func NodeDrain(fsm fsmReader, st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, ok := pathNode(w, r, fsm)
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if n.Drain == nil {
+				http.Error(w, "node "+n.ID+" was never drained", 404)
+				return
+			}
+			writeJSON(w, n.Drain)
+		case http.MethodPost:
+			var req struct {
+				MaxParallel int `json:"maxParallel"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if req.MaxParallel < 0 {
+				http.Error(w, "maxParallel must be >= 0", 400)
+				return
+			}
+			if n.Drain != nil && n.Drain.Phase == api.DrainRunning {
+				http.Error(w, "node "+n.ID+" is already draining", 409)
+				return
+			}
+			if applyOr500(w, r, st,
+				store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID, Unschedulable: true, Maintenance: n.Maintenance}),
+				startDrain(n, req.MaxParallel)) {
+				w.WriteHeader(202)
+			}
+		case http.MethodDelete:
+			if applyOr500(w, r, st, cancelDrain(n)...) {
+				w.WriteHeader(204)
+			}
+		default:
+			http.Error(w, "method not allowed", 405)
+		}
+	}
+}
+
+// NodeMaintenance enters maintenance mode (POST: cordon and drain) or exits it (DELETE:
+// cancel any drain and uncordon).
+func NodeMaintenance(fsm fsmReader, st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, ok := pathNode(w, r, fsm)
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			cmds := []store.Command{store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID, Unschedulable: true, Maintenance: true})}
+			if n.Drain == nil || n.Drain.Phase != api.DrainRunning {
+				cmds = append(cmds, startDrain(n, 0))
+			}
+			if applyOr500(w, r, st, cmds...) {
+				w.WriteHeader(202)
+			}
+		case http.MethodDelete:
+			cmds := append(cancelDrain(n), store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID}))
+			if applyOr500(w, r, st, cmds...) {
+				w.WriteHeader(204)
+			}
+		default:
+			http.Error(w, "method not allowed", 405)
+		}
+	}
+}
+
+// EventsGet lists cluster events, oldest first, optionally only those about one object
+// (?object=<node or vm id>).
+func EventsGet(fsm fsmReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		object := r.URL.Query().Get("object")
+		out := []api.Event{}
+		for _, ev := range fsm.GetStateCopy().Events {
+			if object == "" || ev.Object == object {
+				out = append(out, ev)
+			}
+		}
+		writeJSON(w, out)
+	}
+}
+
+// SchedulerSimulate runs the scheduler for an existing or hypothetical VM against the
+// current state, optionally modified (POST scheduler.SimulateRequest), and returns every
+// node's filter verdicts and scores without placing anything.
+func SchedulerSimulate(fsm fsmReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req scheduler.SimulateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		res, err := scheduler.Simulate(fsm.GetStateCopy(), req)
+		if errors.Is(err, scheduler.ErrUnknownVM) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		writeJSON(w, struct {
+			scheduler.Result
+			Summary string `json:"summary"`
+		}{res, res.Summary()})
+	}
+}
+
+// DRSRecommendations returns the rebalancer's current plan: node utilisation, the
+// imbalance and the migrations it recommends.
+func DRSRecommendations(fsm fsmReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) { writeJSON(w, scheduler.Rebalance(fsm.GetStateCopy())) }
+}
+
+// DRSApply starts the migration currently recommended for the VM in the path, whatever
+// the DRS mode.
+func DRSApply(fsm fsmReader, st applier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("vm")
+		state := fsm.GetStateCopy()
+		for _, m := range scheduler.Rebalance(state).Migrations {
+			if m.VMID != id {
+				continue
+			}
+			err := drs.Migrate(r.Context(), st, state, m, time.Now().UTC())
+			if errors.Is(err, store.ErrBindConflict) {
+				http.Error(w, err.Error(), 409)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			writeJSON(w, m)
+			return
+		}
+		http.Error(w, "no migration recommended for vm "+id, 404)
+	}
+}
+
+// HAStatus returns the failover headroom under the HA admission control policy and the
+// VMs that would fail to restart after a node failure.
+func HAStatus(fsm fsmReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) { writeJSON(w, scheduler.HA(fsm.GetStateCopy())) }
+}
+
+// Gossip keyring
+type keyManager interface {
+	ListKeys() (*serf.KeyResponse, error)
+	InstallKey(key string) (*serf.KeyResponse, error)
+	UseKey(key string) (*serf.KeyResponse, error)
+	RemoveKey(key string) (*serf.KeyResponse, error)
+}
+
+// GossipKeys lists (GET) the gossip encryption keys by fingerprint (see
+// membership.KeyFingerprint), never the keys themselves, and installs/uses/removes
+// (POST {"op","key"}) keys cluster-wide through the serf key manager. Changes must carry
+// the join token as a bearer token; without a join token they are refused.
+func GossipKeys(km keyManager, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			resp *serf.KeyResponse
+			err  error
+		)
+		switch r.Method {
+		case http.MethodGet:
+			resp, err = km.ListKeys()
+		case http.MethodPost:
+			if token == "" {
+				http.Error(w, "gossip key changes need clusterd to run with --join-token", 403)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+				http.Error(w, "join token required", 401)
+				return
+			}
+			var req struct {
+				Op  string `json:"op"`
+				Key string `json:"key"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+				http.Error(w, "op and key required", 400)
+				return
+			}
+			switch req.Op {
+			case "install":
+				resp, err = km.InstallKey(req.Key)
+			case "use":
+				resp, err = km.UseKey(req.Key)
+			case "remove":
+				resp, err = km.RemoveKey(req.Key)
+			default:
+				http.Error(w, "op must be install, use or remove", 400)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", 405)
+			return
+		}
+		if resp == nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out := map[string]any{"keys": fingerprints(resp.Keys), "primaryKeys": fingerprints(resp.PrimaryKeys),
+			"numNodes": resp.NumNodes, "numResp": resp.NumResp, "numErr": resp.NumErr, "messages": resp.Messages}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			out["error"] = err.Error()
+			_ = json.NewEncoder(w).Encode(out)
+			return
+		}
+		writeJSON(w, out)
+	}
+}
+
+// fingerprints replaces the keys of a key -> member count map with their fingerprints.
+func fingerprints(keys map[string]int) map[string]int {
+	out := make(map[string]int, len(keys))
+	for k, n := range keys {
+		out[membership.KeyFingerprint(k)] = n
+	}
+	return out
+}
+
+// Cluster broadcast
+type broadcaster interface {
+	Broadcast(name string, v any) error
+	Query(ctx context.Context, name string, v any, opts membership.QueryOptions) ([]membership.QueryResponse, error)
+}
+
+// BroadcastEvent sends a fire-and-forget cluster event: POST {"name","payload"}.
+func BroadcastEvent(b broadcaster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name    string          `json:"name"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "name required", 400)
+			return
+		}
+		if err := b.Broadcast(req.Name, req.Payload); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(204)
+	}
+}
+
+// BroadcastQuery runs a cluster query and returns the collected responses:
+// POST {"name","payload","timeout":"5s","nodes":[...],"tags":{...}}.
+func BroadcastQuery(b broadcaster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name    string            `json:"name"`
+			Payload json.RawMessage   `json:"payload"`
+			Timeout string            `json:"timeout"`
+			Nodes   []string          `json:"nodes"`
+			Tags    map[string]string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "name required", 400)
+			return
+		}
+		opts := membership.QueryOptions{FilterNodes: req.Nodes, FilterTags: req.Tags}
+		if req.Timeout != "" {
+			d, err := time.ParseDuration(req.Timeout)
+			if err != nil {
+				http.Error(w, "bad timeout: "+err.Error(), 400)
+				return
+			}
+			opts.Timeout = d
+		}
+		if len(req.Payload) == 0 {
+			req.Payload = json.RawMessage("null")
+		}
+		resps, err := b.Query(r.Context(), req.Name, req.Payload, opts)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		writeJSON(w, resps)
+	}
+}
+
+// Node-local runtime (served by the node agent)
+
+// RuntimeInfo returns the driver's capabilities and the VMs it currently holds.
+func RuntimeInfo(rt runtime.VMRuntime) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vms, err := rt.List(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		writeJSON(w, map[string]any{"capabilities": rt.Capabilities(), "vms": vms})
+	}
+}
+
+// AgentHealthz reports the node-local checks; the control plane's agent health check
+// reads them to derive the Healthy and pressure conditions.
+func AgentHealthz(checks interface {
+	Run(context.Context) []api.AgentCheck
+}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, api.AgentHealth{Checks: checks.Run(r.Context())})
+	}
+}
+
+// VMConsole streams a VM's console output (path value "id").
+func VMConsole(rt runtime.VMRuntime) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc, err := rt.Console(r.Context(), r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), runtimeErrorCode(err))
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.Copy(w, rc)
+	}
+}
+
+// VMReboot reboots a VM in place (path value "id").
+func VMReboot(rt runtime.VMRuntime) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rt.Reboot(r.Context(), r.PathValue("id")); err != nil {
+			http.Error(w, err.Error(), runtimeErrorCode(err))
+			return
+		}
+		w.WriteHeader(204)
+	}
+}
+
+func runtimeErrorCode(err error) int {
+	switch {
+	case errors.Is(err, runtime.ErrNotFound):
+		return 404
+	case errors.Is(err, runtime.ErrUnsupported):
+		return 501
+	default:
+		return 500
+	}
+}
+
+// Fault injection (mock runtime)
+type faultInjector interface {
+	Faults() mock.Faults
+	SetFaults(mock.Faults) error
+	Crash(vmID string) error
+}
+
+// Faults shows (GET), replaces (PUT/POST) or clears (DELETE) the mock runtime's faults.
+func Faults(fi faultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, fi.Faults())
+		case http.MethodPut, http.MethodPost:
+			var f mock.Faults
+			if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if err := fi.SetFaults(f); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			w.WriteHeader(204)
+		case http.MethodDelete:
+			_ = fi.SetFaults(mock.Faults{})
+			w.WriteHeader(204)
+		default:
+			http.Error(w, "method not allowed", 405)
+		}
+	}
+}
+
+// FaultsCrash crashes a running VM immediately (POST {"vmId"}).
+func FaultsCrash(fi faultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			VMID string `json:"vmId"`
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", 405)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.VMID == "" {
+			http.Error(w, "vmId required", 400)
+			return
+		}
+		if err := fi.Crash(req.VMID); err != nil {
+			http.Error(w, err.Error(), runtimeErrorCode(err))
+			return
+		}
+		w.WriteHeader(204)
+	}
+}
@@ -2,12 +2,20 @@ package httphandlers
 
 import (
 	"bytes"
+	"clustering/pkg/agent/runtime"
+	"clustering/pkg/agent/runtime/mock"
 	"clustering/pkg/api"
+	"clustering/pkg/membership"
 	"clustering/pkg/store"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/hashicorp/serf/serf"
 )
 
 type fakeFSM struct{ st api.ClusterState }
@@ -42,6 +50,18 @@ func TestConfigHandlers(t *testing.T) {
 	if len(ap.cmds) != 1 || ap.cmds[0].Type != "SetConfig" {
 		t.Fatalf("unexpected cmds: %+v", ap.cmds)
 	}
+	rr3 := httptest.NewRecorder()
+	body = bytes.NewBufferString(`{"desiredVoters":3,"healthChecks":[{"name":"x","type":"icmp"}]}`)
+	ConfigPost(ap)(rr3, httptest.NewRequest(http.MethodPost, "/api/config", body))
+	if rr3.Code != 400 || len(ap.cmds) != 1 {
+		t.Fatalf("invalid health check accepted: %d", rr3.Code)
+	}
+	rr4 := httptest.NewRecorder()
+	body = bytes.NewBufferString(`{"desiredVoters":3,"schedulerProfiles":[{"name":"gpu","scores":[{"name":"Nope","weight":1}]}]}`)
+	ConfigPost(ap)(rr4, httptest.NewRequest(http.MethodPost, "/api/config", body))
+	if rr4.Code != 400 || len(ap.cmds) != 1 {
+		t.Fatalf("unknown score plugin accepted: %d", rr4.Code)
+	}
 }
 
 func TestNetworksHandlers(t *testing.T) {
@@ -91,3 +111,390 @@ func TestStorageHandlers(t *testing.T) {
 		t.Fatalf("post status: %d", rr4.Code)
 	}
 }
+
+func TestVMStatusHandlers(t *testing.T) {
+	fsm := &fakeFSM{st: api.ClusterState{VMs: map[string]api.VM{"a": {ID: "a", NodeID: "n1"}, "b": {ID: "b", NodeID: "n2"}}}}
+	rr := httptest.NewRecorder()
+	VMsGet(fsm)(rr, httptest.NewRequest(http.MethodGet, "/api/vms?node=n1", nil))
+	var vms map[string]api.VM
+	if err := json.NewDecoder(rr.Body).Decode(&vms); err != nil || len(vms) != 1 || vms["a"].ID != "a" {
+		t.Fatalf("want only vm a, got %v (%v)", vms, err)
+	}
+
+	ap := &fakeApplier{}
+	rr2 := httptest.NewRecorder()
+	VMStatusPost(ap)(rr2, httptest.NewRequest(http.MethodPost, "/api/vms/status", bytes.NewBufferString(`{"vmId":"a","nodeId":"n1","phase":"Running"}`)))
+	if rr2.Code != 204 || len(ap.cmds) != 1 || ap.cmds[0].Type != "UpdateVMStatus" {
+		t.Fatalf("post status: %d cmds=%+v", rr2.Code, ap.cmds)
+	}
+	rr3 := httptest.NewRecorder()
+	VMStatusPost(ap)(rr3, httptest.NewRequest(http.MethodPost, "/api/vms/status", bytes.NewBufferString(`{"vmId":"a"}`)))
+	if rr3.Code != 400 {
+		t.Fatalf("want 400 got %d", rr3.Code)
+	}
+}
+
+func TestPriorityClassHandlers(t *testing.T) {
+	fsm := &fakeFSM{st: api.ClusterState{
+		PriorityClasses: map[string]api.PriorityClass{"high": {Name: "high", Value: 1000}, "low": {Name: "low", Value: -10}},
+		VMs:             map[string]api.VM{"vm1": {ID: "vm1", Policy: api.VMSchedulingPolicy{PriorityClass: "high"}}},
+	}}
+	ap := &fakeApplier{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/priorityclasses", PriorityClassesPost(ap))
+	mux.HandleFunc("DELETE /api/priorityclasses/{name}", PriorityClassDelete(fsm, ap))
+	do := func(method, path, body string) int {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rr.Code
+	}
+	if code := do(http.MethodPost, "/api/priorityclasses", `{"name":"batch","value":-100,"preemptionPolicy":"Sometimes"}`); code != 400 {
+		t.Fatalf("bad policy: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/priorityclasses", `{"name":"batch","value":-100,"preemptionPolicy":"Never"}`); code != 204 || ap.cmds[0].Type != "UpsertPriorityClass" {
+		t.Fatalf("post: %d %v", code, ap.cmds)
+	}
+	if code := do(http.MethodDelete, "/api/priorityclasses/high", ``); code != 409 {
+		t.Fatalf("delete class in use: %d", code)
+	}
+	if code := do(http.MethodDelete, "/api/priorityclasses/none", ``); code != 404 {
+		t.Fatalf("delete unknown: %d", code)
+	}
+	if code := do(http.MethodDelete, "/api/priorityclasses/low", ``); code != 204 || len(ap.cmds) != 2 {
+		t.Fatalf("delete: %d %v", code, ap.cmds)
+	}
+}
+
+func TestDRSHandlers(t *testing.T) {
+	caps := []string{api.CapLiveMigrate}
+	fsm := &fakeFSM{st: api.ClusterState{
+		Nodes: map[string]api.Node{
+			"n1": {ID: "n1", Status: "Alive", Capabilities: caps, Capacity: api.Resources{CPU: 2000, Memory: 4096}, Allocated: api.Resources{CPU: 2000, Memory: 4096}},
+			"n2": {ID: "n2", Status: "Alive", Capabilities: caps, Capacity: api.Resources{CPU: 2000, Memory: 4096}},
+		},
+		VMs: map[string]api.VM{
+			"a": {ID: "a", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 1000, Memory: 2048}},
+			"b": {ID: "b", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 1000, Memory: 2048}},
+		},
+	}}
+	ap := &fakeApplier{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/drs/recommendations", DRSRecommendations(fsm))
+	mux.HandleFunc("POST /api/drs/recommendations/{vm}/apply", DRSApply(fsm, ap))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/drs/recommendations", nil))
+	var plan struct {
+		Mode       string `json:"mode"`
+		Migrations []struct {
+			VMID string `json:"vmId"`
+			To   string `json:"to"`
+		} `json:"migrations"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &plan); err != nil || plan.Mode != api.DRSManual || len(plan.Migrations) != 1 || plan.Migrations[0].To != "n2" {
+		t.Fatalf("recommendations: %v %s", err, rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/drs/recommendations/"+plan.Migrations[0].VMID+"/apply", nil))
+	if rr.Code != 200 || len(ap.cmds) != 2 || ap.cmds[0].Type != "BindVM" {
+		t.Fatalf("apply: %d %v", rr.Code, ap.cmds)
+	}
+	var vm api.VM
+	_ = json.Unmarshal(ap.cmds[0].Payload, &vm)
+	if vm.NodeID != "n2" || vm.Phase != "Migrating" {
+		t.Fatalf("want a migration to n2, got %+v", vm)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/drs/recommendations/none/apply", nil))
+	if rr.Code != 404 {
+		t.Fatalf("apply without recommendation: %d", rr.Code)
+	}
+}
+
+func TestHAStatusHandler(t *testing.T) {
+	fsm := &fakeFSM{st: api.ClusterState{
+		Config: api.ClusterConfig{HA: api.HAConfig{Policy: api.HAPolicyHostFailures}},
+		Nodes: map[string]api.Node{
+			"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 4096}, Allocated: api.Resources{CPU: 1500, Memory: 1024}},
+			"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 4096}, Allocated: api.Resources{CPU: 1500, Memory: 1024}},
+		},
+		VMs: map[string]api.VM{
+			"a": {ID: "a", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 1500, Memory: 1024}},
+			"b": {ID: "b", NodeID: "n2", Phase: "Running", Resources: api.Resources{CPU: 1500, Memory: 1024}},
+		},
+	}}
+	rr := httptest.NewRecorder()
+	HAStatus(fsm)(rr, httptest.NewRequest(http.MethodGet, "/api/ha/status", nil))
+	var hs struct {
+		Satisfied bool          `json:"satisfied"`
+		Headroom  api.Resources `json:"headroom"`
+		AtRisk    []string      `json:"atRisk"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &hs); err != nil || hs.Satisfied || hs.Headroom.CPU != -1000 || len(hs.AtRisk) != 2 {
+		t.Fatalf("status: %v %s", err, rr.Body)
+	}
+}
+
+func TestEventsGet(t *testing.T) {
+	fsm := &fakeFSM{st: api.ClusterState{Events: []api.Event{
+		{Reason: "NodeFenced", Object: "n1"}, {Reason: "FailoverRestart", Object: "vm1"}, {Reason: "NodeRecovered", Object: "n1"},
+	}}}
+	rr := httptest.NewRecorder()
+	EventsGet(fsm)(rr, httptest.NewRequest(http.MethodGet, "/api/events?object=n1", nil))
+	var evs []api.Event
+	if err := json.NewDecoder(rr.Body).Decode(&evs); err != nil || len(evs) != 2 || evs[1].Reason != "NodeRecovered" {
+		t.Fatalf("want the two n1 events in order, got %+v (%v)", evs, err)
+	}
+}
+
+func TestSchedulerSimulate(t *testing.T) {
+	fsm := &fakeFSM{st: api.ClusterState{
+		Nodes: map[string]api.Node{"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}}},
+		VMs:   map[string]api.VM{"big": {ID: "big", Resources: api.Resources{CPU: 4000, Memory: 512}}},
+	}}
+	post := func(body string) (*httptest.ResponseRecorder, map[string]any) {
+		rr := httptest.NewRecorder()
+		SchedulerSimulate(fsm)(rr, httptest.NewRequest(http.MethodPost, "/api/v1/scheduler/simulate", bytes.NewBufferString(body)))
+		var out map[string]any
+		_ = json.NewDecoder(rr.Body).Decode(&out)
+		return rr, out
+	}
+	if rr, out := post(`{"vmId":"big"}`); rr.Code != 200 || out["nodeId"] != nil || out["summary"] != "0/1 nodes are available: 1 Capacity" {
+		t.Fatalf("want no fit, got %d %v", rr.Code, out)
+	}
+	// with a hypothetical larger node the vm fits
+	if rr, out := post(`{"vmId":"big","nodes":{"n2":{"status":"Alive","capacity":{"cpu":8000,"memory":8192}}}}`); rr.Code != 200 || out["nodeId"] != "n2" {
+		t.Fatalf("want n2, got %d %v", rr.Code, out)
+	}
+	if rr, _ := post(`{"vmId":"nope"}`); rr.Code != 404 {
+		t.Fatalf("want 404 got %d", rr.Code)
+	}
+}
+
+type fakeKeyManager struct{ ops []string }
+
+func (f *fakeKeyManager) resp() *serf.KeyResponse {
+	return &serf.KeyResponse{NumNodes: 2, NumResp: 2, Keys: map[string]int{"k1": 2}, PrimaryKeys: map[string]int{"k1": 2}}
+}
+func (f *fakeKeyManager) ListKeys() (*serf.KeyResponse, error) { return f.resp(), nil }
+func (f *fakeKeyManager) InstallKey(k string) (*serf.KeyResponse, error) {
+	f.ops = append(f.ops, "install:"+k)
+	return f.resp(), nil
+}
+func (f *fakeKeyManager) UseKey(k string) (*serf.KeyResponse, error) {
+	f.ops = append(f.ops, "use:"+k)
+	return f.resp(), nil
+}
+func (f *fakeKeyManager) RemoveKey(k string) (*serf.KeyResponse, error) {
+	f.ops = append(f.ops, "remove:"+k)
+	return f.resp(), fmt.Errorf("1/2 nodes reported failure")
+}
+
+func TestGossipKeysHandler(t *testing.T) {
+	km := &fakeKeyManager{}
+	post := func(token, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/gossip/keys", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer tok")
+		GossipKeys(km, token)(rr, req)
+		return rr
+	}
+	rr := httptest.NewRecorder()
+	GossipKeys(km, "tok")(rr, httptest.NewRequest(http.MethodGet, "/api/gossip/keys", nil))
+	if rr.Code != 200 || strings.Contains(rr.Body.String(), `"k1"`) || !strings.Contains(rr.Body.String(), membership.KeyFingerprint("k1")) {
+		t.Fatalf("list status=%d body=%s, want fingerprints only", rr.Code, rr.Body)
+	}
+	if rr2 := post("tok", `{"op":"install","key":"k2"}`); rr2.Code != 200 || len(km.ops) != 1 || km.ops[0] != "install:k2" {
+		t.Fatalf("install status=%d ops=%v", rr2.Code, km.ops)
+	}
+	if rr3 := post("tok", `{"op":"remove","key":"k1"}`); rr3.Code != 500 {
+		t.Fatalf("partial failure should surface as 500, got %d", rr3.Code)
+	}
+	if rr4 := post("tok", `{"op":"rotate","key":"k1"}`); rr4.Code != 400 {
+		t.Fatalf("unknown op status: %d", rr4.Code)
+	}
+	if rr5 := post("other", `{"op":"use","key":"k2"}`); rr5.Code != 401 {
+		t.Fatalf("wrong token status: %d", rr5.Code)
+	}
+	if rr6 := post("", `{"op":"use","key":"k2"}`); rr6.Code != 403 {
+		t.Fatalf("no join token status: %d", rr6.Code)
+	}
+	if len(km.ops) != 2 {
+		t.Fatalf("rejected requests reached the key manager: %v", km.ops)
+	}
+}
+
+type fakeBroadcaster struct {
+	events []string
+	opts   membership.QueryOptions
+}
+
+func (f *fakeBroadcaster) Broadcast(name string, v any) error {
+	f.events = append(f.events, name)
+	return nil
+}
+func (f *fakeBroadcaster) Query(_ context.Context, name string, v any, opts membership.QueryOptions) ([]membership.QueryResponse, error) {
+	f.opts = opts
+	return []membership.QueryResponse{{From: "agent-1", Payload: []byte(`{"running":true}`)}}, nil
+}
+
+func TestBroadcastHandlers(t *testing.T) {
+	b := &fakeBroadcaster{}
+	rr := httptest.NewRecorder()
+	BroadcastEvent(b)(rr, httptest.NewRequest(http.MethodPost, "/api/broadcast/event", bytes.NewBufferString(`{"name":"cache-invalidate","payload":{"key":"vms"}}`)))
+	if rr.Code != 204 || len(b.events) != 1 {
+		t.Fatalf("event status=%d events=%v", rr.Code, b.events)
+	}
+	rr2 := httptest.NewRecorder()
+	BroadcastQuery(b)(rr2, httptest.NewRequest(http.MethodPost, "/api/broadcast/query", bytes.NewBufferString(`{"name":"vm-running","payload":{"vmId":"vm1"},"timeout":"2s","tags":{"role":"node"}}`)))
+	if rr2.Code != 200 || b.opts.Timeout.String() != "2s" || b.opts.FilterTags["role"] != "node" {
+		t.Fatalf("query status=%d opts=%+v", rr2.Code, b.opts)
+	}
+	rr3 := httptest.NewRecorder()
+	BroadcastQuery(b)(rr3, httptest.NewRequest(http.MethodPost, "/api/broadcast/query", bytes.NewBufferString(`{"name":"x","timeout":"soon"}`)))
+	if rr3.Code != 400 {
+		t.Fatalf("bad timeout status: %d", rr3.Code)
+	}
+}
+
+func TestFaultsHandlers(t *testing.T) {
+	d := mock.New()
+	rr := httptest.NewRecorder()
+	Faults(d)(rr, httptest.NewRequest(http.MethodPut, "/debug/faults", bytes.NewBufferString(`{"seed":7,"ops":{"start":{"errorRate":1,"latency":{"mean":"1ms"}}}}`)))
+	if rr.Code != 204 || d.Faults().Ops[mock.OpStart].ErrorRate != 1 {
+		t.Fatalf("put: %d %+v", rr.Code, d.Faults())
+	}
+	rr2 := httptest.NewRecorder()
+	Faults(d)(rr2, httptest.NewRequest(http.MethodPut, "/debug/faults", bytes.NewBufferString(`{"ops":{"start":{"errorRate":2}}}`)))
+	if rr2.Code != 400 {
+		t.Fatalf("want 400 for invalid rate got %d", rr2.Code)
+	}
+	rr3 := httptest.NewRecorder()
+	Faults(d)(rr3, httptest.NewRequest(http.MethodDelete, "/debug/faults", nil))
+	if rr3.Code != 204 || len(d.Faults().Ops) != 0 {
+		t.Fatalf("delete: %d %+v", rr3.Code, d.Faults())
+	}
+	rr4 := httptest.NewRecorder()
+	_ = d.Start(context.Background(), runtime.Spec{ID: "vm1"})
+	FaultsCrash(d)(rr4, httptest.NewRequest(http.MethodPost, "/debug/faults/crash", bytes.NewBufferString(`{"vmId":"vm1"}`)))
+	if rr4.Code != 204 {
+		t.Fatalf("crash: %d", rr4.Code)
+	}
+}
+
+func TestRuntimeHandlers(t *testing.T) {
+	d := mock.New()
+	if err := d.Start(context.Background(), runtime.Spec{ID: "vm1"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/runtime", RuntimeInfo(d))
+	mux.HandleFunc("GET /api/vms/{id}/console", VMConsole(d))
+	mux.HandleFunc("POST /api/vms/{id}/reboot", VMReboot(d))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/runtime", nil))
+	var info struct {
+		Capabilities runtime.Capabilities `json:"capabilities"`
+		VMs          []runtime.Status     `json:"vms"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil || !info.Capabilities.Reboot || len(info.VMs) != 1 {
+		t.Fatalf("runtime info: %+v (%v)", info, err)
+	}
+	rr2 := httptest.NewRecorder()
+	mux.ServeHTTP(rr2, httptest.NewRequest(http.MethodGet, "/api/vms/vm1/console", nil))
+	if rr2.Code != 200 || rr2.Body.Len() == 0 {
+		t.Fatalf("console: %d", rr2.Code)
+	}
+	rr3 := httptest.NewRecorder()
+	mux.ServeHTTP(rr3, httptest.NewRequest(http.MethodPost, "/api/vms/vm1/reboot", nil))
+	if rr3.Code != 204 {
+		t.Fatalf("reboot: %d", rr3.Code)
+	}
+	rr4 := httptest.NewRecorder()
+	mux.ServeHTTP(rr4, httptest.NewRequest(http.MethodPost, "/api/vms/missing/reboot", nil))
+	if rr4.Code != 404 {
+		t.Fatalf("want 404 got %d", rr4.Code)
+	}
+}
+
+func TestNodeTaints(t *testing.T) {
+	fsm := &fakeFSM{st: api.ClusterState{Nodes: map[string]api.Node{"n1": {ID: "n1"}}}}
+	ap := &fakeApplier{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/nodes/{id}/taints", NodeTaints(fsm, ap))
+	do := func(method, path, body string) int {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rr.Code
+	}
+	if code := do(http.MethodPut, "/api/nodes/n1/taints", `[{"key":"gpu","effect":"NoSchedule"}]`); code != 204 {
+		t.Fatalf("put status: %d", code)
+	}
+	var nt api.NodeTaints
+	if len(ap.cmds) != 1 || json.Unmarshal(ap.cmds[0].Payload, &nt) != nil || nt.Taints[0].TimeAdded.IsZero() {
+		t.Fatalf("unexpected cmds: %+v", ap.cmds)
+	}
+	if code := do(http.MethodPut, "/api/nodes/n1/taints", `[{"key":"node.cluster.io/not-ready","effect":"NoExecute"}]`); code != 400 {
+		t.Fatalf("condition taint accepted: %d", code)
+	}
+	if code := do(http.MethodPut, "/api/nodes/n1/taints", `[{"key":"gpu","effect":"Maybe"}]`); code != 400 {
+		t.Fatalf("bad effect accepted: %d", code)
+	}
+	if code := do(http.MethodGet, "/api/nodes/n9/taints", ""); code != 404 {
+		t.Fatalf("unknown node: %d", code)
+	}
+}
+
+func TestNodeMaintenanceHandlers(t *testing.T) {
+	fsm := &fakeFSM{st: api.ClusterState{Nodes: map[string]api.Node{
+		"n1": {ID: "n1"},
+		"n2": {ID: "n2", Unschedulable: true, Maintenance: true, Drain: &api.DrainStatus{NodeID: "n2", Phase: api.DrainRunning}},
+	}}}
+	ap := &fakeApplier{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/nodes/{id}/cordon", NodeCordon(fsm, ap))
+	mux.HandleFunc("POST /api/nodes/{id}/uncordon", NodeUncordon(fsm, ap))
+	mux.HandleFunc("/api/nodes/{id}/drain", NodeDrain(fsm, ap))
+	mux.HandleFunc("/api/nodes/{id}/maintenance", NodeMaintenance(fsm, ap))
+	do := func(method, path, body string) int {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rr.Code
+	}
+	types := func() []string {
+		var out []string
+		for _, c := range ap.cmds {
+			out = append(out, c.Type)
+		}
+		ap.cmds = nil
+		return out
+	}
+
+	if code := do(http.MethodPost, "/api/nodes/n1/drain", `{"maxParallel":3}`); code != 202 {
+		t.Fatalf("drain: %d", code)
+	}
+	if got := types(); len(got) != 2 || got[0] != "SetNodeSchedulable" || got[1] != "SetNodeDrain" {
+		t.Fatalf("drain must cordon then start: %v", got)
+	}
+	if code := do(http.MethodPost, "/api/nodes/n2/drain", ``); code != 409 {
+		t.Fatalf("second drain: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/nodes/n2/uncordon", ``); code != 409 || len(types()) != 0 {
+		t.Fatalf("uncordon in maintenance: %d", code)
+	}
+	if code := do(http.MethodDelete, "/api/nodes/n2/maintenance", ``); code != 204 {
+		t.Fatalf("exit maintenance: %d", code)
+	}
+	if got := types(); len(got) != 2 || got[0] != "SetNodeDrain" || got[1] != "SetNodeSchedulable" {
+		t.Fatalf("exit maintenance must cancel the drain and uncordon: %v", got)
+	}
+	if code := do(http.MethodGet, "/api/nodes/n1/drain", ``); code != 404 {
+		t.Fatalf("status of never drained node: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/nodes/n9/cordon", ``); code != 404 {
+		t.Fatalf("unknown node: %d", code)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// Standby phases of a node taken out of service by power management.
+const (
+	// StandbyEvacuating: the node is cordoned and drained before it is powered down.
+	StandbyEvacuating = "Evacuating"
+	// StandbyParked: the power hook put the node in standby.
+	StandbyParked = "Standby"
+	// StandbyWaking: the power hook was asked to wake the node; it returns to service
+	// once it is ready again.
+	StandbyWaking = "Waking"
+)
+
+// StandbyStatus tracks a node parked by power management.
+type StandbyStatus struct {
+	Phase   string    `json:"phase"`
+	Since   time.Time `json:"since"`
+	Reason  string    `json:"reason,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// NodeStandby is the payload of the SetNodeStandby command; a nil Standby returns the
+// node to service.
+type NodeStandby struct {
+	NodeID  string         `json:"nodeId"`
+	Standby *StandbyStatus `json:"standby"`
+}
+
+// DPMConfig tunes power management, which consolidates VMs onto fewer nodes when the
+// cluster is idle and parks the emptied nodes. Zero fields take the defaults of
+// WithDefaults.
+type DPMConfig struct {
+	Enabled bool `json:"enabled"`
+	// LowUtilization is the CPU and memory utilisation of the active nodes below which
+	// a node is emptied and parked (default 0.3).
+	LowUtilization float64 `json:"lowUtilization,omitempty"`
+	// HighUtilization is the utilisation above which a parked node is woken; no node is
+	// parked when that would push the others above it (default 0.75).
+	HighUtilization float64 `json:"highUtilization,omitempty"`
+	// MinActiveNodes is how many agent nodes always stay powered on (default 2).
+	MinActiveNodes int `json:"minActiveNodes,omitempty"`
+}
+
+// WithDefaults fills in unset fields.
+func (c DPMConfig) WithDefaults() DPMConfig {
+	if c.LowUtilization == 0 {
+		c.LowUtilization = 0.3
+	}
+	if c.HighUtilization == 0 {
+		c.HighUtilization = 0.75
+	}
+	if c.MinActiveNodes == 0 {
+		c.MinActiveNodes = 2
+	}
+	return c
+}
+
+func (c DPMConfig) Validate() error {
+	d := c.WithDefaults()
+	if d.LowUtilization < 0 || d.MinActiveNodes < 0 {
+		return fmt.Errorf("dpm settings must not be negative")
+	}
+	if d.LowUtilization >= d.HighUtilization {
+		return fmt.Errorf("dpm lowUtilization %.2f must be below highUtilization %.2f", d.LowUtilization, d.HighUtilization)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// Preemption policies of a priority class.
+const (
+	PreemptLowerPriority = "PreemptLowerPriority"
+	PreemptNever         = "Never"
+)
+
+// VMReasonPreempted marks a VM evicted to make room for a higher-priority one.
+const VMReasonPreempted = "Preempted"
+
+// PriorityClass names a scheduling priority VMs refer to with Policy.PriorityClass.
+type PriorityClass struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+	// PreemptionPolicy is PreemptLowerPriority (default) or Never: VMs of a Never class
+	// wait for free capacity instead of evicting lower-priority VMs.
+	PreemptionPolicy string `json:"preemptionPolicy,omitempty"`
+	Description      string `json:"description,omitempty"`
+}
+
+func (p PriorityClass) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("priority class name required")
+	}
+	switch p.PreemptionPolicy {
+	case "", PreemptLowerPriority, PreemptNever:
+	default:
+		return fmt.Errorf("unknown preemption policy %q", p.PreemptionPolicy)
+	}
+	return nil
+}
+
+// Priority returns the VM's scheduling priority: the value of its priority class, or
+// Policy.Priority when it names none.
+func (s ClusterState) Priority(vm VM) int {
+	if pc, ok := s.PriorityClasses[vm.Policy.PriorityClass]; ok && vm.Policy.PriorityClass != "" {
+		return pc.Value
+	}
+	return vm.Policy.Priority
+}
+
+// CanPreempt reports whether the VM may evict lower-priority VMs to be placed.
+func (s ClusterState) CanPreempt(vm VM) bool {
+	return s.PriorityClasses[vm.Policy.PriorityClass].PreemptionPolicy != PreemptNever
+}
+
+// Preemption evicts Victims from NodeID so that VMID can be placed there.
+type Preemption struct {
+	VMID    string    `json:"vmId"`
+	NodeID  string    `json:"nodeId"`
+	Victims []string  `json:"victims"`
+	Time    time.Time `json:"time"`
+}
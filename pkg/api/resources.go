@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Overcommit ratios scale a node's allocatable CPU and memory, e.g. 4 hands out four
+// vCPU millicores per physical one. Zero means no overcommit.
+type Overcommit struct {
+	CPU    float64 `json:"cpu,omitempty"`
+	Memory float64 `json:"memory,omitempty"`
+}
+
+func (o Overcommit) Validate() error {
+	if o.CPU < 0 || o.Memory < 0 {
+		return fmt.Errorf("overcommit ratios must not be negative")
+	}
+	return nil
+}
+
+// Allocatable is what the scheduler may hand out on a node: its capacity minus the
+// system-reserved resources, with CPU and memory scaled by the node's overcommit ratios or,
+// where the node sets none, the cluster's. Disk is never overcommitted.
+func (n Node) Allocatable(cluster Overcommit) Resources {
+	ratio := func(node, cluster float64) float64 {
+		switch {
+		case node > 0:
+			return node
+		case cluster > 0:
+			return cluster
+		}
+		return 1
+	}
+	var oc Overcommit
+	if n.Overcommit != nil {
+		oc = *n.Overcommit
+	}
+	return Resources{
+		CPU:    int(float64(max(n.Capacity.CPU-n.Reserved.CPU, 0)) * ratio(oc.CPU, cluster.CPU)),
+		Memory: int(float64(max(n.Capacity.Memory-n.Reserved.Memory, 0)) * ratio(oc.Memory, cluster.Memory)),
+		Disk:   max(n.Capacity.Disk-n.Reserved.Disk, 0),
+	}
+}
+
+// Limit returns the resources the runtime enforces for the VM: its Limits, with unset
+// fields (and no Limits at all) falling back to the requested Resources.
+func (vm VM) Limit() Resources {
+	if vm.Limits == nil {
+		return vm.Resources
+	}
+	pick := func(limit, request int) int {
+		if limit > 0 {
+			return limit
+		}
+		return request
+	}
+	return Resources{
+		CPU:    pick(vm.Limits.CPU, vm.Resources.CPU),
+		Memory: pick(vm.Limits.Memory, vm.Resources.Memory),
+		Disk:   pick(vm.Limits.Disk, vm.Resources.Disk),
+	}
+}
+
+// ValidateLimits checks that no limit is below its request.
+func (vm VM) ValidateLimits() error {
+	l := vm.Limit()
+	if l.CPU < vm.Resources.CPU || l.Memory < vm.Resources.Memory || l.Disk < vm.Resources.Disk {
+		return fmt.Errorf("vm %s: limits %+v below requests %+v", vm.ID, l, vm.Resources)
+	}
+	return nil
+}
+
+// parseList parses "key=value,key=value" into a map, rejecting keys other than
+// cpu, memory and disk.
+func parseList(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		switch k = strings.TrimSpace(k); {
+		case !ok:
+			return nil, fmt.Errorf("%q: want key=value", kv)
+		case k != "cpu" && k != "memory" && k != "disk":
+			return nil, fmt.Errorf("%q: unknown resource %q", kv, k)
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// ParseResources parses "cpu=500,memory=2048,disk=20" (millicores, MiB, GiB).
+func ParseResources(s string) (Resources, error) {
+	var r Resources
+	kvs, err := parseList(s)
+	if err != nil {
+		return r, err
+	}
+	for k, v := range kvs {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return r, fmt.Errorf("%s=%s: want a non-negative integer", k, v)
+		}
+		switch k {
+		case "cpu":
+			r.CPU = n
+		case "memory":
+			r.Memory = n
+		case "disk":
+			r.Disk = n
+		}
+	}
+	return r, nil
+}
+
+// ParseOvercommit parses "cpu=4,memory=1.2".
+func ParseOvercommit(s string) (Overcommit, error) {
+	var o Overcommit
+	kvs, err := parseList(s)
+	if err != nil {
+		return o, err
+	}
+	for k, v := range kvs {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			return o, fmt.Errorf("%s=%s: want a non-negative ratio", k, v)
+		}
+		switch k {
+		case "cpu":
+			o.CPU = f
+		case "memory":
+			o.Memory = f
+		default:
+			return o, fmt.Errorf("%s cannot be overcommitted", k)
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Restart policies.
+const (
+	RestartAlways    = "Always"
+	RestartOnFailure = "OnFailure"
+	RestartNever     = "Never"
+)
+
+// Reasons reported in VMStatus.Reason.
+const (
+	VMReasonBackOff        = "RestartBackOff"
+	VMReasonRestartPolicy  = "RestartPolicy"
+	VMReasonRestartLimit   = "RestartLimitReached"
+	VMReasonWaitingForDeps = "WaitingForDependencies"
+	// VMReasonFailedScheduling is set by the scheduler controller on VMs no node fits.
+	VMReasonFailedScheduling = "FailedScheduling"
+)
+
+// Restart backoff used when a policy sets none.
+const (
+	DefaultRestartBackoff    = 10 * time.Second
+	DefaultMaxRestartBackoff = 5 * time.Minute
+)
+
+// MaxStopTimeoutSeconds bounds a graceful stop, which holds up the node agent's
+// reconcile pass while it runs.
+const MaxStopTimeoutSeconds = 120
+
+func (p StopPolicy) Validate() error {
+	if p.TimeoutSeconds < 0 || p.TimeoutSeconds > MaxStopTimeoutSeconds {
+		return fmt.Errorf("stop timeoutSeconds must be between 0 and %d", MaxStopTimeoutSeconds)
+	}
+	return nil
+}
+
+// Validate checks the policy of a VM against the other VMs; dependency cycles are refused.
+func (p RestartPolicy) Validate(vmID string, vms map[string]VM) error {
+	switch p.Policy {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("unknown restart policy %q", p.Policy)
+	}
+	if p.MaxRestarts < 0 || p.BackoffSeconds < 0 || p.MaxBackoffSeconds < 0 {
+		return fmt.Errorf("restart limits must not be negative")
+	}
+	seen := map[string]bool{}
+	var visit func(id string, deps []string) error
+	visit = func(id string, deps []string) error {
+		for _, d := range deps {
+			if d == vmID {
+				return fmt.Errorf("vm %s: dependency cycle through %s", vmID, id)
+			}
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			if dep, ok := vms[d]; ok {
+				if err := visit(d, dep.Restart.DependsOn); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return visit(vmID, p.DependsOn)
+}
+
+// RestartsAfter reports whether the policy restarts a VM after it exited, failed telling a
+// crash or node failure from a clean shutdown.
+func (p RestartPolicy) RestartsAfter(failed bool) bool {
+	switch p.Policy {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return failed
+	}
+	return true
+}
+
+// Exhausted reports whether a VM restarted this often must not be restarted again.
+func (p RestartPolicy) Exhausted(restarts int) bool {
+	return p.MaxRestarts > 0 && restarts >= p.MaxRestarts
+}
+
+// Backoff is the minimum time after the last restart before the next one, given the
+// number of restarts so far.
+func (p RestartPolicy) Backoff(restarts int) time.Duration {
+	if restarts <= 0 {
+		return 0
+	}
+	base, limit := DefaultRestartBackoff, DefaultMaxRestartBackoff
+	if p.BackoffSeconds > 0 {
+		base = time.Duration(p.BackoffSeconds) * time.Second
+	}
+	if p.MaxBackoffSeconds > 0 {
+		limit = time.Duration(p.MaxBackoffSeconds) * time.Second
+	}
+	d := base
+	for i := 1; i < restarts && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
+// RestartPriority orders a VM's restart after a node failure.
+func RestartPriority(vm VM) int {
+	if vm.Restart.HAPriority != 0 {
+		return vm.Restart.HAPriority
+	}
+	return vm.Policy.Priority
+}
+
+// Restarts returns how often the VM has been restarted.
+func (vm VM) Restarts() int {
+	if vm.Status == nil {
+		return 0
+	}
+	return vm.Status.Restarts
+}
+
+// StartOrder sorts VMs by restart priority (highest first, then ID) and moves the VMs a
+// VM depends on right before it, so dependencies inherit their dependents' priority.
+func StartOrder(vms []VM) []VM {
+	sort.SliceStable(vms, func(i, j int) bool {
+		if pi, pj := RestartPriority(vms[i]), RestartPriority(vms[j]); pi != pj {
+			return pi > pj
+		}
+		return vms[i].ID < vms[j].ID
+	})
+	byID := map[string]VM{}
+	for _, vm := range vms {
+		byID[vm.ID] = vm
+	}
+	out := make([]VM, 0, len(vms))
+	visited := map[string]bool{}
+	var visit func(vm VM)
+	visit = func(vm VM) {
+		if visited[vm.ID] {
+			return // placed already, or a cycle
+		}
+		visited[vm.ID] = true
+		for _, d := range vm.Restart.DependsOn {
+			if dep, ok := byID[d]; ok {
+				visit(dep)
+			}
+		}
+		out = append(out, vm)
+	}
+	for _, vm := range vms {
+		visit(vm)
+	}
+	return out
+}
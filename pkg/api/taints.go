@@ -0,0 +1,117 @@
+package api
+
+import (
+	"strings"
+	"time"
+)
+
+// TaintPrefix namespaces the taints the FSM derives from node conditions.
+const TaintPrefix = "node.cluster.io/"
+
+// Condition-derived taint keys.
+const (
+	TaintNotReady           = TaintPrefix + "not-ready"
+	TaintNetworkUnavailable = TaintPrefix + "network-unavailable"
+	TaintMemoryPressure     = TaintPrefix + "memory-pressure"
+	TaintDiskPressure       = TaintPrefix + "disk-pressure"
+)
+
+// conditionTaints lists, per condition, the status that taints the node and how.
+var conditionTaints = []struct {
+	condition, status, key, effect string
+}{
+	{NodeReady, ConditionFalse, TaintNotReady, TaintNoExecute},
+	{NodeNetworkUnavailable, ConditionTrue, TaintNetworkUnavailable, TaintNoSchedule},
+	{NodeMemoryPressure, ConditionTrue, TaintMemoryPressure, TaintNoSchedule},
+	{NodeDiskPressure, ConditionTrue, TaintDiskPressure, TaintNoSchedule},
+}
+
+// IsConditionTaint reports whether the taint is managed from node conditions.
+func IsConditionTaint(t Taint) bool { return strings.HasPrefix(t.Key, TaintPrefix) }
+
+// SyncConditionTaints returns taints with the condition-derived taints matching conds.
+// Operator taints are kept; a condition taint that stays keeps its TimeAdded, a new one
+// takes the condition's transition time.
+func SyncConditionTaints(taints []Taint, conds []NodeCondition) []Taint {
+	var out []Taint
+	prev := map[string]Taint{}
+	for _, t := range taints {
+		if IsConditionTaint(t) {
+			prev[t.Key] = t
+		} else {
+			out = append(out, t)
+		}
+	}
+	for _, ct := range conditionTaints {
+		var cond *NodeCondition
+		for i := range conds {
+			if conds[i].Type == ct.condition {
+				cond = &conds[i]
+			}
+		}
+		if cond == nil || cond.Status != ct.status {
+			continue
+		}
+		t, ok := prev[ct.key]
+		if !ok || t.Effect != ct.effect {
+			t = Taint{Key: ct.key, Effect: ct.effect, TimeAdded: cond.LastTransitionTime}
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// Tolerates reports whether the toleration matches the taint.
+func (t Toleration) Tolerates(taint Taint) bool {
+	if t.Effect != "" && t.Effect != taint.Effect {
+		return false
+	}
+	if t.Operator == "Exists" {
+		return t.Key == "" || t.Key == taint.Key
+	}
+	return t.Key == taint.Key && t.Value == taint.Value
+}
+
+// FindToleration returns the first toleration matching the taint.
+func FindToleration(tols []Toleration, taint Taint) (Toleration, bool) {
+	for _, t := range tols {
+		if t.Tolerates(taint) {
+			return t, true
+		}
+	}
+	return Toleration{}, false
+}
+
+// UntoleratedTaints returns the node's taints with one of the given effects that the
+// tolerations do not match.
+func UntoleratedTaints(n Node, tols []Toleration, effects ...string) []Taint {
+	var out []Taint
+	for _, taint := range n.Taints {
+		for _, e := range effects {
+			if taint.Effect != e {
+				continue
+			}
+			if _, ok := FindToleration(tols, taint); !ok {
+				out = append(out, taint)
+			}
+		}
+	}
+	return out
+}
+
+// EvictionTime returns when a VM tolerating tols must leave a node with the NoExecute
+// taint, or false when it may stay indefinitely. Without a matching toleration the
+// deadline is TimeAdded plus defaultGrace.
+func EvictionTime(taint Taint, tols []Toleration, defaultGrace time.Duration) (time.Time, bool) {
+	if taint.Effect != TaintNoExecute {
+		return time.Time{}, false
+	}
+	tol, ok := FindToleration(tols, taint)
+	if !ok {
+		return taint.TimeAdded.Add(defaultGrace), true
+	}
+	if tol.TolerationSeconds == nil {
+		return time.Time{}, false
+	}
+	return taint.TimeAdded.Add(time.Duration(*tol.TolerationSeconds) * time.Second), true
+}
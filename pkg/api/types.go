@@ -1,5 +1,16 @@
 package api
 
+import "time"
+
+// Node capabilities: optional runtime operations a node's driver supports.
+const (
+	CapPause       = "pause"
+	CapReboot      = "reboot"
+	CapResize      = "resize"
+	CapLiveMigrate = "live-migrate"
+	CapConsole     = "console"
+)
+
 type Node struct {
 	ID        string            `json:"id"`
 	Address   string            `json:"address"`
@@ -8,8 +19,242 @@ type Node struct {
 	Capacity  Resources         `json:"capacity"`
 	Allocated Resources         `json:"allocated"`
 	Labels    map[string]string `json:"labels"`
-	Taints    map[string]string `json:"taints"`
-	Status    string            `json:"status"` // Alive/Failed/Left
+	// Reserved is kept for the host itself and Overcommit overrides the cluster's ratios;
+	// both come from the agent's serf tags (see Allocatable).
+	Reserved   Resources   `json:"reserved"`
+	Overcommit *Overcommit `json:"overcommit,omitempty"`
+	// Taints repel VMs that do not tolerate them. Taints under TaintPrefix follow the
+	// node's conditions and are managed by the FSM; others are set by operators.
+	Taints []Taint `json:"taints"`
+	Status string  `json:"status"` // Alive/Failed/Left
+	// Capabilities lists optional runtime operations the node's driver supports
+	// (pause, reboot, resize, live-migrate, console), from its "caps" serf tag.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Coordinate is the node's serf network coordinate, refreshed by nodesync.
+	Coordinate *Coordinate `json:"coordinate,omitempty"`
+
+	// Usage, RunningVMs, DriverVersion, AgentUptime and LastHeartbeat come from the node
+	// agent's heartbeats; UpsertNode keeps them.
+	Usage         Resources `json:"usage"`
+	RunningVMs    []string  `json:"runningVms,omitempty"`
+	DriverVersion string    `json:"driverVersion,omitempty"`
+	AgentUptime   int64     `json:"agentUptimeSeconds,omitempty"`
+	LastHeartbeat time.Time `json:"lastHeartbeat,omitempty"`
+	// Ready is derived by the heartbeat controller from serf status, heartbeat freshness
+	// and the Healthy condition.
+	Ready       bool   `json:"ready"`
+	ReadyReason string `json:"readyReason,omitempty"`
+	// Checks and Conditions are recorded by the health prober; UpsertNode keeps them.
+	Checks     []CheckResult   `json:"checks,omitempty"`
+	Conditions []NodeCondition `json:"conditions,omitempty"`
+
+	// Unschedulable (cordoned) keeps new VMs off the node. Maintenance keeps it cordoned
+	// until maintenance is exited explicitly. Drain tracks the last drain. All three are
+	// operator state that UpsertNode keeps.
+	Unschedulable bool         `json:"unschedulable,omitempty"`
+	Maintenance   bool         `json:"maintenance,omitempty"`
+	Drain         *DrainStatus `json:"drain,omitempty"`
+	// Fence is set by the failover controller while it fences the node or after it did;
+	// it is cleared when the node is ready again.
+	Fence *FenceStatus `json:"fence,omitempty"`
+	// Standby is set while power management evacuates, parks or wakes the node.
+	Standby *StandbyStatus `json:"standby,omitempty"`
+}
+
+// Fence phases.
+const (
+	FencePending = "Fencing"
+	FenceDone    = "Fenced"
+	FenceFailed  = "FenceFailed"
+)
+
+// FenceStatus records fencing of a failed node: making sure none of its VMs still run
+// before they are restarted elsewhere.
+type FenceStatus struct {
+	Phase    string    `json:"phase"`
+	Method   string    `json:"method"`
+	Since    time.Time `json:"since"`
+	Attempts int       `json:"attempts,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// NodeFence is the payload of the SetNodeFence command; a nil Fence clears it.
+type NodeFence struct {
+	NodeID string       `json:"nodeId"`
+	Fence  *FenceStatus `json:"fence"`
+}
+
+// Event types.
+const (
+	EventNormal  = "Normal"
+	EventWarning = "Warning"
+)
+
+// Event is a cluster event such as a failover step, recorded with the RecordEvent command.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Reason  string    `json:"reason"`
+	Object  string    `json:"object"` // node or VM ID
+	Message string    `json:"message,omitempty"`
+}
+
+// NodeSchedulability is the payload of the SetNodeSchedulable command.
+type NodeSchedulability struct {
+	NodeID        string `json:"nodeId"`
+	Unschedulable bool   `json:"unschedulable"`
+	Maintenance   bool   `json:"maintenance"`
+}
+
+// Drain phases.
+const (
+	DrainRunning   = "Draining"
+	DrainCompleted = "Completed"
+	DrainCancelled = "Cancelled"
+)
+
+// DrainStatus is the progress of moving every VM off a node; it is also the payload of the
+// SetNodeDrain command.
+type DrainStatus struct {
+	NodeID      string    `json:"nodeId"`
+	Phase       string    `json:"phase"`
+	MaxParallel int       `json:"maxParallel"`
+	StartedAt   time.Time `json:"startedAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// Total counts the VMs on the node when the drain started plus any that arrived later.
+	Total    int      `json:"total"`
+	Moved    int      `json:"moved"`
+	InFlight []string `json:"inFlight,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// NodeHeartbeat is the payload of the NodeHeartbeat command.
+type NodeHeartbeat struct {
+	NodeID        string    `json:"nodeId"`
+	Time          time.Time `json:"time"`
+	Usage         Resources `json:"usage"`
+	RunningVMs    []string  `json:"runningVms"`
+	DriverVersion string    `json:"driverVersion"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+}
+
+// NodeReadiness is the payload of the SetNodeReadiness command.
+type NodeReadiness struct {
+	NodeID string    `json:"nodeId"`
+	Ready  bool      `json:"ready"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"` // transition time for the Ready condition
+}
+
+// Node condition types. Ready and Healthy are True when the node is fine; the pressure
+// conditions are True when the node is under pressure.
+const (
+	NodeReady          = "Ready"
+	NodeHealthy        = "Healthy"
+	NodeDiskPressure   = "DiskPressure"
+	NodeMemoryPressure = "MemoryPressure"
+	// NetworkUnavailable is True while serf cannot reach the node.
+	NodeNetworkUnavailable = "NetworkUnavailable"
+)
+
+// Condition statuses.
+const (
+	ConditionTrue    = "True"
+	ConditionFalse   = "False"
+	ConditionUnknown = "Unknown"
+)
+
+// NodeCondition is one aspect of a node's state. LastTransitionTime changes only when
+// Status does.
+type NodeCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// NodeConditions is the payload of the SetNodeConditions command; the conditions are
+// merged into the node's.
+type NodeConditions struct {
+	NodeID     string          `json:"nodeId"`
+	Conditions []NodeCondition `json:"conditions"`
+}
+
+// NodeTaints is the payload of the SetNodeTaints command: the node's operator taints.
+type NodeTaints struct {
+	NodeID string  `json:"nodeId"`
+	Taints []Taint `json:"taints"`
+}
+
+// Taint effects.
+const (
+	// NoSchedule keeps new VMs off the node.
+	TaintNoSchedule = "NoSchedule"
+	// PreferNoSchedule makes the scheduler avoid the node when others fit.
+	TaintPreferNoSchedule = "PreferNoSchedule"
+	// NoExecute also evicts running VMs that do not tolerate it, after their
+	// toleration's grace period.
+	TaintNoExecute = "NoExecute"
+)
+
+// Taint marks a node so that only VMs tolerating it are placed or kept there.
+type Taint struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value,omitempty"`
+	Effect    string    `json:"effect"`
+	TimeAdded time.Time `json:"timeAdded,omitempty"`
+}
+
+// Toleration lets a VM run on nodes with matching taints. An empty Key with Operator
+// Exists matches every taint; an empty Effect matches every effect. For NoExecute taints
+// TolerationSeconds bounds how long the VM stays after the taint was added (nil: forever).
+type Toleration struct {
+	Key               string `json:"key,omitempty"`
+	Operator          string `json:"operator,omitempty"` // Equal (default) or Exists
+	Value             string `json:"value,omitempty"`
+	Effect            string `json:"effect,omitempty"`
+	TolerationSeconds *int64 `json:"tolerationSeconds,omitempty"`
+}
+
+// CheckResult is the latest outcome of one health check against a node.
+type CheckResult struct {
+	Name                string    `json:"name"`
+	Type                string    `json:"type"`
+	OK                  bool      `json:"ok"`
+	Message             string    `json:"message,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures,omitempty"`
+	LastChecked         time.Time `json:"lastChecked"`
+}
+
+// NodeHealth is the payload of the SetNodeHealth command: it replaces the node's check
+// results and merges the given conditions.
+type NodeHealth struct {
+	NodeID     string          `json:"nodeId"`
+	Checks     []CheckResult   `json:"checks"`
+	Conditions []NodeCondition `json:"conditions"`
+}
+
+// AgentHealth is the body of a node agent's /healthz.
+type AgentHealth struct {
+	Checks []AgentCheck `json:"checks"`
+}
+
+// AgentCheck is one node-local check reported by a node agent on /healthz. Condition,
+// when set, names the node condition the check feeds (e.g. DiskPressure).
+type AgentCheck struct {
+	Name      string `json:"name"`
+	OK        bool   `json:"ok"`
+	Message   string `json:"message,omitempty"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// Coordinate is a Vivaldi network coordinate (units are seconds) used to estimate RTT.
+type Coordinate struct {
+	Vec        []float64 `json:"vec"`
+	Error      float64   `json:"error"`
+	Adjustment float64   `json:"adjustment"`
+	Height     float64   `json:"height"`
 }
 
 type Resources struct {
@@ -21,17 +266,128 @@ type Resources struct {
 type VM struct {
 	ID        string             `json:"id"`
 	Name      string             `json:"name"`
-	Resources Resources          `json:"resources"`
+	Image     string             `json:"image,omitempty"`  // template BaseImage; selects what the runtime runs
+	Resources Resources          `json:"resources"`        // requests the scheduler places on
+	Limits    *Resources         `json:"limits,omitempty"` // enforced by the runtime; unset fields default to Resources
 	NodeID    string             `json:"nodeId"`
-	Phase     string             `json:"phase"` // Pending, Running, Migrating, Stopped
+	Phase     string             `json:"phase"` // Pending, Scheduled, Running, Paused, Migrating, Stopped, Failed
 	Labels    map[string]string  `json:"labels"`
 	Policy    VMSchedulingPolicy `json:"policy"`
+	Networks  []string           `json:"networks,omitempty"`
+	Volumes   []string           `json:"volumes,omitempty"`
+	// DesiredState is Running (the default when empty), Paused or Stopped; node agents converge to it.
+	DesiredState string `json:"desiredState,omitempty"`
+	// Restart controls whether the VM is started again after it exits or its node fails.
+	Restart RestartPolicy `json:"restart"`
+	// Stop controls how the node agent stops the VM.
+	Stop StopPolicy `json:"stop"`
+	// Status is the last status observed by the node agent running the VM.
+	Status *VMStatus `json:"status,omitempty"`
+	// NominatedNode is the node lower-priority VMs were preempted from for this VM.
+	NominatedNode string `json:"nominatedNode,omitempty"`
+}
+
+// StopPolicy selects how the node agent stops a VM: a graceful shutdown request that
+// turns into a kill after TimeoutSeconds (0: the runtime's default), or a forced kill.
+type StopPolicy struct {
+	Force          bool `json:"force,omitempty"`
+	TimeoutSeconds int  `json:"timeoutSeconds,omitempty"`
+}
+
+// RestartPolicy is enforced by the node agent for VMs that exit and by the failover
+// controller for VMs whose node failed.
+type RestartPolicy struct {
+	Policy string `json:"policy,omitempty"` // Always (default), OnFailure or Never
+	// MaxRestarts stops restarting once the VM has been restarted this often (0: no limit).
+	MaxRestarts int `json:"maxRestarts,omitempty"`
+	// BackoffSeconds is the minimum time between the first two restarts; it doubles with
+	// every further restart up to MaxBackoffSeconds.
+	BackoffSeconds    int `json:"backoffSeconds,omitempty"`
+	MaxBackoffSeconds int `json:"maxBackoffSeconds,omitempty"`
+	// HAPriority orders restarts after a node failure, highest first; 0 uses Policy.Priority.
+	HAPriority int `json:"haPriority,omitempty"`
+	// DependsOn lists VMs that must be Running before this one is started.
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// VMStatus is reported by a node agent after reconciling a VM.
+type VMStatus struct {
+	VMID    string `json:"vmId"`
+	NodeID  string `json:"nodeId"`
+	Phase   string `json:"phase"` // Running, Paused, Stopped, Failed
+	Message string `json:"message,omitempty"`
+	// Reason explains a VM that is not running although it should (see VMReason*).
+	Reason     string    `json:"reason,omitempty"`
+	ObservedAt time.Time `json:"observedAt"`
+	// Restarts counts restarts after exits and node failures; LastRestartAt is the latest.
+	Restarts      int       `json:"restarts,omitempty"`
+	LastRestartAt time.Time `json:"lastRestartAt,omitempty"`
 }
 
 type VMSchedulingPolicy struct {
-	Priority int               `json:"priority"`
+	Priority int `json:"priority"`
+	// PriorityClass names a priority class whose value replaces Priority.
+	PriorityClass string `json:"priorityClass,omitempty"`
+	// Spread is no longer consulted; the placement strategy comes from Profile.
 	Spread   bool              `json:"spread"`
 	Affinity map[string]string `json:"affinity"`
+	// Profile names the scheduler profile placing the VM (default "default").
+	Profile string `json:"profile,omitempty"`
+	// NearNode / NearVolume prefer nodes with the lowest estimated RTT to the given
+	// node or to the node holding the given volume.
+	NearNode   string `json:"nearNode,omitempty"`
+	NearVolume string `json:"nearVolume,omitempty"`
+	// Tolerations allow placement on (and, for NoExecute, staying on) tainted nodes.
+	Tolerations []Toleration `json:"tolerations,omitempty"`
+	// NodeAffinity selects nodes by label expressions, on top of the exact-match Affinity.
+	NodeAffinity *NodeAffinity `json:"nodeAffinity,omitempty"`
+	// VMAffinity and VMAntiAffinity place the VM in the same topology domain as, or a
+	// different one from, the VMs their selectors match.
+	VMAffinity     []VMAffinityTerm `json:"vmAffinity,omitempty"`
+	VMAntiAffinity []VMAffinityTerm `json:"vmAntiAffinity,omitempty"`
+	// TopologySpread bounds how unevenly matching VMs spread over a node label.
+	TopologySpread []TopologySpreadConstraint `json:"topologySpread,omitempty"`
+}
+
+// LabelRequirement matches a label set: In and NotIn compare the value with Values,
+// Exists and DoesNotExist only look at the key.
+type LabelRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// NodeAffinity requires nodes to match every Required expression and prefers nodes
+// matching the Preferred terms by their weight.
+type NodeAffinity struct {
+	Required  []LabelRequirement `json:"required,omitempty"`
+	Preferred []PreferredLabels  `json:"preferred,omitempty"`
+}
+
+// PreferredLabels adds Weight (1-100) to nodes matching every expression in Match.
+type PreferredLabels struct {
+	Weight int                `json:"weight"`
+	Match  []LabelRequirement `json:"match"`
+}
+
+// VMAffinityTerm relates a VM to the VMs matching Selector within a topology domain: the
+// nodes sharing the value of the TopologyKey label, or a single node when it is empty. A
+// Weight (1-100) makes the term a preference instead of a requirement.
+type VMAffinityTerm struct {
+	Selector    []LabelRequirement `json:"selector"`
+	TopologyKey string             `json:"topologyKey,omitempty"`
+	Weight      int                `json:"weight,omitempty"`
+}
+
+// TopologySpreadConstraint keeps the number of VMs matching Selector (default: VMs with
+// all of this VM's labels) in any domain of TopologyKey within MaxSkew (default 1) of the
+// least loaded domain. WhenUnsatisfiable is DoNotSchedule (default) or ScheduleAnyway, which
+// only prefers the less loaded domains.
+type TopologySpreadConstraint struct {
+	TopologyKey       string             `json:"topologyKey"`
+	MaxSkew           int                `json:"maxSkew,omitempty"`
+	Selector          []LabelRequirement `json:"selector,omitempty"`
+	WhenUnsatisfiable string             `json:"whenUnsatisfiable,omitempty"`
 }
 
 type ClusterState struct {
@@ -44,6 +400,10 @@ type ClusterState struct {
 	Config        ClusterConfig          `json:"config"`
 	ConfigVersion int                    `json:"configVersion"`
 	ConfigHistory []ClusterConfig        `json:"configHistory"`
+	// Events holds the most recent cluster events, oldest first.
+	Events []Event `json:"events,omitempty"`
+	// PriorityClasses maps class names to VM scheduling priorities.
+	PriorityClasses map[string]PriorityClass `json:"priorityClasses,omitempty"`
 }
 
 // Future extensions
@@ -56,12 +416,56 @@ type Volume struct {
 type Network struct {
 	ID   string `json:"id"`
 	CIDR string `json:"cidr"`
+	// NodeSelector limits the network to nodes with these labels (empty: every node).
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
 }
 
 // ClusterConfig holds operator-tunable parameters.
 type ClusterConfig struct {
 	DesiredVoters    int `json:"desiredVoters"`
 	DesiredNonVoters int `json:"desiredNonVoters"`
+	// HealthChecks run against every agent node; empty means the agent's /healthz.
+	HealthChecks []HealthCheck `json:"healthChecks,omitempty"`
+	// HealthFailureThreshold is how many consecutive failures make a check mark the
+	// node unhealthy (default 3).
+	HealthFailureThreshold int `json:"healthFailureThreshold,omitempty"`
+	// SchedulerProfiles add scheduler profiles or replace the built-in ones by name.
+	SchedulerProfiles []SchedulerProfile `json:"schedulerProfiles,omitempty"`
+	// Overcommit sets the CPU and memory ratios of nodes that do not set their own.
+	Overcommit Overcommit `json:"overcommit"`
+	// DRS configures the rebalancer that live-migrates VMs to even out node load.
+	DRS DRSConfig `json:"drs"`
+	// DPM configures power management, which parks idle nodes.
+	DPM DPMConfig `json:"dpm"`
+	// HA reserves failover capacity and refuses VMs that would eat into it.
+	HA HAConfig `json:"ha"`
+}
+
+// SchedulerProfile selects the filter and score plugins the scheduler runs. Empty
+// Filters runs every filter plugin; empty Scores uses the default weights.
+type SchedulerProfile struct {
+	Name    string         `json:"name"`
+	Filters []string       `json:"filters,omitempty"`
+	Scores  []PluginWeight `json:"scores,omitempty"`
+}
+
+// PluginWeight multiplies a score plugin's 0-100 node score.
+type PluginWeight struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// HealthCheck configures one probe of agent nodes. Type is http (GET Path, 2xx is
+// healthy), tcp (connect), grpc (grpc.health.v1 Check of Service) or agent (GET the
+// agent's /healthz and read its node-local checks). Port defaults to the agent's
+// advertised http port.
+type HealthCheck struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	Path           string `json:"path,omitempty"`
+	Port           int    `json:"port,omitempty"`
+	Service        string `json:"service,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
 }
 
 // StoragePool models a storage pool resource (placeholder for storage mgmt).
@@ -31,6 +31,12 @@ func RemoveServer(r *raft.Raft, id raft.ServerID) error {
 	return fut.Error()
 }
 
+// DemoteVoter turns a voter into a non-voter.
+func DemoteVoter(r *raft.Raft, id raft.ServerID) error {
+	fut := r.DemoteVoter(id, 0, 0)
+	return fut.Error()
+}
+
 // WaitForLeader blocks until a leader is elected or the timeout elapses.
 func WaitForLeader(r *raft.Raft, timeout time.Duration) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
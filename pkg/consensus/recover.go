@@ -0,0 +1,234 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+
+	"clustering/pkg/store"
+)
+
+// RecoveryRecordFile is written into the data dir after a successful recovery so
+// the next clusterd start can document the forced configuration in the audit log.
+const RecoveryRecordFile = "recovery.json"
+
+// Stores bundles the durable raft stores of a data directory.
+type Stores struct {
+	Logs      raft.LogStore
+	Stable    raft.StableStore
+	Snapshots raft.SnapshotStore
+	closers   []io.Closer
+}
+
+// Close releases the underlying bolt handles.
+func (s *Stores) Close() error {
+	var first error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// OpenStores opens the raft stores under dataDir for offline inspection or recovery.
+// It fails instead of blocking when another process (a running clusterd) holds the bolt lock.
+func OpenStores(dataDir string, lockTimeout time.Duration) (*Stores, error) {
+	if _, err := os.Stat(filepath.Join(dataDir, "raft-log.bolt")); err != nil {
+		return nil, fmt.Errorf("no raft log in %s: %w", dataDir, err)
+	}
+	opts := &bolt.Options{Timeout: lockTimeout}
+	lstore, err := raftboltdb.New(raftboltdb.Options{Path: filepath.Join(dataDir, "raft-log.bolt"), BoltOptions: opts})
+	if err != nil {
+		return nil, fmt.Errorf("open log store (is clusterd still running?): %w", err)
+	}
+	sstore, err := raftboltdb.New(raftboltdb.Options{Path: filepath.Join(dataDir, "raft-stable.bolt"), BoltOptions: opts})
+	if err != nil {
+		_ = lstore.Close()
+		return nil, fmt.Errorf("open stable store (is clusterd still running?): %w", err)
+	}
+	snapStore, err := raft.NewFileSnapshotStore(filepath.Join(dataDir, "snapshots"), 2, io.Discard)
+	if err != nil {
+		_ = lstore.Close()
+		_ = sstore.Close()
+		return nil, fmt.Errorf("snapshot store: %w", err)
+	}
+	return &Stores{Logs: lstore, Stable: sstore, Snapshots: snapStore, closers: []io.Closer{lstore, sstore}}, nil
+}
+
+// RaftStateInfo summarises the durable raft state of one survivor.
+type RaftStateInfo struct {
+	LastIndex     uint64             `json:"lastIndex"`
+	LastTerm      uint64             `json:"lastTerm"`
+	CurrentTerm   uint64             `json:"currentTerm"`
+	SnapshotIndex uint64             `json:"snapshotIndex"`
+	SnapshotTerm  uint64             `json:"snapshotTerm"`
+	Configuration raft.Configuration `json:"configuration"`
+}
+
+// Inspect reads the latest index/term and the last known configuration without starting raft.
+func Inspect(s *Stores) (RaftStateInfo, error) {
+	var info RaftStateInfo
+	if ct, err := s.Stable.GetUint64([]byte("CurrentTerm")); err == nil {
+		info.CurrentTerm = ct
+	}
+	snaps, err := s.Snapshots.List()
+	if err != nil {
+		return info, fmt.Errorf("list snapshots: %w", err)
+	}
+	if len(snaps) > 0 {
+		info.SnapshotIndex, info.SnapshotTerm = snaps[0].Index, snaps[0].Term
+		info.LastIndex, info.LastTerm = snaps[0].Index, snaps[0].Term
+		info.Configuration = snaps[0].Configuration
+	}
+	first, err := s.Logs.FirstIndex()
+	if err != nil {
+		return info, fmt.Errorf("first log index: %w", err)
+	}
+	last, err := s.Logs.LastIndex()
+	if err != nil {
+		return info, fmt.Errorf("last log index: %w", err)
+	}
+	if last > 0 && last >= info.LastIndex {
+		var l raft.Log
+		if err := s.Logs.GetLog(last, &l); err != nil {
+			return info, fmt.Errorf("get log %d: %w", last, err)
+		}
+		info.LastIndex, info.LastTerm = l.Index, l.Term
+	}
+	// newest configuration entry in the log wins over the snapshot's
+	for i := last; i >= first && i > info.SnapshotIndex && i > 0; i-- {
+		var l raft.Log
+		if err := s.Logs.GetLog(i, &l); err != nil {
+			continue
+		}
+		if l.Type == raft.LogConfiguration {
+			info.Configuration = raft.DecodeConfiguration(l.Data)
+			break
+		}
+	}
+	return info, nil
+}
+
+// ValidateRecoveryConfiguration checks that the forced configuration is one this node can
+// come back up with: at least one voter, no duplicates, and the local node is a voter.
+func ValidateRecoveryConfiguration(nodeID string, cfg raft.Configuration) error {
+	ids := map[raft.ServerID]bool{}
+	addrs := map[raft.ServerAddress]bool{}
+	voters := 0
+	local := false
+	for _, s := range cfg.Servers {
+		if s.ID == "" || s.Address == "" {
+			return errors.New("every server needs an id and an address")
+		}
+		if ids[s.ID] {
+			return fmt.Errorf("duplicate server id %s", s.ID)
+		}
+		if addrs[s.Address] {
+			return fmt.Errorf("duplicate server address %s", s.Address)
+		}
+		ids[s.ID], addrs[s.Address] = true, true
+		if s.Suffrage == raft.Voter {
+			voters++
+			if string(s.ID) == nodeID {
+				local = true
+			}
+		}
+	}
+	if voters == 0 {
+		return errors.New("configuration has no voters")
+	}
+	if !local {
+		return fmt.Errorf("local node %s must be a voter in the recovery configuration", nodeID)
+	}
+	return nil
+}
+
+// RecoveryRecord documents a forced configuration for the audit log.
+type RecoveryRecord struct {
+	Time          time.Time          `json:"time"`
+	NodeID        string             `json:"nodeId"`
+	Before        RaftStateInfo      `json:"before"`
+	Configuration raft.Configuration `json:"configuration"`
+}
+
+// Summary renders the record as a single audit line.
+func (r RecoveryRecord) Summary() string {
+	return fmt.Sprintf("forced configuration %s on %s at index=%d term=%d (%s)", FormatServers(r.Configuration), r.NodeID, r.Before.LastIndex, r.Before.LastTerm, r.Time.UTC().Format(time.RFC3339))
+}
+
+// FormatServers renders a configuration as [id=addr(suffrage) ...].
+func FormatServers(cfg raft.Configuration) string {
+	out := "["
+	for i, s := range cfg.Servers {
+		if i > 0 {
+			out += " "
+		}
+		out += fmt.Sprintf("%s=%s(%s)", s.ID, s.Address, s.Suffrage)
+	}
+	return out + "]"
+}
+
+// Recover rewrites the raft configuration of the local stores using raft.RecoverCluster,
+// replaying the local log/snapshot into a throwaway FSM.
+func Recover(nodeID string, s *Stores, cfg raft.Configuration) (RecoveryRecord, error) {
+	if err := ValidateRecoveryConfiguration(nodeID, cfg); err != nil {
+		return RecoveryRecord{}, err
+	}
+	before, err := Inspect(s)
+	if err != nil {
+		return RecoveryRecord{}, err
+	}
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(nodeID)
+	conf.LogOutput = io.Discard
+	var localAddr raft.ServerAddress
+	for _, sv := range cfg.Servers {
+		if string(sv.ID) == nodeID {
+			localAddr = sv.Address
+		}
+	}
+	_, trans := raft.NewInmemTransport(localAddr)
+	defer trans.Close()
+	if err := raft.RecoverCluster(conf, store.NewFSM(), s.Logs, s.Stable, s.Snapshots, trans, cfg); err != nil {
+		return RecoveryRecord{}, err
+	}
+	return RecoveryRecord{Time: time.Now(), NodeID: nodeID, Before: before, Configuration: cfg}, nil
+}
+
+// WriteRecoveryRecord persists rec into dataDir for the next startup to pick up.
+func WriteRecoveryRecord(dataDir string, rec RecoveryRecord) error {
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dataDir, RecoveryRecordFile), b, 0o644)
+}
+
+// ConsumeRecoveryRecord returns a pending recovery record, if any, and marks it as consumed.
+func ConsumeRecoveryRecord(dataDir string) (*RecoveryRecord, error) {
+	path := filepath.Join(dataDir, RecoveryRecordFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec RecoveryRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path, path+".applied"); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"clustering/pkg/api"
+	"clustering/pkg/store"
+)
+
+func threeNodeStores(t *testing.T) *Stores {
+	logs := raft.NewInmemStore()
+	old := raft.Configuration{Servers: []raft.Server{
+		{ID: "n1", Address: "127.0.0.1:7000", Suffrage: raft.Voter},
+		{ID: "n2", Address: "127.0.0.1:7001", Suffrage: raft.Voter},
+		{ID: "n3", Address: "127.0.0.1:7002", Suffrage: raft.Voter},
+	}}
+	cmd, _ := json.Marshal(store.NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive"}))
+	if err := logs.StoreLogs([]*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogConfiguration, Data: raft.EncodeConfiguration(old)},
+		{Index: 2, Term: 2, Type: raft.LogCommand, Data: cmd},
+	}); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	if err := logs.SetUint64([]byte("CurrentTerm"), 2); err != nil {
+		t.Fatalf("set term: %v", err)
+	}
+	return &Stores{Logs: logs, Stable: logs, Snapshots: raft.NewInmemSnapshotStore()}
+}
+
+func TestInspectReportsIndexTermAndConfiguration(t *testing.T) {
+	info, err := Inspect(threeNodeStores(t))
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if info.LastIndex != 2 || info.LastTerm != 2 || info.CurrentTerm != 2 {
+		t.Fatalf("unexpected index/term: %+v", info)
+	}
+	if len(info.Configuration.Servers) != 3 {
+		t.Fatalf("want 3 servers got %v", info.Configuration.Servers)
+	}
+}
+
+func TestRecoverForcesSingleVoterConfiguration(t *testing.T) {
+	s := threeNodeStores(t)
+	cfg := raft.Configuration{Servers: []raft.Server{{ID: "n1", Address: "127.0.0.1:7000", Suffrage: raft.Voter}}}
+	rec, err := Recover("n1", s, cfg)
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if rec.Before.LastIndex != 2 {
+		t.Fatalf("record should capture pre-recovery index: %+v", rec.Before)
+	}
+	info, err := Inspect(s)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if info.SnapshotIndex != 2 || len(info.Configuration.Servers) != 1 || info.Configuration.Servers[0].ID != "n1" {
+		t.Fatalf("unexpected state after recovery: %+v", info)
+	}
+}
+
+func TestValidateRecoveryConfigurationRequiresLocalVoter(t *testing.T) {
+	cfg := raft.Configuration{Servers: []raft.Server{
+		{ID: "n1", Address: "a1", Suffrage: raft.Nonvoter},
+		{ID: "n2", Address: "a2", Suffrage: raft.Voter},
+	}}
+	if err := ValidateRecoveryConfiguration("n1", cfg); err == nil {
+		t.Fatal("expected error when local node is not a voter")
+	}
+	if err := ValidateRecoveryConfiguration("n2", cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
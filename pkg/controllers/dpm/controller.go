@@ -0,0 +1,423 @@
+package dpm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/metrics"
+	"clustering/pkg/scheduler"
+	"clustering/pkg/store"
+)
+
+// Event reasons recorded by the controller.
+const (
+	ReasonEvacuating  = "StandbyEvacuating"
+	ReasonStandby     = "NodeStandby"
+	ReasonAborted     = "StandbyAborted"
+	ReasonWaking      = "NodeWaking"
+	ReasonWoken       = "NodeWoken"
+	ReasonPowerFailed = "PowerActionFailed"
+)
+
+// WakeTimeout is how long a woken node has to become ready before it is woken again.
+const WakeTimeout = 5 * time.Minute
+
+// Controller consolidates VMs onto fewer nodes while the cluster is idle and parks the
+// emptied nodes, like VMware DPM. A node is parked by draining it (its VMs live-migrate
+// away) and then calling the power hook; it stays cordoned while in standby. Parked
+// nodes are woken when pending VMs would fit on them or utilisation rises above
+// DPMConfig.HighUtilization, and return to service once they are ready. Disabling DPM
+// aborts evacuations but leaves parked nodes in standby.
+type Controller struct {
+	st       *store.Manager
+	power    PowerManager
+	interval time.Duration
+	isLeader func() bool
+	now      func() time.Time
+}
+
+func NewController(st *store.Manager, isLeader func() bool) *Controller {
+	return &Controller{st: st, power: &LocalPower{}, interval: 30 * time.Second, isLeader: isLeader, now: time.Now}
+}
+
+// WithPower replaces the default LocalPower hook.
+func (c *Controller) WithPower(p PowerManager) *Controller {
+	c.power = p
+	return c
+}
+
+func (c *Controller) Run(stop <-chan struct{}) {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			c.tick()
+		}
+	}
+}
+
+// Decision is what power management would do with the cluster now.
+type Decision struct {
+	// Utilization is the higher of the CPU and memory utilisation of the active nodes.
+	Utilization float64
+	// Park names the node to evacuate and park, Wake the parked node to wake and Abort
+	// the evacuating node to return to service; Reason explains the choice.
+	Park   string
+	Wake   string
+	Abort  string
+	Reason string
+}
+
+// Plan decides whether to park or wake a node. One node is evacuated or woken at a time.
+// Pressure (pending VMs a parked node would fit, too little capacity for the HA policy, or
+// utilisation above HighUtilization) first aborts an evacuation, then wakes a node.
+// Without pressure, a node is parked when utilisation is below LowUtilization, more than
+// MinActiveNodes agent nodes are active, the others stay below HighUtilization without
+// it, every VM on it can move elsewhere and the HA policy still holds. The least loaded
+// such node is parked first.
+func Plan(state api.ClusterState) Decision {
+	cfg := state.Config.DPM.WithDefaults()
+	ids := make([]string, 0, len(state.Nodes))
+	for id := range state.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var active, parked []api.Node
+	var evacuating, waking string
+	for _, id := range ids {
+		n := state.Nodes[id]
+		switch {
+		case n.Standby == nil:
+			if n.Status == "Alive" {
+				active = append(active, n)
+			}
+		case n.Standby.Phase == api.StandbyEvacuating:
+			// its VMs still run until they moved
+			active = append(active, n)
+			evacuating = id
+		case n.Standby.Phase == api.StandbyParked:
+			parked = append(parked, n)
+		case n.Standby.Phase == api.StandbyWaking:
+			waking = id
+		}
+	}
+	var d Decision
+	used, capacity := totals(active, state.Config.Overcommit)
+	d.Utilization = utilization(used, capacity)
+
+	if reason, node := pressure(state, cfg, parked, d.Utilization); reason != "" {
+		switch {
+		case evacuating != "":
+			d.Abort, d.Reason = evacuating, reason
+		case waking == "" && node != "":
+			d.Wake, d.Reason = node, reason
+		}
+		return d
+	}
+	if evacuating != "" || waking != "" || d.Utilization >= cfg.LowUtilization {
+		return d
+	}
+	agents := 0
+	for _, n := range active {
+		if n.Role == "node" {
+			agents++
+		}
+	}
+	if agents <= cfg.MinActiveNodes {
+		return d
+	}
+
+	cands := slices.DeleteFunc(slices.Clone(active), func(n api.Node) bool { return !parkable(state, n) })
+	sort.SliceStable(cands, func(i, j int) bool {
+		return nodeUtilization(cands[i], state.Config.Overcommit) < nodeUtilization(cands[j], state.Config.Overcommit)
+	})
+	for _, n := range cands {
+		a := n.Allocatable(state.Config.Overcommit)
+		rest := api.Resources{CPU: capacity.CPU - a.CPU, Memory: capacity.Memory - a.Memory}
+		if utilization(used, rest) >= cfg.HighUtilization || !evacuable(state, n.ID) || scheduler.HAShortfall(parkedState(state, n)) != "" {
+			continue
+		}
+		d.Park = n.ID
+		d.Reason = fmt.Sprintf("utilisation %.0f%% below %.0f%%", d.Utilization*100, cfg.LowUtilization*100)
+		return d
+	}
+	return d
+}
+
+// pressure returns why a parked node should be woken, and which: the first one a pending
+// VM would be placed on, or the largest one when the cluster falls short of its HA policy
+// or utilisation is too high.
+func pressure(state api.ClusterState, cfg api.DPMConfig, parked []api.Node, util float64) (string, string) {
+	ids := make([]string, 0, len(state.VMs))
+	for id, vm := range state.VMs {
+		if vm.NodeID == "" && vm.Status != nil && vm.Status.Reason == api.VMReasonFailedScheduling {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		for _, n := range parked {
+			res, err := scheduler.Simulate(state, scheduler.SimulateRequest{VMID: id, Nodes: map[string]api.Node{n.ID: awake(n)}})
+			if err == nil && res.NodeID == n.ID {
+				return "vm " + id + " is pending and fits on " + n.ID, n.ID
+			}
+		}
+	}
+	node := ""
+	if len(parked) > 0 {
+		largest := slices.MaxFunc(parked, func(a, b api.Node) int {
+			return a.Allocatable(state.Config.Overcommit).CPU - b.Allocatable(state.Config.Overcommit).CPU
+		})
+		node = largest.ID
+	}
+	if why := scheduler.HAShortfall(state); why != "" {
+		return why, node
+	}
+	if util > cfg.HighUtilization {
+		return fmt.Sprintf("utilisation %.0f%% above %.0f%%", util*100, cfg.HighUtilization*100), node
+	}
+	return "", ""
+}
+
+// parkedState is a copy of state with the node in standby.
+func parkedState(state api.ClusterState, n api.Node) api.ClusterState {
+	nodes := make(map[string]api.Node, len(state.Nodes))
+	for id, n := range state.Nodes {
+		nodes[id] = n
+	}
+	n.Standby = &api.StandbyStatus{Phase: api.StandbyParked}
+	nodes[n.ID] = n
+	state.Nodes = nodes
+	return state
+}
+
+// awake is a parked node as it will be once it is back in service.
+func awake(n api.Node) api.Node {
+	n.Standby, n.Drain = nil, nil
+	n.Status, n.Unschedulable, n.Ready = "Alive", false, true
+	n.Conditions = nil
+	n.Taints = slices.DeleteFunc(slices.Clone(n.Taints), api.IsConditionTaint)
+	return n
+}
+
+// parkable reports whether the node may be parked: an agent node nobody else has taken
+// out of service, whose running VMs can live-migrate.
+func parkable(state api.ClusterState, n api.Node) bool {
+	if n.Role != "node" || n.Standby != nil || n.Unschedulable || n.Maintenance || n.Fence != nil {
+		return false
+	}
+	if slices.Contains(n.Capabilities, api.CapLiveMigrate) {
+		return true
+	}
+	for _, vm := range state.VMs {
+		if vm.NodeID == n.ID && (vm.Phase == "Running" || vm.Phase == "Paused") {
+			return false
+		}
+	}
+	return true
+}
+
+// evacuable reports whether every VM on the node fits on the other nodes.
+func evacuable(state api.ClusterState, nodeID string) bool {
+	nodes := make(map[string]api.Node, len(state.Nodes))
+	for id, n := range state.Nodes {
+		nodes[id] = n
+	}
+	state.Nodes = nodes
+	var vms []api.VM
+	for _, vm := range state.VMs {
+		if vm.NodeID == nodeID {
+			vms = append(vms, vm)
+		}
+	}
+	sort.Slice(vms, func(i, j int) bool { return vms[i].ID < vms[j].ID })
+	for _, vm := range vms {
+		target, ok := scheduler.ChooseNodeExcluding(state, vm, nodeID)
+		if !ok {
+			return false
+		}
+		tn := state.Nodes[target]
+		tn.Allocated.CPU += vm.Resources.CPU
+		tn.Allocated.Memory += vm.Resources.Memory
+		tn.Allocated.Disk += vm.Resources.Disk
+		state.Nodes[target] = tn
+	}
+	return true
+}
+
+// totals sums the load and the allocatable resources of the nodes.
+func totals(nodes []api.Node, oc api.Overcommit) (used, capacity api.Resources) {
+	for _, n := range nodes {
+		l := scheduler.NodeLoad(n)
+		a := n.Allocatable(oc)
+		used.CPU += l.CPU
+		used.Memory += l.Memory
+		capacity.CPU += a.CPU
+		capacity.Memory += a.Memory
+	}
+	return used, capacity
+}
+
+// utilization is the higher of the CPU and memory shares of capacity in use.
+func utilization(used, capacity api.Resources) float64 {
+	if capacity.CPU <= 0 || capacity.Memory <= 0 {
+		return 1
+	}
+	return max(float64(used.CPU)/float64(capacity.CPU), float64(used.Memory)/float64(capacity.Memory))
+}
+
+func nodeUtilization(n api.Node, oc api.Overcommit) float64 {
+	return utilization(scheduler.NodeLoad(n), n.Allocatable(oc))
+}
+
+func (c *Controller) tick() {
+	if c.isLeader != nil && !c.isLeader() {
+		return
+	}
+	state := c.st.GetStateCopy()
+	cfg := state.Config.DPM.WithDefaults()
+	now := c.now().UTC()
+	d := Plan(state)
+	metrics.SetGauge("dpm_utilization", d.Utilization)
+
+	ids := make([]string, 0, len(state.Nodes))
+	standby := 0
+	for id, n := range state.Nodes {
+		if n.Standby != nil {
+			ids = append(ids, id)
+			if n.Standby.Phase == api.StandbyParked {
+				standby++
+			}
+		}
+	}
+	metrics.SetGauge("dpm_standby_nodes", float64(standby))
+	sort.Strings(ids)
+	for _, id := range ids {
+		n := state.Nodes[id]
+		switch n.Standby.Phase {
+		case api.StandbyEvacuating:
+			switch {
+			case !cfg.Enabled:
+				c.abort(n, "power management disabled", now)
+			case d.Abort == id:
+				c.abort(n, d.Reason, now)
+			default:
+				c.evacuate(state, n, now)
+			}
+		case api.StandbyWaking:
+			c.waking(n, now)
+		}
+	}
+	if !cfg.Enabled {
+		return
+	}
+	switch {
+	case d.Wake != "":
+		c.wake(state.Nodes[d.Wake], d.Reason, now)
+	case d.Park != "":
+		c.park(state.Nodes[d.Park], d.Reason, now)
+	}
+}
+
+// park starts evacuating a node: it is cordoned and drained.
+func (c *Controller) park(n api.Node, reason string, now time.Time) {
+	c.event(now, api.EventNormal, ReasonEvacuating, n.ID, "evacuating for standby: "+reason)
+	c.apply(store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID, Unschedulable: true}),
+		store.NewCommand("SetNodeDrain", api.DrainStatus{NodeID: n.ID, Phase: api.DrainRunning, StartedAt: now, UpdatedAt: now}),
+		store.NewCommand("SetNodeStandby", api.NodeStandby{NodeID: n.ID, Standby: &api.StandbyStatus{Phase: api.StandbyEvacuating, Since: now, Reason: reason}}))
+}
+
+// evacuate puts a node whose drain completed into standby. An operator cancelling the
+// drain or uncordoning the node aborts the standby.
+func (c *Controller) evacuate(state api.ClusterState, n api.Node, now time.Time) {
+	switch {
+	case n.Drain == nil || n.Drain.Phase == api.DrainCancelled || !n.Unschedulable:
+		c.abort(n, "drain cancelled", now)
+		return
+	case n.Drain.Phase != api.DrainCompleted:
+		return
+	}
+	for _, vm := range state.VMs {
+		if vm.NodeID == n.ID {
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	err := c.power.Power(ctx, n, ActionStandby)
+	cancel()
+	if err != nil {
+		metrics.IncCounter("dpm_power_errors_total")
+		c.event(now, api.EventWarning, ReasonPowerFailed, n.ID, fmt.Sprintf("standby with %s: %v", c.power.Name(), err))
+		c.abort(n, "standby failed", now)
+		return
+	}
+	metrics.IncCounter("dpm_standby_total")
+	c.event(now, api.EventNormal, ReasonStandby, n.ID, "in standby ("+c.power.Name()+")")
+	c.apply(store.NewCommand("SetNodeStandby", api.NodeStandby{NodeID: n.ID, Standby: &api.StandbyStatus{Phase: api.StandbyParked, Since: now, Reason: n.Standby.Reason}}))
+}
+
+// abort returns an evacuating node to service.
+func (c *Controller) abort(n api.Node, why string, now time.Time) {
+	c.event(now, api.EventNormal, ReasonAborted, n.ID, "standby aborted: "+why)
+	var cmds []store.Command
+	if n.Drain != nil && n.Drain.Phase == api.DrainRunning {
+		ds := *n.Drain
+		ds.Phase, ds.UpdatedAt = api.DrainCancelled, now
+		cmds = append(cmds, store.NewCommand("SetNodeDrain", ds))
+	}
+	cmds = append(cmds, store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID, Maintenance: n.Maintenance}),
+		store.NewCommand("SetNodeStandby", api.NodeStandby{NodeID: n.ID}))
+	c.apply(cmds...)
+}
+
+// wake asks the power hook to bring a parked node back.
+func (c *Controller) wake(n api.Node, reason string, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	err := c.power.Power(ctx, n, ActionWake)
+	cancel()
+	if err != nil {
+		metrics.IncCounter("dpm_power_errors_total")
+		c.event(now, api.EventWarning, ReasonPowerFailed, n.ID, fmt.Sprintf("wake with %s: %v", c.power.Name(), err))
+		return
+	}
+	metrics.IncCounter("dpm_wakes_total")
+	c.event(now, api.EventNormal, ReasonWaking, n.ID, "waking: "+reason)
+	c.apply(store.NewCommand("SetNodeStandby", api.NodeStandby{NodeID: n.ID, Standby: &api.StandbyStatus{Phase: api.StandbyWaking, Since: now, Reason: reason}}))
+}
+
+// waking returns a woken node to service once it is ready, and wakes it again when it
+// does not come back within WakeTimeout.
+func (c *Controller) waking(n api.Node, now time.Time) {
+	if n.Status == "Alive" && n.Ready {
+		c.event(now, api.EventNormal, ReasonWoken, n.ID, "back in service")
+		c.apply(store.NewCommand("SetNodeSchedulable", api.NodeSchedulability{NodeID: n.ID}),
+			store.NewCommand("SetNodeStandby", api.NodeStandby{NodeID: n.ID}))
+		return
+	}
+	if now.Sub(n.Standby.Since) < WakeTimeout {
+		return
+	}
+	c.wake(n, fmt.Sprintf("not ready %s after waking", WakeTimeout), now)
+}
+
+func (c *Controller) apply(cmds ...store.Command) {
+	for _, cmd := range cmds {
+		if err := c.st.Apply(context.Background(), cmd); err != nil {
+			log.Printf("dpm: %s: %v", cmd.Type, err)
+			return
+		}
+	}
+}
+
+func (c *Controller) event(now time.Time, typ, reason, object, msg string) {
+	log.Printf("dpm: %s %s: %s", reason, object, msg)
+	c.apply(store.NewCommand("RecordEvent", api.Event{Time: now, Type: typ, Reason: reason, Object: object, Message: msg}))
+}
@@ -0,0 +1,127 @@
+package dpm
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func idleCluster() api.ClusterState {
+	node := func(id string, cpu int) api.Node {
+		return api.Node{ID: id, Role: "node", Status: "Alive", Ready: true, Capabilities: []string{api.CapLiveMigrate},
+			Capacity: api.Resources{CPU: 4000, Memory: 8192}, Allocated: api.Resources{CPU: cpu, Memory: cpu * 2}}
+	}
+	vm := func(id, node string) api.VM {
+		return api.VM{ID: id, NodeID: node, Phase: "Running", Resources: api.Resources{CPU: 500, Memory: 1000}}
+	}
+	return api.ClusterState{
+		Config: api.ClusterConfig{DPM: api.DPMConfig{Enabled: true}},
+		Nodes:  map[string]api.Node{"n1": node("n1", 1000), "n2": node("n2", 500), "n3": node("n3", 1000)},
+		VMs:    map[string]api.VM{"a": vm("a", "n1"), "b": vm("b", "n1"), "c": vm("c", "n2"), "d": vm("d", "n3"), "e": vm("e", "n3")},
+	}
+}
+
+func TestPlanParksLeastLoadedNode(t *testing.T) {
+	st := idleCluster()
+	if d := Plan(st); d.Park != "n2" || d.Wake != "" || d.Abort != "" {
+		t.Fatalf("want n2 parked, got %+v", d)
+	}
+
+	// never below MinActiveNodes
+	st.Config.DPM.MinActiveNodes = 3
+	if d := Plan(st); d.Park != "" {
+		t.Fatalf("want nothing parked with 3 active nodes minimum, got %+v", d)
+	}
+
+	// a node whose running VMs cannot live-migrate stays up
+	st.Config.DPM.MinActiveNodes = 0
+	n2 := st.Nodes["n2"]
+	n2.Capabilities = nil
+	st.Nodes["n2"] = n2
+	if d := Plan(st); d.Park != "n1" {
+		t.Fatalf("want n1 parked instead, got %+v", d)
+	}
+
+	// busy clusters are left alone
+	st = idleCluster()
+	for id, n := range st.Nodes {
+		n.Usage = api.Resources{CPU: 2000, Memory: 4000}
+		st.Nodes[id] = n
+	}
+	if d := Plan(st); d.Park != "" || d.Utilization != 0.5 {
+		t.Fatalf("want no parking at 50%% utilisation, got %+v", d)
+	}
+
+	// utilisation is measured against allocatable capacity
+	st = idleCluster()
+	for id, n := range st.Nodes {
+		n.Usage = api.Resources{CPU: 1000, Memory: 2000}
+		n.Reserved = api.Resources{CPU: 2000, Memory: 4096}
+		st.Nodes[id] = n
+	}
+	if d := Plan(st); d.Park != "" || d.Utilization != 0.5 {
+		t.Fatalf("want no parking at 50%% of allocatable, got %+v", d)
+	}
+	st.Config.Overcommit = api.Overcommit{CPU: 2, Memory: 2}
+	if d := Plan(st); d.Park == "" || d.Utilization != 0.25 {
+		t.Fatalf("want a node parked at 25%% of overcommitted allocatable, got %+v", d)
+	}
+}
+
+func TestPlanWakesForPendingVMs(t *testing.T) {
+	st := idleCluster()
+	n2 := st.Nodes["n2"]
+	n2.Status, n2.Ready, n2.Unschedulable, n2.Allocated = "Failed", false, true, api.Resources{}
+	n2.Standby = &api.StandbyStatus{Phase: api.StandbyParked}
+	n2.Taints = []api.Taint{{Key: api.TaintNotReady, Effect: api.TaintNoExecute}}
+	st.Nodes["n2"] = n2
+	delete(st.VMs, "c")
+	// too big for n1 and n3, fits an empty node
+	st.VMs["big"] = api.VM{ID: "big", Phase: "Pending", Resources: api.Resources{CPU: 3500, Memory: 4096},
+		Status: &api.VMStatus{Reason: api.VMReasonFailedScheduling}}
+	if d := Plan(st); d.Wake != "n2" || !strings.Contains(d.Reason, "big") {
+		t.Fatalf("want n2 woken for big, got %+v", d)
+	}
+
+	// pressure aborts an evacuation before waking anything
+	n1 := st.Nodes["n1"]
+	n1.Standby = &api.StandbyStatus{Phase: api.StandbyEvacuating}
+	st.Nodes["n1"] = n1
+	if d := Plan(st); d.Abort != "n1" || d.Wake != "" {
+		t.Fatalf("want n1's evacuation aborted, got %+v", d)
+	}
+}
+
+func TestPlanKeepsHACapacity(t *testing.T) {
+	// three 4000 cpu nodes cover two failures; two do not
+	st := idleCluster()
+	st.Config.HA = api.HAConfig{Policy: api.HAPolicyHostFailures, HostFailures: 2}
+	if d := Plan(st); d.Park != "" {
+		t.Fatalf("want no node parked, got %+v", d)
+	}
+
+	n2 := st.Nodes["n2"]
+	n2.Unschedulable, n2.Allocated = true, api.Resources{}
+	n2.Standby = &api.StandbyStatus{Phase: api.StandbyParked}
+	st.Nodes["n2"] = n2
+	st.VMs["c"] = api.VM{ID: "c", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 500, Memory: 1000}}
+	if d := Plan(st); d.Wake != "n2" || !strings.Contains(d.Reason, "ha policy") {
+		t.Fatalf("want n2 woken for the ha policy, got %+v", d)
+	}
+}
+
+func TestExecPowerPassesAction(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	p := &ExecPower{Command: `test "$POWER_ACTION $NODE_ID" = "wake n1" || { echo "unexpected $POWER_ACTION $NODE_ID"; exit 1; }`}
+	if err := p.Power(context.Background(), api.Node{ID: "n1"}, ActionWake); err != nil {
+		t.Fatalf("wake: %v", err)
+	}
+	if err := p.Power(context.Background(), api.Node{ID: "n1"}, ActionStandby); err == nil || !strings.Contains(err.Error(), "unexpected standby n1") {
+		t.Fatalf("want failure with output, got %v", err)
+	}
+}
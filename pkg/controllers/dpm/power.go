@@ -0,0 +1,79 @@
+package dpm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+// Power actions passed to a PowerManager.
+const (
+	ActionStandby = "standby"
+	ActionWake    = "wake"
+)
+
+// PowerManager puts nodes into standby and wakes them again, e.g. through IPMI,
+// wake-on-LAN or a cloud API.
+type PowerManager interface {
+	// Name identifies the power method in events.
+	Name() string
+	// Power performs ActionStandby or ActionWake on the node. Wake may return before the
+	// node is back; the controller waits for it to become ready.
+	Power(ctx context.Context, n api.Node, action string) error
+}
+
+// ExecPower runs an operator command with POWER_ACTION (standby or wake), NODE_ID and
+// NODE_ADDRESS in its environment; exit status 0 means done.
+type ExecPower struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (p *ExecPower) Name() string { return "exec" }
+
+func (p *ExecPower) Power(ctx context.Context, n api.Node, action string) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", p.Command)
+	cmd.Env = append(os.Environ(), "POWER_ACTION="+action, "NODE_ID="+n.ID, "NODE_ADDRESS="+n.Address)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// LocalPower only records power actions and leaves nodes running. It stands in for a real
+// power hook in tests and development clusters: parked nodes keep their agent, so they
+// are ready again as soon as they are woken.
+type LocalPower struct {
+	mu      sync.Mutex
+	actions []string
+}
+
+func (p *LocalPower) Name() string { return "local" }
+
+func (p *LocalPower) Power(_ context.Context, n api.Node, action string) error {
+	log.Printf("dpm: %s node %s (local power, nothing to do)", action, n.ID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions = append(p.actions, action+" "+n.ID)
+	return nil
+}
+
+// Actions returns the recorded actions as "<action> <node>", oldest first.
+func (p *LocalPower) Actions() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.actions...)
+}
@@ -0,0 +1,165 @@
+package drain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/metrics"
+	"clustering/pkg/scheduler"
+	"clustering/pkg/store"
+)
+
+// DefaultMaxParallel bounds concurrent moves when a drain does not set MaxParallel.
+const DefaultMaxParallel = 2
+
+// Controller moves the VMs off draining nodes, highest priority first and at most
+// MaxParallel at a time. VMs live-migrate when the node is alive and supports it and
+// restart elsewhere otherwise. The node stays cordoned after the drain completes.
+type Controller struct {
+	st       *store.Manager
+	interval time.Duration
+	isLeader func() bool
+	now      func() time.Time
+}
+
+func NewController(st *store.Manager, isLeader func() bool) *Controller {
+	return &Controller{st: st, interval: 5 * time.Second, isLeader: isLeader, now: time.Now}
+}
+
+func (c *Controller) Run(stop <-chan struct{}) {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			c.tick()
+		}
+	}
+}
+
+func (c *Controller) tick() {
+	if c.isLeader != nil && !c.isLeader() {
+		return
+	}
+	state := c.st.GetStateCopy()
+	ids := make([]string, 0, len(state.Nodes))
+	for id, n := range state.Nodes {
+		if n.Drain != nil && n.Drain.Phase == api.DrainRunning {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ds, moves := Step(state, id, c.now().UTC())
+		for _, vm := range moves {
+			log.Printf("drain %s: moving vm %s to %s (%s)", id, vm.ID, vm.NodeID, vm.Phase)
+			if err := c.st.Apply(context.Background(), store.NewCommand("BindVM", vm)); err != nil {
+				log.Printf("drain %s: move vm %s: %v", id, vm.ID, err)
+				ds.InFlight = slices.DeleteFunc(ds.InFlight, func(s string) bool { return s == vm.ID })
+				continue
+			}
+			metrics.IncCounter("drain_vm_moves_total")
+		}
+		if drainChanged(*state.Nodes[id].Drain, ds) {
+			if err := c.st.Apply(context.Background(), store.NewCommand("SetNodeDrain", ds)); err != nil {
+				log.Printf("drain %s: record progress: %v", id, err)
+			}
+		}
+		if ds.Phase == api.DrainCompleted {
+			log.Printf("drain %s: completed, %d vms moved", id, ds.Moved)
+		}
+	}
+}
+
+// Step advances the drain of one node: it settles finished moves and picks the next VMs
+// to move. It returns the new drain status and the VMs to upsert; state is updated with
+// the moves so later decisions in the same pass see them.
+func Step(state api.ClusterState, nodeID string, now time.Time) (api.DrainStatus, []api.VM) {
+	n := state.Nodes[nodeID]
+	ds := *n.Drain
+	ds.UpdatedAt, ds.Message = now, ""
+	if ds.MaxParallel <= 0 {
+		ds.MaxParallel = DefaultMaxParallel
+	}
+
+	// a move is finished once the VM left Migrating/Scheduled on its new node (or is gone)
+	var inFlight []string
+	for _, id := range ds.InFlight {
+		vm, ok := state.VMs[id]
+		if ok && vm.NodeID != nodeID && (vm.Phase == "Migrating" || vm.Phase == "Scheduled") {
+			inFlight = append(inFlight, id)
+			continue
+		}
+		ds.Moved++
+	}
+	ds.InFlight = inFlight
+
+	var remaining []api.VM
+	for _, vm := range state.VMs {
+		if vm.NodeID == nodeID {
+			remaining = append(remaining, vm)
+		}
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		if remaining[i].Policy.Priority != remaining[j].Policy.Priority {
+			return remaining[i].Policy.Priority > remaining[j].Policy.Priority
+		}
+		return remaining[i].ID < remaining[j].ID
+	})
+
+	var moves []api.VM
+	var stuck []string
+	for _, vm := range remaining {
+		if len(ds.InFlight) >= ds.MaxParallel {
+			break
+		}
+		target, ok := scheduler.ChooseNodeExcluding(state, vm, nodeID)
+		if !ok {
+			stuck = append(stuck, vm.ID)
+			continue
+		}
+		if liveMigratable(n, vm) {
+			vm.Phase = "Migrating"
+		} else {
+			vm.Phase = "Scheduled"
+		}
+		vm.NodeID = target
+		moves = append(moves, vm)
+		ds.InFlight = append(ds.InFlight, vm.ID)
+		state.VMs[vm.ID] = vm
+		tn := state.Nodes[target]
+		tn.Allocated.CPU += vm.Resources.CPU
+		tn.Allocated.Memory += vm.Resources.Memory
+		tn.Allocated.Disk += vm.Resources.Disk
+		state.Nodes[target] = tn
+	}
+	if len(stuck) > 0 {
+		ds.Message = fmt.Sprintf("no node fits vms %v", stuck)
+	}
+
+	ds.Total = max(ds.Total, ds.Moved+len(ds.InFlight)+len(remaining)-len(moves))
+	if len(remaining) == 0 && len(ds.InFlight) == 0 {
+		ds.Phase = api.DrainCompleted
+	}
+	return ds, moves
+}
+
+// liveMigratable reports whether the VM can move without a restart.
+func liveMigratable(n api.Node, vm api.VM) bool {
+	if n.Status != "Alive" || (vm.Phase != "Running" && vm.Phase != "Paused") {
+		return false
+	}
+	return slices.Contains(n.Capabilities, api.CapLiveMigrate)
+}
+
+func drainChanged(a, b api.DrainStatus) bool {
+	return a.Phase != b.Phase || a.MaxParallel != b.MaxParallel || a.Total != b.Total || a.Moved != b.Moved ||
+		a.Message != b.Message || !slices.Equal(a.InFlight, b.InFlight)
+}
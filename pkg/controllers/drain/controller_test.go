@@ -0,0 +1,68 @@
+package drain
+
+import (
+	"testing"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+func TestStepMovesByPriorityWithinLimit(t *testing.T) {
+	now := time.Now()
+	st := api.ClusterState{
+		Nodes: map[string]api.Node{
+			"n1": {ID: "n1", Status: "Alive", Unschedulable: true, Capabilities: []string{"live-migrate"}, Capacity: api.Resources{CPU: 4000, Memory: 4096},
+				Drain: &api.DrainStatus{NodeID: "n1", Phase: api.DrainRunning, MaxParallel: 2}},
+			"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}},
+		},
+		VMs: map[string]api.VM{
+			"low":     {ID: "low", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 100}},
+			"high":    {ID: "high", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 100}, Policy: api.VMSchedulingPolicy{Priority: 10}},
+			"stopped": {ID: "stopped", NodeID: "n1", Phase: "Stopped", Resources: api.Resources{CPU: 100}, Policy: api.VMSchedulingPolicy{Priority: 5}},
+		},
+	}
+	ds, moves := Step(st, "n1", now)
+	if len(moves) != 2 || moves[0].ID != "high" || moves[1].ID != "stopped" {
+		t.Fatalf("want high then stopped, got %+v", moves)
+	}
+	if moves[0].Phase != "Migrating" || moves[1].Phase != "Scheduled" || moves[0].NodeID != "n2" {
+		t.Fatalf("running vms live-migrate, others restart: %+v", moves)
+	}
+	if ds.Total != 3 || ds.Phase != api.DrainRunning || len(ds.InFlight) != 2 {
+		t.Fatalf("unexpected status: %+v", ds)
+	}
+
+	// high finished migrating; stopped is still being placed
+	n1 := st.Nodes["n1"]
+	n1.Drain = &ds
+	st.Nodes["n1"] = n1
+	high := st.VMs["high"]
+	high.Phase = "Running"
+	st.VMs["high"] = high
+	ds, moves = Step(st, "n1", now)
+	if ds.Moved != 1 || len(moves) != 1 || moves[0].ID != "low" {
+		t.Fatalf("want low moved after high finished: %+v %+v", ds, moves)
+	}
+
+	for _, id := range []string{"low", "stopped"} {
+		vm := st.VMs[id]
+		vm.Phase = "Running"
+		st.VMs[id] = vm
+	}
+	n1.Drain = &ds
+	st.Nodes["n1"] = n1
+	if ds, _ = Step(st, "n1", now); ds.Phase != api.DrainCompleted || ds.Moved != 3 || ds.Total != 3 {
+		t.Fatalf("want completed: %+v", ds)
+	}
+}
+
+func TestStepReportsStuckVMs(t *testing.T) {
+	st := api.ClusterState{
+		Nodes: map[string]api.Node{"n1": {ID: "n1", Status: "Alive", Drain: &api.DrainStatus{NodeID: "n1", Phase: api.DrainRunning}}},
+		VMs:   map[string]api.VM{"a": {ID: "a", NodeID: "n1", Phase: "Running"}},
+	}
+	ds, moves := Step(st, "n1", time.Now())
+	if len(moves) != 0 || ds.Phase != api.DrainRunning || ds.Message == "" || ds.MaxParallel != DefaultMaxParallel {
+		t.Fatalf("unexpected: %+v %+v", ds, moves)
+	}
+}
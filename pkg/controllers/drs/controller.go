@@ -0,0 +1,160 @@
+package drs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/metrics"
+	"clustering/pkg/scheduler"
+	"clustering/pkg/store"
+)
+
+const (
+	// ReasonRebalance is the event reason of a migration started by the rebalancer.
+	ReasonRebalance = "DRSMigration"
+	// ReasonStaleMigration is the event reason of a migration that did not finish within
+	// MigrationTimeout.
+	ReasonStaleMigration = "DRSMigrationStale"
+)
+
+// MigrationTimeout is how long a VM may stay Migrating before it no longer holds back
+// new migrations.
+const MigrationTimeout = 10 * time.Minute
+
+// Controller periodically plans migrations that even out node utilisation (see
+// scheduler.Rebalance). In Manual mode it only publishes the imbalance and the number of
+// recommendations as metrics; in Partial mode it starts the leading migrations that
+// relieve overloaded nodes and in Automated mode all of them. A pass starts nothing while
+// earlier migrations are still in flight, so usage reports catch up first; a migration
+// still in flight after MigrationTimeout is reported and no longer waited for.
+type Controller struct {
+	st       *store.Manager
+	interval time.Duration
+	isLeader func() bool
+	now      func() time.Time
+	// migrating holds when each Migrating VM was first seen migrating.
+	migrating map[string]migration
+}
+
+type migration struct {
+	since time.Time
+	stale bool
+}
+
+func NewController(st *store.Manager, isLeader func() bool) *Controller {
+	return &Controller{st: st, interval: time.Minute, isLeader: isLeader, now: time.Now, migrating: map[string]migration{}}
+}
+
+func (c *Controller) Run(stop <-chan struct{}) {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			c.tick()
+		}
+	}
+}
+
+func (c *Controller) tick() {
+	if c.isLeader != nil && !c.isLeader() {
+		return
+	}
+	state := c.st.GetStateCopy()
+	now := c.now().UTC()
+	plan := scheduler.Rebalance(state)
+	metrics.SetGauge("drs_imbalance", plan.Imbalance)
+	metrics.SetGauge("drs_recommendations", float64(len(plan.Migrations)))
+	for _, m := range toStart(state, plan, c.stale(state, now)) {
+		if err := Migrate(context.Background(), c.st, state, m, now); err != nil {
+			log.Printf("drs: %v", err)
+			return
+		}
+	}
+}
+
+// stale returns the VMs that have been migrating for longer than MigrationTimeout. A
+// warning event is recorded when a migration goes stale.
+func (c *Controller) stale(state api.ClusterState, now time.Time) map[string]bool {
+	migrating := map[string]migration{}
+	stale := map[string]bool{}
+	for id, vm := range state.VMs {
+		if vm.Phase != "Migrating" {
+			continue
+		}
+		m, seen := c.migrating[id]
+		if !seen {
+			m.since = now
+		}
+		if now.Sub(m.since) >= MigrationTimeout {
+			stale[id] = true
+			if !m.stale {
+				log.Printf("drs: vm %s still migrating to %s after %s", id, vm.NodeID, MigrationTimeout)
+				ev := api.Event{Time: now, Type: api.EventWarning, Reason: ReasonStaleMigration, Object: id,
+					Message: fmt.Sprintf("migration to %s not finished after %s, no longer holding back rebalancing", vm.NodeID, MigrationTimeout)}
+				if err := c.st.Apply(context.Background(), store.NewCommand("RecordEvent", ev)); err != nil {
+					log.Printf("drs: record event for vm %s: %v", id, err)
+				}
+			}
+			m.stale = true
+		}
+		migrating[id] = m
+	}
+	c.migrating = migrating
+	metrics.SetGauge("drs_stale_migrations", float64(len(stale)))
+	return stale
+}
+
+// toStart returns the migrations of plan the DRS mode lets the controller start: none
+// in Manual mode or while a VM other than the stale ones is still migrating, the leading
+// hot ones in Partial mode (later migrations were planned on top of the earlier ones)
+// and all in Automated mode.
+func toStart(state api.ClusterState, plan scheduler.RebalancePlan, stale map[string]bool) []scheduler.Migration {
+	if plan.Mode == api.DRSManual {
+		return nil
+	}
+	for id, vm := range state.VMs {
+		if vm.Phase == "Migrating" && !stale[id] {
+			return nil
+		}
+	}
+	if plan.Mode == api.DRSAutomated {
+		return plan.Migrations
+	}
+	n := 0
+	for n < len(plan.Migrations) && plan.Migrations[n].Hot {
+		n++
+	}
+	return plan.Migrations[:n]
+}
+
+// Applier commits commands to the replicated store.
+type Applier interface {
+	Apply(context.Context, store.Command) error
+}
+
+// Migrate starts a recommended migration: the VM is bound to the target node as
+// Migrating and an event records why.
+func Migrate(ctx context.Context, st Applier, state api.ClusterState, m scheduler.Migration, now time.Time) error {
+	vm, ok := state.VMs[m.VMID]
+	if !ok || vm.NodeID != m.From {
+		return fmt.Errorf("vm %s is no longer on %s", m.VMID, m.From)
+	}
+	log.Printf("drs: migrating vm %s from %s to %s", vm.ID, m.From, m.To)
+	vm.NodeID, vm.Phase = m.To, "Migrating"
+	if err := st.Apply(ctx, store.NewCommand("BindVM", vm)); err != nil {
+		return fmt.Errorf("migrate vm %s to %s: %w", vm.ID, m.To, err)
+	}
+	metrics.IncCounter("drs_migrations_total")
+	ev := api.Event{Time: now, Type: api.EventNormal, Reason: ReasonRebalance, Object: vm.ID,
+		Message: fmt.Sprintf("rebalancing from %s to %s, imbalance %.3f after", m.From, m.To, m.Imbalance)}
+	if err := st.Apply(ctx, store.NewCommand("RecordEvent", ev)); err != nil {
+		log.Printf("drs: record event for vm %s: %v", vm.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package drs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/scheduler"
+	"clustering/pkg/store"
+)
+
+func TestToStartFollowsMode(t *testing.T) {
+	migs := []scheduler.Migration{{VMID: "a", Hot: true}, {VMID: "b"}, {VMID: "c", Hot: true}}
+	idle := api.ClusterState{VMs: map[string]api.VM{"a": {ID: "a", Phase: "Running"}}}
+	busy := api.ClusterState{VMs: map[string]api.VM{"x": {ID: "x", Phase: "Migrating"}}}
+	cases := []struct {
+		mode  string
+		state api.ClusterState
+		want  int
+	}{
+		{api.DRSManual, idle, 0},
+		{api.DRSPartial, idle, 1},
+		{api.DRSAutomated, idle, 3},
+		{api.DRSAutomated, busy, 0},
+	}
+	for _, tc := range cases {
+		got := toStart(tc.state, scheduler.RebalancePlan{Mode: tc.mode, Migrations: migs}, nil)
+		if len(got) != tc.want {
+			t.Errorf("%s: started %d migrations, want %d", tc.mode, len(got), tc.want)
+		}
+	}
+}
+
+func TestStaleMigrationStopsBlocking(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	c := NewController(store.NewManager(nil), nil)
+	state := api.ClusterState{VMs: map[string]api.VM{"x": {ID: "x", NodeID: "n2", Phase: "Migrating"}}}
+	plan := scheduler.RebalancePlan{Mode: api.DRSAutomated, Migrations: []scheduler.Migration{{VMID: "a"}}}
+	if got := toStart(state, plan, c.stale(state, t0)); len(got) != 0 {
+		t.Fatalf("started %d migrations while x is migrating", len(got))
+	}
+	if got := toStart(state, plan, c.stale(state, t0.Add(MigrationTimeout-time.Second))); len(got) != 0 {
+		t.Fatalf("started %d migrations before x went stale", len(got))
+	}
+	if got := toStart(state, plan, c.stale(state, t0.Add(MigrationTimeout))); len(got) != 1 {
+		t.Fatalf("started %d migrations after x went stale, want 1", len(got))
+	}
+	// a VM that migrates again is timed afresh
+	c.stale(api.ClusterState{}, t0.Add(MigrationTimeout))
+	if got := toStart(state, plan, c.stale(state, t0.Add(2*MigrationTimeout))); len(got) != 0 {
+		t.Fatalf("started %d migrations while x is migrating again", len(got))
+	}
+}
+
+type fakeApplier struct {
+	cmds    []store.Command
+	bindErr error
+}
+
+func (f *fakeApplier) Apply(_ context.Context, cmd store.Command) error {
+	if cmd.Type == "BindVM" && f.bindErr != nil {
+		return f.bindErr
+	}
+	f.cmds = append(f.cmds, cmd)
+	return nil
+}
+
+func TestMigrateBindsAndRecordsEvent(t *testing.T) {
+	state := api.ClusterState{VMs: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Phase: "Running"}}}
+	m := scheduler.Migration{VMID: "a", From: "n1", To: "n2", Imbalance: 0.05}
+	st := &fakeApplier{}
+	if err := Migrate(context.Background(), st, state, m, time.Unix(1000, 0)); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if len(st.cmds) != 2 || st.cmds[0].Type != "BindVM" || st.cmds[1].Type != "RecordEvent" {
+		t.Fatalf("applied %+v, want a bind and an event", st.cmds)
+	}
+	var vm api.VM
+	_ = json.Unmarshal(st.cmds[0].Payload, &vm)
+	if vm.NodeID != "n2" || vm.Phase != "Migrating" {
+		t.Fatalf("bound %+v, want a to migrate to n2", vm)
+	}
+	var ev api.Event
+	_ = json.Unmarshal(st.cmds[1].Payload, &ev)
+	if ev.Reason != ReasonRebalance || ev.Object != "a" {
+		t.Fatalf("recorded %+v", ev)
+	}
+
+	// a VM that moved since the plan is left alone
+	m.From = "n3"
+	if err := Migrate(context.Background(), st, state, m, time.Unix(1000, 0)); err == nil || len(st.cmds) != 2 {
+		t.Fatalf("migrated a vm that is not on n3: %v", err)
+	}
+}
+
+func TestMigrateRejectedBind(t *testing.T) {
+	state := api.ClusterState{VMs: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Phase: "Running"}}}
+	m := scheduler.Migration{VMID: "a", From: "n1", To: "n2"}
+	st := &fakeApplier{bindErr: store.ErrBindConflict}
+	err := Migrate(context.Background(), st, state, m, time.Unix(1000, 0))
+	if !errors.Is(err, store.ErrBindConflict) {
+		t.Fatalf("want a bind conflict, got %v", err)
+	}
+	if len(st.cmds) != 0 {
+		t.Fatalf("rejected migration applied %+v", st.cmds)
+	}
+}
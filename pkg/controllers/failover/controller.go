@@ -1,23 +1,64 @@
 package failover
 
 import (
-	"clustering/pkg/store"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/controllers/heartbeat"
+	"clustering/pkg/metrics"
+	"clustering/pkg/scheduler"
+	"clustering/pkg/store"
 )
 
-type StateReader interface {
-	GetStateCopy() interface{}
-}
+// Event reasons recorded by the controller.
+const (
+	ReasonFencing       = "FencingStarted"
+	ReasonFenced        = "NodeFenced"
+	ReasonFenceFailed   = "FencingFailed"
+	ReasonRestarted     = "FailoverRestart"
+	ReasonNoPlacement   = "FailoverPending"
+	ReasonNodeRecovered = "NodeRecovered"
+)
+
+// DefaultGrace is how long a node must be NotReady before failover starts.
+const DefaultGrace = 30 * time.Second
 
+// Controller restarts the VMs of failed agent nodes elsewhere. A node that has been
+// NotReady for longer than the grace period because it lost contact is fenced first;
+// only then are its VMs placed on healthy nodes, highest Policy.Priority first. Nodes
+// that are reachable but fail their health checks are left to the taint controller,
+// which live-migrates their VMs.
 type Controller struct {
 	st       *store.Manager
 	interval time.Duration
+	grace    time.Duration
+	fencer   Fencer
 	isLeader func() bool
+	now      func() time.Time
+	// pending remembers VMs already reported as unplaceable, to record that event once.
+	pending map[string]bool
 }
 
 func NewController(st *store.Manager, isLeader func() bool) *Controller {
-	return &Controller{st: st, interval: 10 * time.Second, isLeader: isLeader}
+	return &Controller{st: st, interval: 10 * time.Second, grace: DefaultGrace, fencer: NewSelfFence(time.Minute),
+		isLeader: isLeader, now: time.Now, pending: map[string]bool{}}
+}
+
+// WithGrace sets how long a node must be NotReady before it is fenced.
+func (c *Controller) WithGrace(d time.Duration) *Controller {
+	c.grace = d
+	return c
+}
+
+// WithFencer replaces the default self-fence fencer.
+func (c *Controller) WithFencer(f Fencer) *Controller {
+	c.fencer = f
+	return c
 }
 
 func (c *Controller) Run(stop <-chan struct{}) {
@@ -28,11 +69,185 @@ func (c *Controller) Run(stop <-chan struct{}) {
 		case <-stop:
 			return
 		case <-t.C:
-			if c.isLeader != nil && !c.isLeader() {
+			c.tick()
+		}
+	}
+}
+
+func (c *Controller) tick() {
+	if c.isLeader != nil && !c.isLeader() {
+		return
+	}
+	state := c.st.GetStateCopy()
+	ids := make([]string, 0, len(state.Nodes))
+	for id, n := range state.Nodes {
+		if n.Role == "node" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	now := c.now().UTC()
+	for _, id := range ids {
+		n := state.Nodes[id]
+		ready, ok := n.Condition(api.NodeReady)
+		if !ok || ready.Status != api.ConditionFalse {
+			if n.Fence != nil {
+				c.apply(store.NewCommand("SetNodeFence", api.NodeFence{NodeID: id}))
+				c.event(now, api.EventNormal, ReasonNodeRecovered, id, "node is ready again; fence cleared")
+			}
+			continue
+		}
+		if !lostContact(ready) {
+			if n.Fence != nil && n.Fence.Phase != api.FenceDone {
+				c.apply(store.NewCommand("SetNodeFence", api.NodeFence{NodeID: id}))
+				c.event(now, api.EventNormal, ReasonNodeRecovered, id, "node is reachable again; fence cleared")
+			}
+			continue
+		}
+		if now.Sub(ready.LastTransitionTime) < c.grace {
+			continue
+		}
+		vms := VMsOn(state, id)
+		if len(vms) == 0 {
+			continue
+		}
+		if n.Fence == nil || n.Fence.Phase != api.FenceDone {
+			if !c.fence(n, ready, now) {
 				continue
 			}
-			// TODO: inspect state for NotReady nodes and reassign VMs
-			log.Printf("failover: tick")
 		}
+		c.restart(state, id, vms, now)
 	}
 }
+
+// lostContact reports whether a NotReady node is unreachable: serf does not see it alive
+// or its agent stopped heartbeating. A reachable node cannot be fenced by waiting for its
+// agent to self-fence.
+func lostContact(ready api.NodeCondition) bool {
+	return ready.Reason != heartbeat.ReasonUnhealthy
+}
+
+// fence runs the fencer against n and records the outcome; it reports whether the node
+// is fenced.
+func (c *Controller) fence(n api.Node, ready api.NodeCondition, now time.Time) bool {
+	fs := api.FenceStatus{Phase: api.FencePending, Method: c.fencer.Name(), Since: now}
+	if n.Fence != nil {
+		fs.Since, fs.Attempts = n.Fence.Since, n.Fence.Attempts
+	} else {
+		c.event(now, api.EventWarning, ReasonFencing, n.ID, fmt.Sprintf("node NotReady (%s) since %s; fencing with %s", ready.Reason, ready.LastTransitionTime.Format(time.RFC3339), fs.Method))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	err := c.fencer.Fence(ctx, n)
+	cancel()
+	fs.Attempts++
+	switch {
+	case err == nil:
+		fs.Phase, fs.Message = api.FenceDone, ""
+		metrics.IncCounter("failover_fenced_total")
+		c.event(now, api.EventNormal, ReasonFenced, n.ID, "fenced with "+fs.Method)
+	case errors.Is(err, ErrFencePending):
+		fs.Attempts-- // waiting is not an attempt
+		fs.Message = err.Error()
+	default:
+		fs.Phase, fs.Message = api.FenceFailed, err.Error()
+		metrics.IncCounter("failover_fence_errors_total")
+		if n.Fence == nil || n.Fence.Message != fs.Message {
+			c.event(now, api.EventWarning, ReasonFenceFailed, n.ID, fs.Message)
+		}
+	}
+	if n.Fence == nil || *n.Fence != fs {
+		c.apply(store.NewCommand("SetNodeFence", api.NodeFence{NodeID: n.ID, Fence: &fs}))
+	}
+	return fs.Phase == api.FenceDone
+}
+
+// restart places the VMs of a fenced node on other nodes.
+func (c *Controller) restart(state api.ClusterState, nodeID string, vms []api.VM, now time.Time) {
+	for _, vm := range vms {
+		target, ok := scheduler.ChooseNodeExcluding(state, vm, nodeID)
+		if !ok {
+			if !c.pending[vm.ID] {
+				c.pending[vm.ID] = true
+				c.event(now, api.EventWarning, ReasonNoPlacement, vm.ID, "no node fits vm from failed node "+nodeID)
+			}
+			continue
+		}
+		delete(c.pending, vm.ID)
+		vm.NodeID = target
+		st := api.VMStatus{VMID: vm.ID, NodeID: target, ObservedAt: now, Restarts: vm.Restarts()}
+		if vm.Status != nil {
+			st.LastRestartAt = vm.Status.LastRestartAt
+		}
+		msg := fmt.Sprintf("restarted on %s after %s failed", target, nodeID)
+		switch reason := restartBlocked(vm); reason {
+		case "":
+			vm.Phase = "Scheduled"
+			st.Restarts++
+			st.LastRestartAt = now
+		case api.VMReasonRestartPolicy, api.VMReasonRestartLimit:
+			// the target's agent keeps a VM with this reason down
+			vm.Phase, msg = "Failed", fmt.Sprintf("moved to %s (not restarted: %s) after %s failed", target, reason, nodeID)
+			st.Reason = reason
+		default:
+			vm.Phase, msg = "Stopped", fmt.Sprintf("moved to %s (not restarted) after %s failed", target, nodeID)
+		}
+		st.Phase, st.Message = vm.Phase, msg
+		vm.Status = &st
+		if !c.apply(store.NewCommand("BindVM", vm)) {
+			continue
+		}
+		if vm.Phase == "Scheduled" {
+			metrics.IncCounter("failover_vm_restarts_total")
+		}
+		c.event(now, api.EventNormal, ReasonRestarted, vm.ID, msg)
+		state.VMs[vm.ID] = vm
+		tn := state.Nodes[target]
+		tn.Allocated.CPU += vm.Resources.CPU
+		tn.Allocated.Memory += vm.Resources.Memory
+		tn.Allocated.Disk += vm.Resources.Disk
+		state.Nodes[target] = tn
+	}
+}
+
+// Restartable reports whether failover should start the VM again; other VMs are only
+// moved.
+func Restartable(vm api.VM) bool { return restartBlocked(vm) == "" }
+
+// restartBlocked returns why a VM from a failed node is not restarted: it is meant to be
+// stopped ("Stopped"), its restart policy is Never, or its restarts are exhausted.
+func restartBlocked(vm api.VM) string {
+	switch {
+	case vm.DesiredState == "Stopped":
+		return "Stopped"
+	case !vm.Restart.RestartsAfter(true):
+		return api.VMReasonRestartPolicy
+	case vm.Restart.Exhausted(vm.Restarts()):
+		return api.VMReasonRestartLimit
+	}
+	return ""
+}
+
+// VMsOn returns the VMs assigned to a node in restart order: highest HA priority first,
+// dependencies before the VMs depending on them.
+func VMsOn(state api.ClusterState, nodeID string) []api.VM {
+	var out []api.VM
+	for _, vm := range state.VMs {
+		if vm.NodeID == nodeID {
+			out = append(out, vm)
+		}
+	}
+	return api.StartOrder(out)
+}
+
+func (c *Controller) apply(cmd store.Command) bool {
+	if err := c.st.Apply(context.Background(), cmd); err != nil {
+		log.Printf("failover: %s: %v", cmd.Type, err)
+		return false
+	}
+	return true
+}
+
+func (c *Controller) event(now time.Time, typ, reason, object, msg string) {
+	log.Printf("failover: %s %s: %s", reason, object, msg)
+	c.apply(store.NewCommand("RecordEvent", api.Event{Time: now, Type: typ, Reason: reason, Object: object, Message: msg}))
+}
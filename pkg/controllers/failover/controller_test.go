@@ -0,0 +1,189 @@
+package failover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"clustering/pkg/api"
+	"clustering/pkg/store"
+)
+
+func mkLog(c store.Command) *raft.Log { b, _ := json.Marshal(c); return &raft.Log{Data: b} }
+
+type fakeFencer struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeFencer) Name() string { return "fake" }
+func (f *fakeFencer) Fence(_ context.Context, n api.Node) error {
+	f.calls = append(f.calls, n.ID)
+	return f.err
+}
+
+func failedNode(id string, since time.Time) api.Node {
+	return api.Node{ID: id, Role: "node", Status: "Failed",
+		Conditions: []api.NodeCondition{{Type: api.NodeReady, Status: api.ConditionFalse, Reason: "SerfNotAlive", LastTransitionTime: since}}}
+}
+
+func newTestController(t *testing.T, state api.ClusterState, now time.Time) (*Controller, *fakeFencer) {
+	t.Helper()
+	fsm := store.NewFSM()
+	for _, n := range state.Nodes {
+		fsm.Apply(mkLog(store.NewCommand("UpsertNode", n)))
+		if n.Conditions != nil {
+			fsm.Apply(mkLog(store.NewCommand("SetNodeConditions", api.NodeConditions{NodeID: n.ID, Conditions: n.Conditions})))
+		}
+	}
+	for _, vm := range state.VMs {
+		fsm.Apply(mkLog(store.NewCommand("UpsertVM", vm)))
+	}
+	m := store.NewManager(nil)
+	m.SetFSM(fsm)
+	f := &fakeFencer{}
+	c := NewController(m, nil).WithGrace(30 * time.Second).WithFencer(f)
+	c.now = func() time.Time { return now }
+	return c, f
+}
+
+func TestFailoverFencesOnlyAfterGraceAndWithVMs(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := api.ClusterState{
+		Nodes: map[string]api.Node{"n1": failedNode("n1", t0), "n2": failedNode("n2", t0)},
+		VMs:   map[string]api.VM{"vm1": {ID: "vm1", NodeID: "n1"}},
+	}
+	c, f := newTestController(t, state, t0.Add(10*time.Second))
+	c.tick()
+	if len(f.calls) != 0 {
+		t.Fatalf("fenced within grace: %v", f.calls)
+	}
+	c.now = func() time.Time { return t0.Add(time.Minute) }
+	c.tick()
+	if len(f.calls) != 1 || f.calls[0] != "n1" {
+		t.Fatalf("want only n1 (has vms) fenced, got %v", f.calls)
+	}
+}
+
+func TestFailoverDoesNotRestartUnfenced(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := api.ClusterState{
+		Nodes: map[string]api.Node{
+			"n1": failedNode("n1", t0),
+			"n2": {ID: "n2", Role: "node", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}},
+		},
+		VMs: map[string]api.VM{"vm1": {ID: "vm1", NodeID: "n1"}},
+	}
+	c, f := newTestController(t, state, t0.Add(time.Minute))
+	f.err = errors.New("ipmi timeout")
+	c.tick()
+	if len(c.pending) != 0 || len(f.calls) != 1 {
+		t.Fatalf("unexpected: calls %v pending %v", f.calls, c.pending)
+	}
+	// a failed fence must not be treated as done
+	n := c.st.GetStateCopy().Nodes["n1"]
+	if ok := c.fence(n, n.Conditions[0], t0.Add(time.Minute)); ok {
+		t.Fatal("fence reported success")
+	}
+}
+
+func TestFailoverLeavesReachableUnhealthyNodes(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	unhealthy := api.Node{ID: "n1", Role: "node", Status: "Alive", LastHeartbeat: t0.Add(time.Minute),
+		Conditions: []api.NodeCondition{{Type: api.NodeReady, Status: api.ConditionFalse, Reason: "HealthChecksFailing", LastTransitionTime: t0}}}
+	state := api.ClusterState{
+		Nodes: map[string]api.Node{"n1": unhealthy},
+		VMs:   map[string]api.VM{"vm1": {ID: "vm1", NodeID: "n1"}},
+	}
+	c, f := newTestController(t, state, t0.Add(time.Minute))
+	c.tick()
+	if len(f.calls) != 0 || c.st.GetStateCopy().Nodes["n1"].Fence != nil {
+		t.Fatalf("fenced a node that still heartbeats: calls %v", f.calls)
+	}
+
+	// nor is a fence started while the node was unreachable carried on once it is back
+	unhealthy.Fence = &api.FenceStatus{Phase: api.FencePending, Method: "fake", Since: t0}
+	state.Nodes["n1"] = unhealthy
+	c, f = newTestController(t, state, t0.Add(time.Minute))
+	c.tick()
+	if len(f.calls) != 0 {
+		t.Fatalf("kept fencing a reachable node: calls %v", f.calls)
+	}
+}
+
+func TestSelfFenceWaitsForAgentTimeout(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewSelfFence(time.Minute)
+	n := api.Node{ID: "n1", LastHeartbeat: t0}
+	f.now = func() time.Time { return t0.Add(30 * time.Second) }
+	if err := f.Fence(context.Background(), n); !errors.Is(err, ErrFencePending) {
+		t.Fatalf("want pending got %v", err)
+	}
+	f.now = func() time.Time { return t0.Add(time.Minute) }
+	if err := f.Fence(context.Background(), n); err != nil {
+		t.Fatalf("want fenced got %v", err)
+	}
+}
+
+func TestExecFencePassesNode(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	f := &ExecFence{Command: `test "$NODE_ID" = n1 || { echo "wrong node $NODE_ID"; exit 1; }`}
+	if err := f.Fence(context.Background(), api.Node{ID: "n1"}); err != nil {
+		t.Fatalf("fence: %v", err)
+	}
+	if err := f.Fence(context.Background(), api.Node{ID: "n2"}); err == nil || !strings.Contains(err.Error(), "wrong node n2") {
+		t.Fatalf("want failure with output, got %v", err)
+	}
+}
+
+func TestVMsOnOrdersByPriority(t *testing.T) {
+	state := api.ClusterState{VMs: map[string]api.VM{
+		"a": {ID: "a", NodeID: "n1"},
+		"b": {ID: "b", NodeID: "n1", Policy: api.VMSchedulingPolicy{Priority: 5}},
+		"c": {ID: "c", NodeID: "n2", Policy: api.VMSchedulingPolicy{Priority: 9}},
+	}}
+	vms := VMsOn(state, "n1")
+	if len(vms) != 2 || vms[0].ID != "b" || vms[1].ID != "a" {
+		t.Fatalf("unexpected order: %+v", vms)
+	}
+}
+
+func TestVMsOnStartsDependenciesFirst(t *testing.T) {
+	state := api.ClusterState{VMs: map[string]api.VM{
+		"app": {ID: "app", NodeID: "n1", Restart: api.RestartPolicy{HAPriority: 9, DependsOn: []string{"db"}}},
+		"db":  {ID: "db", NodeID: "n1"},
+		"web": {ID: "web", NodeID: "n1", Policy: api.VMSchedulingPolicy{Priority: 5}},
+	}}
+	var ids []string
+	for _, vm := range VMsOn(state, "n1") {
+		ids = append(ids, vm.ID)
+	}
+	if strings.Join(ids, ",") != "db,app,web" {
+		t.Fatalf("unexpected order: %v", ids)
+	}
+}
+
+func TestRestartPolicyOnNodeFailure(t *testing.T) {
+	for _, tc := range []struct {
+		vm   api.VM
+		want string
+	}{
+		{api.VM{ID: "a"}, ""},
+		{api.VM{ID: "b", Restart: api.RestartPolicy{Policy: api.RestartOnFailure}}, ""},
+		{api.VM{ID: "c", Restart: api.RestartPolicy{Policy: api.RestartNever}}, api.VMReasonRestartPolicy},
+		{api.VM{ID: "d", Restart: api.RestartPolicy{MaxRestarts: 2}, Status: &api.VMStatus{Restarts: 2}}, api.VMReasonRestartLimit},
+		{api.VM{ID: "e", DesiredState: "Stopped"}, "Stopped"},
+	} {
+		if got := restartBlocked(tc.vm); got != tc.want {
+			t.Errorf("%s: want %q got %q", tc.vm.ID, tc.want, got)
+		}
+	}
+}
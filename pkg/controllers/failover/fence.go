@@ -0,0 +1,74 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+// Fencer makes sure a failed node no longer runs any VM, e.g. by powering it off, so its
+// VMs can be restarted elsewhere without running twice.
+type Fencer interface {
+	// Name identifies the fencing method in node status and events.
+	Name() string
+	// Fence returns nil once the node is fenced and ErrFencePending while fencing is
+	// expected to complete later without further action.
+	Fence(ctx context.Context, n api.Node) error
+}
+
+// ErrFencePending reports that the node is not fenced yet; Fence is called again later.
+var ErrFencePending = errors.New("fencing pending")
+
+// SelfFence relies on node agents fencing themselves: an agent that loses contact with
+// the leader for its self-fence timeout stops all its VMs. The node counts as fenced once
+// its last heartbeat (or, without one, its NotReady transition) is older than Wait, which
+// must exceed the agents' timeout.
+type SelfFence struct {
+	Wait time.Duration
+	now  func() time.Time
+}
+
+func NewSelfFence(wait time.Duration) *SelfFence { return &SelfFence{Wait: wait, now: time.Now} }
+
+func (f *SelfFence) Name() string { return "self-fence" }
+
+func (f *SelfFence) Fence(_ context.Context, n api.Node) error {
+	last := n.LastHeartbeat
+	if c, ok := n.Condition(api.NodeReady); ok && last.IsZero() {
+		last = c.LastTransitionTime
+	}
+	if at := last.Add(f.Wait); f.now().Before(at) {
+		return fmt.Errorf("%w: agent has self-fenced by %s", ErrFencePending, at.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ExecFence runs an operator command (IPMI, PDU, cloud API, ...) that powers the node off,
+// with NODE_ID and NODE_ADDRESS in its environment; exit status 0 means fenced.
+type ExecFence struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (f *ExecFence) Name() string { return "exec" }
+
+func (f *ExecFence) Fence(ctx context.Context, n api.Node) error {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", f.Command)
+	cmd.Env = append(os.Environ(), "NODE_ID="+n.ID, "NODE_ADDRESS="+n.Address)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
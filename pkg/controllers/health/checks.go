@@ -0,0 +1,212 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"clustering/pkg/api"
+)
+
+// Check types.
+const (
+	TypeHTTP  = "http"
+	TypeTCP   = "tcp"
+	TypeGRPC  = "grpc"
+	TypeAgent = "agent"
+)
+
+// Check probes one aspect of an agent node.
+type Check interface {
+	Name() string
+	Type() string
+	Probe(ctx context.Context, n api.Node) Result
+}
+
+// Result is the outcome of one probe. Pressure holds the node conditions an agent
+// reported, true meaning the node is under that pressure.
+type Result struct {
+	OK       bool
+	Message  string
+	Pressure map[string]bool
+}
+
+func fail(format string, args ...any) Result {
+	return Result{Message: fmt.Sprintf(format, args...)}
+}
+
+// DefaultChecks is used when the cluster config has none: the agent's /healthz.
+var DefaultChecks = []api.HealthCheck{{Name: "healthz", Type: TypeAgent}}
+
+// NewCheck builds a check from its configuration.
+func NewCheck(hc api.HealthCheck) (Check, error) {
+	if hc.Name == "" {
+		return nil, fmt.Errorf("health check without name")
+	}
+	if hc.Port < 0 || hc.Port > 65535 {
+		return nil, fmt.Errorf("health check %s: invalid port %d", hc.Name, hc.Port)
+	}
+	switch hc.Type {
+	case TypeHTTP, TypeAgent:
+		if hc.Path == "" {
+			hc.Path = "/healthz"
+		}
+		return httpCheck{hc}, nil
+	case TypeTCP:
+		return tcpCheck{hc}, nil
+	case TypeGRPC:
+		return grpcCheck{hc}, nil
+	}
+	return nil, fmt.Errorf("health check %s: unknown type %q", hc.Name, hc.Type)
+}
+
+// NewChecks builds every configured check, or the defaults when none are configured.
+func NewChecks(cfg []api.HealthCheck) ([]Check, error) {
+	if len(cfg) == 0 {
+		cfg = DefaultChecks
+	}
+	seen := map[string]bool{}
+	out := make([]Check, 0, len(cfg))
+	for _, hc := range cfg {
+		if seen[hc.Name] {
+			return nil, fmt.Errorf("duplicate health check %q", hc.Name)
+		}
+		seen[hc.Name] = true
+		c, err := NewCheck(hc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// target returns host:port for the check: the node's address with Port substituted.
+func target(hc api.HealthCheck, n api.Node) (string, error) {
+	host, port, err := net.SplitHostPort(n.Address)
+	if err != nil {
+		return "", fmt.Errorf("node address %q: %v", n.Address, err)
+	}
+	if hc.Port != 0 {
+		port = strconv.Itoa(hc.Port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+type httpCheck struct{ hc api.HealthCheck }
+
+func (c httpCheck) Name() string { return c.hc.Name }
+func (c httpCheck) Type() string { return c.hc.Type }
+
+func (c httpCheck) Probe(ctx context.Context, n api.Node) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout(c.hc))
+	defer cancel()
+	addr, err := target(c.hc, n)
+	if err != nil {
+		return fail("%v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+c.hc.Path, nil)
+	if err != nil {
+		return fail("%v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fail("GET %s: %s", c.hc.Path, resp.Status)
+	}
+	if c.hc.Type != TypeAgent {
+		return Result{OK: true}
+	}
+	var h api.AgentHealth
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		return fail("decode agent checks: %v", err)
+	}
+	return agentResult(h)
+}
+
+// agentResult folds node-local checks into one result: checks that feed a condition only
+// set pressure, any other failing check fails the probe.
+func agentResult(h api.AgentHealth) Result {
+	r := Result{OK: true, Pressure: map[string]bool{}}
+	var failing []string
+	for _, ac := range h.Checks {
+		if ac.Condition != "" {
+			r.Pressure[ac.Condition] = r.Pressure[ac.Condition] || !ac.OK
+			continue
+		}
+		if !ac.OK {
+			r.OK = false
+			failing = append(failing, ac.Name+": "+ac.Message)
+		}
+	}
+	r.Message = strings.Join(failing, "; ")
+	return r
+}
+
+type tcpCheck struct{ hc api.HealthCheck }
+
+func (c tcpCheck) Name() string { return c.hc.Name }
+func (c tcpCheck) Type() string { return c.hc.Type }
+
+func (c tcpCheck) Probe(ctx context.Context, n api.Node) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout(c.hc))
+	defer cancel()
+	addr, err := target(c.hc, n)
+	if err != nil {
+		return fail("%v", err)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fail("%v", err)
+	}
+	conn.Close()
+	return Result{OK: true}
+}
+
+type grpcCheck struct{ hc api.HealthCheck }
+
+func (c grpcCheck) Name() string { return c.hc.Name }
+func (c grpcCheck) Type() string { return c.hc.Type }
+
+func (c grpcCheck) Probe(ctx context.Context, n api.Node) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout(c.hc))
+	defer cancel()
+	addr, err := target(c.hc, n)
+	if err != nil {
+		return fail("%v", err)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: c.hc.Service})
+	if err != nil {
+		return fail("%v", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fail("service %q is %s", c.hc.Service, resp.GetStatus())
+	}
+	return Result{OK: true}
+}
+
+// timeout is the per-probe deadline of a check.
+func timeout(hc api.HealthCheck) time.Duration {
+	if hc.TimeoutSeconds > 0 {
+		return time.Duration(hc.TimeoutSeconds) * time.Second
+	}
+	return 2 * time.Second
+}
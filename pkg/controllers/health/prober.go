@@ -0,0 +1,162 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/metrics"
+	"clustering/pkg/store"
+)
+
+// DefaultFailureThreshold is used when the cluster config does not set one.
+const DefaultFailureThreshold = 3
+
+// Condition reasons recorded by the prober.
+const (
+	ReasonChecksPassing = "ChecksPassing"
+	ReasonCheckFailed   = "CheckFailed"
+	ReasonAgentReported = "AgentReported"
+)
+
+// Prober runs the configured health checks against every alive agent node and records
+// per-check results and the Healthy and pressure conditions. Its Probe method is the
+// PingFunc of the health Controller.
+type Prober struct {
+	st       *store.Manager
+	isLeader func() bool
+	now      func() time.Time
+}
+
+func NewProber(st *store.Manager, isLeader func() bool) *Prober {
+	return &Prober{st: st, isLeader: isLeader, now: time.Now}
+}
+
+// Probe checks all agent nodes once. It returns an error listing the unhealthy nodes.
+func (p *Prober) Probe() error {
+	if p.isLeader != nil && !p.isLeader() {
+		return nil
+	}
+	state := p.st.GetStateCopy()
+	checks, err := NewChecks(state.Config.HealthChecks)
+	if err != nil {
+		return err
+	}
+	threshold := state.Config.HealthFailureThreshold
+	if threshold <= 0 {
+		threshold = DefaultFailureThreshold
+	}
+
+	var ids []string
+	for id, n := range state.Nodes {
+		if n.Role == "node" && n.Status == "Alive" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	updates := make([]*api.NodeHealth, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, n api.Node) {
+			defer wg.Done()
+			updates[i] = p.probeNode(n, checks, threshold)
+		}(i, state.Nodes[id])
+	}
+	wg.Wait()
+
+	var unhealthy []string
+	for i, h := range updates {
+		n := state.Nodes[ids[i]]
+		if h != nil {
+			if err := p.st.Apply(context.Background(), store.NewCommand("SetNodeHealth", *h)); err != nil {
+				log.Printf("health: record %s: %v", ids[i], err)
+			}
+			n.Conditions = h.Conditions
+		}
+		if c, ok := n.Condition(api.NodeHealthy); ok && c.Status == api.ConditionFalse {
+			unhealthy = append(unhealthy, ids[i])
+		}
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("unhealthy nodes: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// probeNode runs every check against n and returns the health update, or nil when
+// nothing but the check times changed.
+func (p *Prober) probeNode(n api.Node, checks []Check, threshold int) *api.NodeHealth {
+	prev := map[string]api.CheckResult{}
+	for _, r := range n.Checks {
+		prev[r.Name] = r
+	}
+	now := p.now().UTC()
+	h := api.NodeHealth{NodeID: n.ID}
+	pressure := map[string]bool{}
+	var failing []string
+	for _, c := range checks {
+		res := c.Probe(context.Background(), n)
+		cr := api.CheckResult{Name: c.Name(), Type: c.Type(), OK: res.OK, Message: res.Message, LastChecked: now}
+		if !res.OK {
+			metrics.IncCounter("health_check_failures_total")
+			// saturate at the threshold so a persistently failing check is not rewritten every probe
+			cr.ConsecutiveFailures = min(prev[cr.Name].ConsecutiveFailures+1, threshold)
+			if cr.ConsecutiveFailures >= threshold {
+				failing = append(failing, cr.Name+": "+cr.Message)
+			}
+		}
+		for cond, on := range res.Pressure {
+			pressure[cond] = pressure[cond] || on
+		}
+		h.Checks = append(h.Checks, cr)
+	}
+
+	healthy := api.NodeCondition{Type: api.NodeHealthy, Status: api.ConditionTrue, Reason: ReasonChecksPassing, LastTransitionTime: now}
+	if len(failing) > 0 {
+		healthy.Status, healthy.Reason, healthy.Message = api.ConditionFalse, ReasonCheckFailed, strings.Join(failing, "; ")
+	}
+	h.Conditions = append(h.Conditions, healthy)
+	conds := make([]string, 0, len(pressure))
+	for cond := range pressure {
+		conds = append(conds, cond)
+	}
+	sort.Strings(conds)
+	for _, cond := range conds {
+		status := api.ConditionFalse
+		if pressure[cond] {
+			status = api.ConditionTrue
+		}
+		h.Conditions = append(h.Conditions, api.NodeCondition{Type: cond, Status: status, Reason: ReasonAgentReported, LastTransitionTime: now})
+	}
+
+	if !changed(n, h) {
+		return nil
+	}
+	return &h
+}
+
+// changed reports whether h differs from what is recorded on n, ignoring timestamps.
+func changed(n api.Node, h api.NodeHealth) bool {
+	if len(n.Checks) != len(h.Checks) {
+		return true
+	}
+	for i, r := range h.Checks {
+		o := n.Checks[i]
+		if o.Name != r.Name || o.Type != r.Type || o.OK != r.OK || o.Message != r.Message || o.ConsecutiveFailures != r.ConsecutiveFailures {
+			return true
+		}
+	}
+	for _, c := range h.Conditions {
+		o, ok := n.Condition(c.Type)
+		if !ok || o.Status != c.Status || o.Reason != c.Reason || o.Message != c.Message {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func agentServer(t *testing.T, h *api.AgentHealth, status *int) api.Node {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		if *status != http.StatusOK {
+			w.WriteHeader(*status)
+			return
+		}
+		json.NewEncoder(w).Encode(h)
+	}))
+	t.Cleanup(srv.Close)
+	return api.Node{ID: "n1", Role: "node", Status: "Alive", Address: strings.TrimPrefix(srv.URL, "http://")}
+}
+
+// record applies an update the way the SetNodeHealth command does.
+func record(n api.Node, h *api.NodeHealth) api.Node {
+	if h == nil {
+		return n
+	}
+	n.Checks = h.Checks
+	for _, c := range h.Conditions {
+		n.Conditions = api.SetCondition(n.Conditions, c)
+	}
+	return n
+}
+
+func TestProberNeedsConsecutiveFailures(t *testing.T) {
+	status := http.StatusOK
+	n := agentServer(t, &api.AgentHealth{}, &status)
+	checks, err := NewChecks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProber(nil, nil)
+
+	n = record(n, p.probeNode(n, checks, 3))
+	if c, _ := n.Condition(api.NodeHealthy); c.Status != api.ConditionTrue {
+		t.Fatalf("want healthy got %+v", n.Conditions)
+	}
+	if h := p.probeNode(n, checks, 3); h != nil {
+		t.Fatalf("unchanged probe must not write: %+v", h)
+	}
+
+	status = http.StatusServiceUnavailable
+	for i := 1; i <= 2; i++ {
+		n = record(n, p.probeNode(n, checks, 3))
+		if c, _ := n.Condition(api.NodeHealthy); c.Status != api.ConditionTrue || n.Checks[0].ConsecutiveFailures != i {
+			t.Fatalf("failure %d: %+v %+v", i, n.Checks, n.Conditions)
+		}
+	}
+	n = record(n, p.probeNode(n, checks, 3))
+	if c, _ := n.Condition(api.NodeHealthy); c.Status != api.ConditionFalse || c.Reason != ReasonCheckFailed {
+		t.Fatalf("want unhealthy after 3 failures got %+v", n.Conditions)
+	}
+	if h := p.probeNode(n, checks, 3); h != nil {
+		t.Fatalf("saturated failure must not write: %+v", h)
+	}
+
+	status = http.StatusOK
+	n = record(n, p.probeNode(n, checks, 3))
+	if c, _ := n.Condition(api.NodeHealthy); c.Status != api.ConditionTrue || n.Checks[0].ConsecutiveFailures != 0 {
+		t.Fatalf("want recovery got %+v %+v", n.Checks, n.Conditions)
+	}
+}
+
+func TestProberRecordsAgentPressure(t *testing.T) {
+	status := http.StatusOK
+	h := &api.AgentHealth{Checks: []api.AgentCheck{
+		{Name: "disk", OK: false, Message: "95% used", Condition: api.NodeDiskPressure},
+		{Name: "memory", OK: true, Condition: api.NodeMemoryPressure},
+	}}
+	n := agentServer(t, h, &status)
+	checks, _ := NewChecks(nil)
+	n = record(n, NewProber(nil, nil).probeNode(n, checks, 3))
+
+	if c, _ := n.Condition(api.NodeDiskPressure); c.Status != api.ConditionTrue {
+		t.Fatalf("want disk pressure got %+v", n.Conditions)
+	}
+	if c, _ := n.Condition(api.NodeMemoryPressure); c.Status != api.ConditionFalse {
+		t.Fatalf("want no memory pressure got %+v", n.Conditions)
+	}
+	if !n.Checks[0].OK {
+		t.Fatalf("pressure alone must not fail the check: %+v", n.Checks)
+	}
+}
+
+func TestTCPAndHTTPChecks(t *testing.T) {
+	status := http.StatusOK
+	n := agentServer(t, &api.AgentHealth{}, &status)
+	checks, err := NewChecks([]api.HealthCheck{
+		{Name: "web", Type: TypeHTTP},
+		{Name: "port", Type: TypeTCP},
+		{Name: "closed", Type: TypeTCP, Port: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = record(n, NewProber(nil, nil).probeNode(n, checks, 1))
+	if !n.Checks[0].OK || !n.Checks[1].OK || n.Checks[2].OK {
+		t.Fatalf("unexpected results: %+v", n.Checks)
+	}
+	if c, _ := n.Condition(api.NodeHealthy); c.Status != api.ConditionFalse || !strings.HasPrefix(c.Message, "closed:") {
+		t.Fatalf("want unhealthy by closed port got %+v", n.Conditions)
+	}
+}
+
+func TestNewChecksValidates(t *testing.T) {
+	for _, cfg := range [][]api.HealthCheck{
+		{{Name: "x", Type: "icmp"}},
+		{{Type: TypeTCP}},
+		{{Name: "a", Type: TypeTCP}, {Name: "a", Type: TypeHTTP}},
+	} {
+		if _, err := NewChecks(cfg); err == nil {
+			t.Errorf("%+v: expected error", cfg)
+		}
+	}
+}
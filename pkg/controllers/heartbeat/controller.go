@@ -0,0 +1,114 @@
+package heartbeat
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/store"
+)
+
+// Readiness reasons.
+const (
+	ReasonNotAlive       = "SerfNotAlive"
+	ReasonNoHeartbeat    = "NoHeartbeat"
+	ReasonHeartbeatStale = "HeartbeatStale"
+	ReasonUnhealthy      = "HealthChecksFailing"
+
+	ReasonSerfUnreachable = "SerfUnreachable"
+	ReasonSerfReachable   = "SerfReachable"
+)
+
+// Controller derives node readiness from serf status, heartbeat freshness and the
+// Healthy condition recorded by the health prober, and the NetworkUnavailable condition
+// from serf status. The FSM turns both into taints.
+type Controller struct {
+	st       *store.Manager
+	interval time.Duration
+	grace    time.Duration
+	isLeader func() bool
+	now      func() time.Time
+}
+
+// NewController marks agent nodes not ready when no heartbeat arrived within grace.
+func NewController(st *store.Manager, grace time.Duration, isLeader func() bool) *Controller {
+	return &Controller{st: st, interval: 5 * time.Second, grace: grace, isLeader: isLeader, now: time.Now}
+}
+
+func (c *Controller) Run(stop <-chan struct{}) {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			c.tick()
+		}
+	}
+}
+
+func (c *Controller) tick() {
+	if c.isLeader != nil && !c.isLeader() {
+		return
+	}
+	nodes := c.st.GetStateCopy().Nodes
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	now := c.now()
+	for _, id := range ids {
+		n := nodes[id]
+		if net := NetworkCondition(n, now); conditionChanged(n, net) {
+			if err := c.st.Apply(context.Background(), store.NewCommand("SetNodeConditions", api.NodeConditions{NodeID: id, Conditions: []api.NodeCondition{net}})); err != nil {
+				log.Printf("heartbeat: set conditions %s: %v", id, err)
+			}
+		}
+		ready, reason := Readiness(n, now, c.grace)
+		if _, ok := n.Condition(api.NodeReady); ok && ready == n.Ready && reason == n.ReadyReason {
+			continue
+		}
+		if err := c.st.Apply(context.Background(), store.NewCommand("SetNodeReadiness", api.NodeReadiness{NodeID: id, Ready: ready, Reason: reason, Time: now})); err != nil {
+			log.Printf("heartbeat: set readiness %s: %v", id, err)
+		}
+	}
+}
+
+// NetworkCondition is True while serf reports the node failed, i.e. unreachable by gossip.
+func NetworkCondition(n api.Node, now time.Time) api.NodeCondition {
+	if n.Status == "Failed" {
+		return api.NodeCondition{Type: api.NodeNetworkUnavailable, Status: api.ConditionTrue, Reason: ReasonSerfUnreachable, LastTransitionTime: now}
+	}
+	return api.NodeCondition{Type: api.NodeNetworkUnavailable, Status: api.ConditionFalse, Reason: ReasonSerfReachable, LastTransitionTime: now}
+}
+
+func conditionChanged(n api.Node, c api.NodeCondition) bool {
+	old, ok := n.Condition(c.Type)
+	return !ok || old.Status != c.Status || old.Reason != c.Reason
+}
+
+// Readiness reports whether a node is ready: its serf status must be Alive and, for nodes
+// running an agent, its last heartbeat must be younger than grace and its health checks
+// must not have marked it unhealthy.
+func Readiness(n api.Node, now time.Time, grace time.Duration) (bool, string) {
+	if n.Status != "Alive" {
+		return false, ReasonNotAlive
+	}
+	if n.Role != "node" {
+		return true, ""
+	}
+	if n.LastHeartbeat.IsZero() {
+		return false, ReasonNoHeartbeat
+	}
+	if now.Sub(n.LastHeartbeat) > grace {
+		return false, ReasonHeartbeatStale
+	}
+	if c, ok := n.Condition(api.NodeHealthy); ok && c.Status == api.ConditionFalse {
+		return false, ReasonUnhealthy
+	}
+	return true, ""
+}
@@ -0,0 +1,32 @@
+package heartbeat
+
+import (
+	"testing"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+func TestReadiness(t *testing.T) {
+	now := time.Now()
+	grace := 30 * time.Second
+	cases := []struct {
+		name   string
+		node   api.Node
+		ready  bool
+		reason string
+	}{
+		{"fresh", api.Node{Role: "node", Status: "Alive", LastHeartbeat: now.Add(-5 * time.Second)}, true, ""},
+		{"stale", api.Node{Role: "node", Status: "Alive", LastHeartbeat: now.Add(-time.Minute)}, false, ReasonHeartbeatStale},
+		{"never", api.Node{Role: "node", Status: "Alive"}, false, ReasonNoHeartbeat},
+		{"failed", api.Node{Role: "node", Status: "Failed", LastHeartbeat: now}, false, ReasonNotAlive},
+		{"unhealthy", api.Node{Role: "node", Status: "Alive", LastHeartbeat: now, Conditions: []api.NodeCondition{{Type: api.NodeHealthy, Status: api.ConditionFalse}}}, false, ReasonUnhealthy},
+		{"control plane", api.Node{Role: "control-plane", Status: "Alive"}, true, ""},
+	}
+	for _, tc := range cases {
+		ready, reason := Readiness(tc.node, now, grace)
+		if ready != tc.ready || reason != tc.reason {
+			t.Errorf("%s: got %v/%q want %v/%q", tc.name, ready, reason, tc.ready, tc.reason)
+		}
+	}
+}
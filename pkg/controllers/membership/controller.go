@@ -20,6 +20,7 @@ type Controller struct {
 	interval      time.Duration
 	desiredVoters int
 	desiredFunc   func() int
+	rttFunc       func() map[string]time.Duration
 }
 
 func NewController(r *raft.Raft, listAlive ListAliveMembersFunc) *Controller {
@@ -32,6 +33,13 @@ func (c *Controller) WithDesiredVotersFunc(fn func() int) *Controller {
 	return c
 }
 
+// WithRTTFunc supplies estimated RTTs from this server to others so the planner
+// prefers low-latency voters.
+func (c *Controller) WithRTTFunc(fn func() map[string]time.Duration) *Controller {
+	c.rttFunc = fn
+	return c
+}
+
 func (c *Controller) Run(stop <-chan struct{}) {
 	ticker := time.NewTicker(c.interval)
 	defer ticker.Stop()
@@ -76,7 +84,11 @@ func (c *Controller) reconcileOnce() {
 		existing = append(existing, es)
 	}
 
-	addNonvoters, promote, demote := Plan(existing, alive, c.desiredVoters)
+	var rtt map[string]time.Duration
+	if c.rttFunc != nil {
+		rtt = c.rttFunc()
+	}
+	addNonvoters, promote, demote := PlanWithLatency(existing, alive, c.desiredVoters, rtt)
 
 	for _, id := range addNonvoters {
 		addr := alive[id]
@@ -1,6 +1,9 @@
 package membership
 
-import "sort"
+import (
+	"sort"
+	"time"
+)
 
 // ExistingServer models a current raft server entry for planning.
 type ExistingServer struct {
@@ -12,6 +15,13 @@ type ExistingServer struct {
 // Plan computes membership actions given existing servers, alive members, and desired voter count.
 // alive maps node ID -> raft address.
 func Plan(existing []ExistingServer, alive map[string]string, desiredVoters int) (addNonvoters []string, promote []string, demote []string) {
+	return PlanWithLatency(existing, alive, desiredVoters, nil)
+}
+
+// PlanWithLatency is Plan preferring low-latency voters: nonvoters with the lowest estimated
+// RTT (to the leader) are promoted first and the highest-RTT voters are demoted first.
+// Servers without an RTT estimate rank after those with one; ties fall back to ID order.
+func PlanWithLatency(existing []ExistingServer, alive map[string]string, desiredVoters int, rtt map[string]time.Duration) (addNonvoters []string, promote []string, demote []string) {
 	if desiredVoters < 1 {
 		desiredVoters = 1
 	}
@@ -33,8 +43,21 @@ func Plan(existing []ExistingServer, alive map[string]string, desiredVoters int)
 			addNonvoters = append(addNonvoters, id)
 		}
 	}
-	sort.Strings(voters)
-	sort.Strings(nonvoters)
+	byLatency := func(ids []string) {
+		sort.Slice(ids, func(i, j int) bool {
+			ri, oki := rtt[ids[i]]
+			rj, okj := rtt[ids[j]]
+			if oki != okj {
+				return oki
+			}
+			if oki && ri != rj {
+				return ri < rj
+			}
+			return ids[i] < ids[j]
+		})
+	}
+	byLatency(voters)
+	byLatency(nonvoters)
 	// Promotions or demotions to reach desired voters
 	if len(voters) < desiredVoters {
 		need := desiredVoters - len(voters)
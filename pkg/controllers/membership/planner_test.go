@@ -1,6 +1,9 @@
 package membership
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestPlanAddAndPromote(t *testing.T) {
 	existing := []ExistingServer{{ID: "n1", Suffrage: "voter"}, {ID: "n2", Suffrage: "nonvoter"}}
@@ -43,3 +46,19 @@ func TestPlanAddsNonvoterForNewAlive(t *testing.T) {
 		t.Fatalf("unexpected plan: add=%v promote=%v demote=%v", add, promote, demote)
 	}
 }
+
+func TestPlanWithLatencyPrefersCloseVoters(t *testing.T) {
+	existing := []ExistingServer{{ID: "n1", Suffrage: "voter"}, {ID: "n2", Suffrage: "nonvoter"}, {ID: "n3", Suffrage: "nonvoter"}, {ID: "n4", Suffrage: "nonvoter"}}
+	alive := map[string]string{"n1": "a1", "n2": "a2", "n3": "a3", "n4": "a4"}
+	rtt := map[string]time.Duration{"n1": 0, "n2": 80 * time.Millisecond, "n3": 2 * time.Millisecond}
+	_, promote, _ := PlanWithLatency(existing, alive, 3, rtt)
+	if len(promote) != 2 || promote[0] != "n3" || promote[1] != "n2" {
+		t.Fatalf("want promote [n3 n2] got %v", promote)
+	}
+
+	voters := []ExistingServer{{ID: "n1", Suffrage: "voter"}, {ID: "n2", Suffrage: "voter"}, {ID: "n3", Suffrage: "voter"}}
+	_, _, demote := PlanWithLatency(voters, alive, 2, rtt)
+	if len(demote) != 1 || demote[0] != "n2" {
+		t.Fatalf("want demote [n2] got %v", demote)
+	}
+}
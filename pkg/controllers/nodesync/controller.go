@@ -4,8 +4,10 @@ import (
 	"context"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
+	"clustering/pkg/agent/runtime"
 	"clustering/pkg/api"
 	"clustering/pkg/store"
 )
@@ -14,8 +16,10 @@ type MemberInfo struct {
 	ID     string
 	Addr   string
 	Role   string
-	Status string // Alive/Failed/Left
+	Status string // serf member status: alive/leaving/left/failed
 	Tags   map[string]string
+	// Coordinate is the member's serf network coordinate, if known.
+	Coordinate *api.Coordinate
 }
 
 type ListMembersFunc func() []MemberInfo
@@ -57,9 +61,10 @@ func (c *Controller) syncOnce() {
 	}
 }
 
-// MemberToNode converts MemberInfo into api.Node, reading capacity tags when present.
+// MemberToNode converts MemberInfo into api.Node, reading capacity, reservation and
+// overcommit tags when present.
 func MemberToNode(m MemberInfo) api.Node {
-	n := api.Node{ID: m.ID, Address: m.Addr, Role: m.Role, Voter: false, Capacity: api.Resources{CPU: 8000, Memory: 32768, Disk: 512}, Status: m.Status}
+	n := api.Node{ID: m.ID, Address: m.Addr, Role: m.Role, Voter: false, Capacity: api.Resources{CPU: 8000, Memory: 32768, Disk: 512}, Status: NodeStatus(m.Status), Coordinate: m.Coordinate}
 	if m.Tags != nil {
 		if v, ok := m.Tags["cpu"]; ok {
 			if iv, err := strconv.Atoi(v); err == nil {
@@ -76,6 +81,32 @@ func MemberToNode(m MemberInfo) api.Node {
 				n.Capacity.Disk = iv
 			}
 		}
+		if v, ok := m.Tags["caps"]; ok {
+			n.Capabilities = runtime.ParseCapabilities(v)
+		}
+		if v, ok := m.Tags["reserved"]; ok {
+			if r, err := api.ParseResources(v); err == nil {
+				n.Reserved = r
+			}
+		}
+		if v, ok := m.Tags["overcommit"]; ok {
+			if oc, err := api.ParseOvercommit(v); err == nil {
+				n.Overcommit = &oc
+			}
+		}
 	}
 	return n
 }
+
+// NodeStatus maps a serf member status onto the node statuses stored in the FSM.
+// A member that left gracefully (or is leaving) becomes Left rather than Failed.
+func NodeStatus(serfStatus string) string {
+	switch strings.ToLower(serfStatus) {
+	case "alive":
+		return "Alive"
+	case "leaving", "left":
+		return "Left"
+	default:
+		return "Failed"
+	}
+}
@@ -12,6 +12,24 @@ func TestMemberToNodeReadsCapacityTags(t *testing.T) {
 	}
 }
 
+func TestMemberToNodeReadsCapabilities(t *testing.T) {
+	n := MemberToNode(MemberInfo{ID: "n1", Status: "Alive", Tags: map[string]string{"caps": "pause,resize"}})
+	if len(n.Capabilities) != 2 || n.Capabilities[0] != "pause" || n.Capabilities[1] != "resize" {
+		t.Fatalf("unexpected capabilities: %v", n.Capabilities)
+	}
+}
+
+func TestMemberToNodeReadsReservationAndOvercommit(t *testing.T) {
+	m := MemberInfo{ID: "n1", Status: "Alive", Tags: map[string]string{"cpu": "16000", "reserved": "cpu=1000,memory=2048", "overcommit": "cpu=4"}}
+	n := MemberToNode(m)
+	if n.Reserved.CPU != 1000 || n.Reserved.Memory != 2048 || n.Overcommit == nil || n.Overcommit.CPU != 4 {
+		t.Fatalf("unexpected reservation/overcommit: %+v %+v", n.Reserved, n.Overcommit)
+	}
+	if n.Capacity.CPU != 16000 {
+		t.Fatalf("capacity must stay raw: %+v", n.Capacity)
+	}
+}
+
 func TestMemberToNodeDefaultsWhenNoTags(t *testing.T) {
 	m := MemberInfo{ID: "n1", Addr: "127.0.0.1:9090", Role: "node", Status: "Alive"}
 	n := MemberToNode(m)
@@ -19,3 +37,13 @@ func TestMemberToNodeDefaultsWhenNoTags(t *testing.T) {
 		t.Fatalf("unexpected defaults: %+v", n.Capacity)
 	}
 }
+
+func TestMemberToNodeMapsSerfStatus(t *testing.T) {
+	cases := map[string]string{"alive": "Alive", "Alive": "Alive", "leaving": "Left", "left": "Left", "failed": "Failed"}
+	for in, want := range cases {
+		n := MemberToNode(MemberInfo{ID: "n1", Status: in})
+		if n.Status != want {
+			t.Fatalf("status %q: want %s got %s", in, want, n.Status)
+		}
+	}
+}
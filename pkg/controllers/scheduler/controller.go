@@ -2,50 +2,292 @@ package schedulerctl
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"clustering/pkg/api"
+	"clustering/pkg/metrics"
 	"clustering/pkg/scheduler"
 	"clustering/pkg/store"
 )
 
 type StateReader interface{ GetStateCopy() api.ClusterState }
 
+// PreemptionGrace is how long a VM that preempted others waits before it is placed, so
+// the node agent can stop the victims first.
+const PreemptionGrace = 5 * time.Second
+
+// Controller places VMs that have no node, highest priority first. It works off a queue
+// fed by applied commands: new unplaced VMs join it, and changes that may free room (a VM
+// deleted, a node added, uncordoned or reconfigured, ...) end the backoff of VMs that did
+// not fit. The placements of a pass are committed in one BindVMs apply, which the FSM
+// re-checks against the authoritative state.
+//
+// A VM no node fits preempts lower-priority VMs when its priority class allows it: they
+// are evicted (and stopped by their node agent) and the VM is placed after
+// PreemptionGrace. Otherwise it keeps a FailedScheduling status explaining why, a
+// FailedScheduling event is recorded when the reason changes, and it is retried with
+// exponential backoff.
 type Controller struct {
 	state    StateReader
 	st       *store.Manager
 	interval time.Duration
+	// resync re-queues every unplaced VM, in case a change was missed.
+	resync   time.Duration
+	isLeader func() bool
+	now      func() time.Time
+
+	queue *queue
+	// wasLeader is only used by the Run goroutine.
+	wasLeader bool
+	// nodes fingerprints the scheduling-relevant fields of upserted nodes; only the
+	// apply path touches it.
+	nodes map[string]string
 }
 
-func NewController(sr StateReader, st *store.Manager) *Controller {
-	return &Controller{state: sr, st: st, interval: 5 * time.Second}
+func NewController(sr StateReader, st *store.Manager, isLeader func() bool) *Controller {
+	c := &Controller{state: sr, st: st, interval: time.Second, resync: time.Minute, isLeader: isLeader, now: time.Now,
+		queue: newQueue(), nodes: map[string]string{}}
+	st.Watch(c.observe)
+	return c
 }
 
 func (c *Controller) Run(stop <-chan struct{}) {
 	t := time.NewTicker(c.interval)
 	defer t.Stop()
+	resync := time.NewTicker(c.resync)
+	defer resync.Stop()
 	for {
 		select {
 		case <-stop:
 			return
+		case <-c.queue.wake:
 		case <-t.C:
-			c.tick()
+		case <-resync.C:
+			c.queue.requestResync()
+		}
+		c.tick()
+	}
+}
+
+// observe feeds the queue from an applied command.
+func (c *Controller) observe(cmd store.Command) {
+	now := c.now()
+	switch cmd.Type {
+	case "UpsertVM":
+		// a VM backing off keeps waiting when it is updated
+		var vm api.VM
+		if json.Unmarshal(cmd.Payload, &vm) == nil && vm.NodeID == "" {
+			c.queue.addIfAbsent(vm.ID, now)
+		}
+	case "PreemptVM":
+		var p api.Preemption
+		if json.Unmarshal(cmd.Payload, &p) == nil {
+			for _, id := range p.Victims {
+				c.queue.add(id, now)
+			}
+		}
+	case "UpsertNode":
+		var n api.Node
+		if json.Unmarshal(cmd.Payload, &n) != nil {
+			return
+		}
+		fp, _ := json.Marshal(struct {
+			Status     string
+			Capacity   api.Resources
+			Reserved   api.Resources
+			Overcommit *api.Overcommit
+			Labels     map[string]string
+		}{n.Status, n.Capacity, n.Reserved, n.Overcommit, n.Labels})
+		if c.nodes[n.ID] != string(fp) {
+			c.nodes[n.ID] = string(fp)
+			c.queue.activateAll()
+		}
+	case "DeleteNode":
+		var id string
+		if json.Unmarshal(cmd.Payload, &id) == nil {
+			delete(c.nodes, id)
+		}
+	case "DeleteVM", "BindVM", "SetNodeSchedulable", "SetNodeTaints", "SetNodeConditions", "SetNodeReadiness",
+		"SetNodeStandby", "SetNodeFence", "SetNodeDrain",
+		"SetConfig", "RollbackConfig", "UpsertPriorityClass", "UpsertNetwork", "UpsertVolume":
+		c.queue.activateAll()
+	case store.RestoreCommand:
+		c.nodes = map[string]string{}
+		c.queue.requestResync()
+	}
+}
+
+// enqueueUnplaced queues every VM without a node that is not queued yet.
+func (c *Controller) enqueueUnplaced(st api.ClusterState) {
+	for id, vm := range st.VMs {
+		if vm.NodeID == "" {
+			c.queue.addIfAbsent(id, c.now())
 		}
 	}
 }
 
 func (c *Controller) tick() {
-	st := c.state.GetStateCopy()
-	for _, vm := range st.VMs {
-		if vm.NodeID == "" || vm.Phase == "Pending" {
-			if nid, ok := scheduler.ChooseNode(st, vm); ok {
-				vm.NodeID = nid
-				vm.Phase = "Running"
-				if err := c.st.Apply(context.Background(), store.NewCommand("UpsertVM", vm)); err != nil {
-					log.Printf("schedule vm %s: %v", vm.ID, err)
-				}
+	if c.isLeader != nil && !c.isLeader() {
+		c.wasLeader = false
+		return
+	}
+	now := c.now()
+	defer func() {
+		total, backingOff := c.queue.len(now)
+		metrics.SetGauge("scheduler_queue_depth", float64(total))
+		metrics.SetGauge("scheduler_queue_backoff", float64(backingOff))
+	}()
+	var st api.ClusterState
+	if resync := c.queue.takeResync(); !c.wasLeader || resync {
+		// a new leader (or a resync) starts from the full state
+		st = c.state.GetStateCopy()
+		c.enqueueUnplaced(st)
+		c.wasLeader = true
+	}
+	due := c.queue.pop(now)
+	if len(due) == 0 {
+		return
+	}
+	if st.VMs == nil {
+		st = c.state.GetStateCopy()
+	}
+	var pending []queueEntry
+	for _, e := range due {
+		if vm, ok := st.VMs[e.id]; ok && vm.NodeID == "" {
+			pending = append(pending, e)
+		} else {
+			c.queue.done(e.id)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		vi, vj := st.VMs[pending[i].id], st.VMs[pending[j].id]
+		if pi, pj := st.Priority(vi), st.Priority(vj); pi != pj {
+			return pi > pj
+		}
+		return vi.ID < vj.ID
+	})
+
+	var placed []api.VM
+	var latency []time.Duration
+	for _, e := range pending {
+		vm := st.VMs[e.id]
+		metrics.IncCounter("scheduler_attempts_total")
+		res, err := scheduler.Schedule(st, vm)
+		if err == nil && res.NodeID != "" {
+			vm.NodeID = res.NodeID
+			// the node agent reports Running once the runtime has actually started it
+			vm.Phase = "Scheduled"
+			vm.NominatedNode = ""
+			if vm.Status != nil && vm.Status.Reason == api.VMReasonFailedScheduling {
+				vm.Status.Reason, vm.Status.Message = "", ""
 			}
+			placed = append(placed, vm)
+			latency = append(latency, now.Sub(e.added))
+			// later VMs in this pass see the placement
+			st.VMs[vm.ID] = vm
+			n := st.Nodes[res.NodeID]
+			n.Allocated.CPU += vm.Resources.CPU
+			n.Allocated.Memory += vm.Resources.Memory
+			n.Allocated.Disk += vm.Resources.Disk
+			st.Nodes[res.NodeID] = n
+			continue
+		}
+		if err == nil && st.CanPreempt(vm) {
+			p, perr := scheduler.Preempt(st, vm)
+			if perr != nil {
+				log.Printf("preempt for vm %s: %v", vm.ID, perr)
+			} else if p.NodeID != "" {
+				c.preempt(p)
+				c.queue.deferUntil(vm.ID, now.Add(PreemptionGrace))
+				continue
+			}
+		}
+		metrics.IncCounter("scheduler_unschedulable_total")
+		msg := res.Summary()
+		if err != nil {
+			msg = err.Error()
+		}
+		c.failed(vm, msg)
+		c.queue.failed(vm.ID, now)
+	}
+	c.bind(placed, latency, now)
+}
+
+// bind commits the placements of a pass in one BindVMs apply. The FSM re-checks each
+// against the current state; VMs it rejects, or all of them when the apply fails, back off
+// and are tried again against fresh state.
+func (c *Controller) bind(placed []api.VM, latency []time.Duration, now time.Time) {
+	if len(placed) == 0 {
+		return
+	}
+	err := c.st.Apply(context.Background(), store.NewCommand("BindVMs", placed))
+	var conflicts store.BindConflicts
+	if err != nil && !errors.As(err, &conflicts) {
+		log.Printf("schedule %d vms: %v", len(placed), err)
+		for _, vm := range placed {
+			c.queue.failed(vm.ID, now)
+		}
+		return
+	}
+	for i, vm := range placed {
+		if why, ok := conflicts[vm.ID]; ok {
+			log.Printf("schedule vm %s: bind to %s rejected: %s", vm.ID, vm.NodeID, why)
+			metrics.IncCounter("scheduler_bind_conflicts_total")
+			c.queue.failed(vm.ID, now)
+			continue
+		}
+		c.queue.done(vm.ID)
+		metrics.IncCounter("scheduler_placements_total")
+		metrics.AddCounter("scheduler_latency_seconds_sum", latency[i].Seconds())
+		metrics.IncCounter("scheduler_latency_seconds_count")
+	}
+	metrics.IncCounter("scheduler_binds_total")
+}
+
+// preempt evicts the victims of p; the VM is placed once PreemptionGrace gave the node
+// agent the chance to stop them.
+func (c *Controller) preempt(p api.Preemption) {
+	p.Time = c.now().UTC()
+	log.Printf("schedule vm %s: preempting %v on %s", p.VMID, p.Victims, p.NodeID)
+	cmds := []store.Command{store.NewCommand("PreemptVM", p)}
+	for _, id := range p.Victims {
+		cmds = append(cmds, store.NewCommand("RecordEvent", api.Event{Time: p.Time, Type: api.EventWarning, Reason: api.VMReasonPreempted, Object: id,
+			Message: fmt.Sprintf("preempted by %s on %s", p.VMID, p.NodeID)}))
+	}
+	for _, cmd := range cmds {
+		if err := c.st.Apply(context.Background(), cmd); err != nil {
+			log.Printf("schedule vm %s: %s: %v", p.VMID, cmd.Type, err)
+			return
+		}
+	}
+}
+
+// failed records why the VM could not be placed, once per distinct reason.
+func (c *Controller) failed(vm api.VM, msg string) {
+	if vm.Status != nil && vm.Status.Reason == api.VMReasonFailedScheduling && vm.Status.Message == msg {
+		return
+	}
+	now := c.now().UTC()
+	st := api.VMStatus{VMID: vm.ID, Phase: "Pending", Reason: api.VMReasonFailedScheduling, Message: msg, ObservedAt: now}
+	if vm.Status != nil {
+		st.Restarts, st.LastRestartAt = vm.Status.Restarts, vm.Status.LastRestartAt
+	}
+	// only the status: the VM may have been deleted, edited or placed since the pass began,
+	// and the FSM ignores a status without a node for a VM that is gone or has one now
+	cmds := []store.Command{
+		store.NewCommand("UpdateVMStatus", st),
+		store.NewCommand("RecordEvent", api.Event{Time: now, Type: api.EventWarning, Reason: api.VMReasonFailedScheduling, Object: vm.ID, Message: msg}),
+	}
+	log.Printf("schedule vm %s: %s", vm.ID, msg)
+	for _, cmd := range cmds {
+		if err := c.st.Apply(context.Background(), cmd); err != nil {
+			log.Printf("schedule vm %s: %s: %v", vm.ID, cmd.Type, err)
+			return
 		}
 	}
 }
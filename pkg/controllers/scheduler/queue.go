@@ -0,0 +1,150 @@
+package schedulerctl
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff bounds for VMs no node fits: the first retry waits InitialBackoff, every
+// further failed attempt doubles the wait up to MaxBackoff.
+const (
+	InitialBackoff = time.Second
+	MaxBackoff     = 30 * time.Second
+)
+
+// queueEntry is a VM waiting for a node.
+type queueEntry struct {
+	id       string
+	added    time.Time // first enqueued since it was last placed, for the latency metric
+	attempts int
+	retryAt  time.Time // zero while active
+}
+
+// queue holds the VMs waiting for a node. Active VMs are tried on the next pass; VMs that
+// did not fit wait out their backoff unless a cluster change may let them fit now.
+type queue struct {
+	mu      sync.Mutex
+	entries map[string]*queueEntry
+	// resync asks the next pass to queue every unplaced VM.
+	resync bool
+	// wake is signalled when a VM becomes active.
+	wake chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{entries: map[string]*queueEntry{}, wake: make(chan struct{}, 1)}
+}
+
+func (q *queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// add makes a VM active, keeping the attempts of a VM already queued.
+func (q *queue) add(id string, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.entries[id]; ok {
+		e.retryAt = time.Time{}
+	} else {
+		q.entries[id] = &queueEntry{id: id, added: now}
+	}
+	q.signal()
+}
+
+// addIfAbsent queues a VM that is not queued yet, leaving backoffs alone.
+func (q *queue) addIfAbsent(id string, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[id]; !ok {
+		q.entries[id] = &queueEntry{id: id, added: now}
+		q.signal()
+	}
+}
+
+// activateAll ends every backoff, after a change that may let waiting VMs fit.
+func (q *queue) activateAll() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.entries {
+		e.retryAt = time.Time{}
+	}
+	if len(q.entries) > 0 {
+		q.signal()
+	}
+}
+
+// pop returns the VMs due for an attempt: active ones and those whose backoff expired.
+// They stay queued until done or failed.
+func (q *queue) pop(now time.Time) []queueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []queueEntry
+	for _, e := range q.entries {
+		if !e.retryAt.After(now) {
+			out = append(out, *e)
+		}
+	}
+	return out
+}
+
+// done removes a VM that was placed or no longer needs a node.
+func (q *queue) done(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.entries, id)
+}
+
+// failed backs a VM off after an attempt that found no node and returns the wait.
+func (q *queue) failed(id string, now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok {
+		e = &queueEntry{id: id, added: now}
+		q.entries[id] = e
+	}
+	e.attempts++
+	wait := InitialBackoff << min(e.attempts-1, 16)
+	wait = min(wait, MaxBackoff)
+	e.retryAt = now.Add(wait)
+	return wait
+}
+
+// deferUntil keeps a VM out of passes until t without counting an attempt.
+func (q *queue) deferUntil(id string, t time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.entries[id]; ok {
+		e.retryAt = t
+	}
+}
+
+func (q *queue) requestResync() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resync = true
+	q.signal()
+}
+
+func (q *queue) takeResync() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r := q.resync
+	q.resync = false
+	return r
+}
+
+// len returns the number of queued VMs and how many of them are backing off.
+func (q *queue) len(now time.Time) (total, backingOff int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.entries {
+		if e.retryAt.After(now) {
+			backingOff++
+		}
+	}
+	return len(q.entries), backingOff
+}
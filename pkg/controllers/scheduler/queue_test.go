@@ -0,0 +1,162 @@
+package schedulerctl
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"clustering/pkg/api"
+	"clustering/pkg/scheduler"
+	"clustering/pkg/store"
+)
+
+func TestQueueBackoff(t *testing.T) {
+	q := newQueue()
+	t0 := time.Unix(1000, 0)
+	q.add("a", t0)
+	q.add("b", t0)
+	if due := q.pop(t0); len(due) != 2 {
+		t.Fatalf("want both active, got %v", due)
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := q.failed("a", t0); got != want {
+			t.Fatalf("attempt %d: want backoff %s, got %s", i+1, want, got)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		q.failed("a", t0)
+	}
+	if due := q.pop(t0.Add(MaxBackoff - time.Millisecond)); len(due) != 1 || due[0].id != "b" {
+		t.Fatalf("a should back off for MaxBackoff, due %v", due)
+	}
+	if total, off := q.len(t0); total != 2 || off != 1 {
+		t.Fatalf("want 2 queued, 1 backing off, got %d/%d", total, off)
+	}
+
+	q.addIfAbsent("a", t0)
+	if due := q.pop(t0); len(due) != 1 {
+		t.Fatal("addIfAbsent must not end a backoff")
+	}
+	q.activateAll()
+	due := q.pop(t0)
+	if len(due) != 2 {
+		t.Fatalf("activateAll should end the backoff, due %v", due)
+	}
+	q.done("a")
+	q.done("b")
+	if total, _ := q.len(t0); total != 0 {
+		t.Fatalf("queue not empty: %d", total)
+	}
+}
+
+type fakeState struct{ st api.ClusterState }
+
+func (f *fakeState) GetStateCopy() api.ClusterState { return f.st }
+
+func TestControllerRetriesUnschedulableOnClusterChange(t *testing.T) {
+	fs := &fakeState{st: api.ClusterState{
+		Nodes: map[string]api.Node{"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}}},
+		VMs: map[string]api.VM{
+			"small": {ID: "small", Resources: api.Resources{CPU: 500, Memory: 100}},
+			"big":   {ID: "big", Resources: api.Resources{CPU: 4000, Memory: 100}},
+		},
+	}}
+	t0 := time.Unix(1000, 0)
+	c := NewController(fs, store.NewManager(nil), nil)
+	c.now = func() time.Time { return t0 }
+
+	c.tick()
+	if total, off := c.queue.len(t0); total != 1 || off != 1 {
+		t.Fatalf("small should be placed and big back off, got %d/%d", total, off)
+	}
+	c.tick()
+	if due := c.queue.pop(t0); len(due) != 0 {
+		t.Fatalf("big must wait for its backoff: %v", due)
+	}
+
+	// the same node upserted again changes nothing, a bigger one ends the backoff
+	node := store.NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}})
+	c.observe(node)
+	c.queue.failed("big", t0)
+	c.observe(node)
+	if due := c.queue.pop(t0); len(due) != 0 {
+		t.Fatal("an unchanged node must not end backoffs")
+	}
+	c.observe(store.NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 8000, Memory: 1024}}))
+	if due := c.queue.pop(t0); len(due) != 1 || due[0].id != "big" || due[0].attempts != 2 {
+		t.Fatalf("a grown node should retry big, due %v", due)
+	}
+}
+
+func TestControllerRetriesWhenNodesReturnToService(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	c := NewController(&fakeState{}, store.NewManager(nil), nil)
+	c.now = func() time.Time { return t0 }
+	for _, cmd := range []store.Command{
+		store.NewCommand("SetNodeStandby", api.NodeStandby{NodeID: "n1"}),
+		store.NewCommand("SetNodeFence", api.NodeFence{NodeID: "n1"}),
+		store.NewCommand("SetNodeDrain", api.DrainStatus{NodeID: "n1", Phase: api.DrainCancelled}),
+	} {
+		c.queue.add("vm1", t0)
+		c.queue.pop(t0)
+		c.queue.failed("vm1", t0)
+		c.observe(cmd)
+		if due := c.queue.pop(t0); len(due) != 1 {
+			t.Fatalf("%s should end the backoff, due %v", cmd.Type, due)
+		}
+		c.queue.done("vm1")
+	}
+}
+
+func TestControllerKeepsPreemptedRoomForPreemptor(t *testing.T) {
+	fsm := store.NewFSM()
+	apply := func(cmd store.Command) any {
+		b, _ := json.Marshal(cmd)
+		return fsm.Apply(&raft.Log{Data: b})
+	}
+	apply(store.NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}}))
+	apply(store.NewCommand("UpsertPriorityClass", api.PriorityClass{Name: "high", Value: 100}))
+	for _, id := range []string{"low1", "low2"} {
+		apply(store.NewCommand("BindVM", api.VM{ID: id, NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 500, Memory: 256}}))
+	}
+	apply(store.NewCommand("UpsertVM", api.VM{ID: "high", Resources: api.Resources{CPU: 800, Memory: 256},
+		Policy: api.VMSchedulingPolicy{PriorityClass: "high"}}))
+	m := store.NewManager(nil)
+	m.SetFSM(fsm)
+	t0 := time.Unix(1000, 0)
+	c := NewController(fsm, m, nil)
+	c.now = func() time.Time { return t0 }
+
+	// high fits nowhere and preempts both low VMs; the manager has no raft, so the
+	// eviction is applied here as the leader would
+	c.tick()
+	p, err := scheduler.Preempt(fsm.GetStateCopy(), fsm.GetStateCopy().VMs["high"])
+	if err != nil || p.NodeID != "n1" || len(p.Victims) != 2 {
+		t.Fatalf("want both low VMs preempted on n1, got %+v (%v)", p, err)
+	}
+	apply(store.NewCommand("PreemptVM", p))
+
+	// the evicted VMs are retried at once but must not take the room back
+	c.tick()
+	if total, off := c.queue.len(t0); total != 3 || off != 3 {
+		t.Fatalf("evicted VMs should back off while high waits, got %d queued, %d backing off", total, off)
+	}
+	if err, _ := apply(store.NewCommand("BindVM", api.VM{ID: "low1", NodeID: "n1", Resources: api.Resources{CPU: 500, Memory: 256}})).(error); !errors.Is(err, store.ErrBindConflict) {
+		t.Fatalf("binding an evicted VM back onto n1 should conflict, got %v", err)
+	}
+
+	// after the grace period high is placed on the room it freed
+	c.now = func() time.Time { return t0.Add(PreemptionGrace) }
+	c.tick()
+	if _, queued := c.queue.entries["high"]; queued {
+		t.Fatal("high was not placed after the grace period")
+	}
+	high := fsm.GetStateCopy().VMs["high"]
+	high.NodeID, high.NominatedNode = "n1", ""
+	if r := apply(store.NewCommand("BindVM", high)); r != nil {
+		t.Fatalf("bind high: %v", r)
+	}
+}
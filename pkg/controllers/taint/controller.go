@@ -0,0 +1,115 @@
+package taint
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"clustering/pkg/api"
+	"clustering/pkg/metrics"
+	"clustering/pkg/scheduler"
+	"clustering/pkg/store"
+)
+
+// DefaultConditionGrace is how long a VM without a matching toleration stays on a node
+// with a condition-derived NoExecute taint (e.g. not-ready), so a short heartbeat gap
+// does not move every VM. Operator NoExecute taints evict untolerating VMs immediately.
+const DefaultConditionGrace = 5 * time.Minute
+
+// Controller evicts VMs from nodes with NoExecute taints once their toleration grace
+// period has passed. Evicted VMs live-migrate when the source node is still alive and
+// are restarted elsewhere otherwise.
+type Controller struct {
+	st       *store.Manager
+	interval time.Duration
+	grace    time.Duration
+	isLeader func() bool
+	now      func() time.Time
+}
+
+func NewController(st *store.Manager, isLeader func() bool) *Controller {
+	return &Controller{st: st, interval: 5 * time.Second, grace: DefaultConditionGrace, isLeader: isLeader, now: time.Now}
+}
+
+// WithConditionGrace overrides DefaultConditionGrace.
+func (c *Controller) WithConditionGrace(d time.Duration) *Controller {
+	c.grace = d
+	return c
+}
+
+func (c *Controller) Run(stop <-chan struct{}) {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			c.tick()
+		}
+	}
+}
+
+func (c *Controller) tick() {
+	if c.isLeader != nil && !c.isLeader() {
+		return
+	}
+	state := c.st.GetStateCopy()
+	ids := make([]string, 0, len(state.VMs))
+	for id := range state.VMs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	now := c.now()
+	for _, id := range ids {
+		vm := state.VMs[id]
+		n, ok := state.Nodes[vm.NodeID]
+		// the failover controller owns VMs of nodes it fences
+		if !ok || vm.Phase == "Migrating" || n.Fence != nil {
+			continue
+		}
+		taint, due := c.evictionDue(n, vm, now)
+		if !due {
+			continue
+		}
+		target, ok := scheduler.ChooseNodeExcluding(state, vm, vm.NodeID)
+		if !ok {
+			log.Printf("taint: vm %s must leave %s (%s) but no node fits", vm.ID, n.ID, taint.Key)
+			continue
+		}
+		log.Printf("taint: evicting vm %s from %s (%s:%s) to %s", vm.ID, n.ID, taint.Key, taint.Effect, target)
+		if n.Status == "Alive" {
+			vm.Phase = "Migrating"
+		} else {
+			vm.Phase = "Scheduled"
+		}
+		vm.NodeID = target
+		if err := c.st.Apply(context.Background(), store.NewCommand("BindVM", vm)); err != nil {
+			log.Printf("taint: evict vm %s: %v", vm.ID, err)
+			continue
+		}
+		metrics.IncCounter("taint_evictions_total")
+		// later decisions in this pass see the new placement
+		state.VMs[vm.ID] = vm
+		tn := state.Nodes[target]
+		tn.Allocated.CPU += vm.Resources.CPU
+		tn.Allocated.Memory += vm.Resources.Memory
+		tn.Allocated.Disk += vm.Resources.Disk
+		state.Nodes[target] = tn
+	}
+}
+
+// evictionDue returns the NoExecute taint whose grace period for vm has run out, if any.
+func (c *Controller) evictionDue(n api.Node, vm api.VM, now time.Time) (api.Taint, bool) {
+	for _, t := range n.Taints {
+		grace := time.Duration(0)
+		if api.IsConditionTaint(t) {
+			grace = c.grace
+		}
+		if at, ok := api.EvictionTime(t, vm.Policy.Tolerations, grace); ok && !now.Before(at) {
+			return t, true
+		}
+	}
+	return api.Taint{}, false
+}
@@ -0,0 +1,35 @@
+package taint
+
+import (
+	"testing"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+func TestEvictionDue(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewController(nil, nil).WithConditionGrace(time.Minute)
+	notReady := api.Node{ID: "n1", Taints: []api.Taint{{Key: api.TaintNotReady, Effect: api.TaintNoExecute, TimeAdded: t0}}}
+	ten := int64(10)
+	cases := []struct {
+		name string
+		node api.Node
+		tols []api.Toleration
+		at   time.Time
+		due  bool
+	}{
+		{"condition taint within default grace", notReady, nil, t0.Add(30 * time.Second), false},
+		{"condition taint after default grace", notReady, nil, t0.Add(time.Minute), true},
+		{"toleration seconds", notReady, []api.Toleration{{Key: api.TaintNotReady, Operator: "Exists", TolerationSeconds: &ten}}, t0.Add(10 * time.Second), true},
+		{"tolerated forever", notReady, []api.Toleration{{Operator: "Exists"}}, t0.Add(time.Hour), false},
+		{"operator taint evicts at once", api.Node{Taints: []api.Taint{{Key: "maintenance", Effect: api.TaintNoExecute, TimeAdded: t0}}}, nil, t0, true},
+		{"NoSchedule never evicts", api.Node{Taints: []api.Taint{{Key: api.TaintDiskPressure, Effect: api.TaintNoSchedule, TimeAdded: t0}}}, nil, t0.Add(time.Hour), false},
+	}
+	for _, tc := range cases {
+		vm := api.VM{ID: "vm1", NodeID: "n1", Policy: api.VMSchedulingPolicy{Tolerations: tc.tols}}
+		if _, due := c.evictionDue(tc.node, vm, tc.at); due != tc.due {
+			t.Errorf("%s: due=%v want %v", tc.name, due, tc.due)
+		}
+	}
+}
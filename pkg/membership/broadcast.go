@@ -0,0 +1,186 @@
+package membership
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+// Well-known cluster events (fire-and-forget) and queries (request/response).
+const (
+	EventConfigChanged   = "config-changed"
+	EventCacheInvalidate = "cache-invalidate"
+
+	QueryDiagnostics = "diagnostics"
+	QueryVMRunning   = "vm-running"
+)
+
+// EventHandler receives the payload of a cluster event.
+type EventHandler func(payload []byte)
+
+// QueryHandler answers a cluster query; the result is JSON-encoded into the response.
+type QueryHandler func(payload []byte) (any, error)
+
+// QueryOptions restricts who answers a query and how long to wait.
+type QueryOptions struct {
+	Timeout     time.Duration
+	FilterNodes []string
+	FilterTags  map[string]string // tag -> regexp
+}
+
+// QueryResponse is one member's answer to a query.
+type QueryResponse struct {
+	From    string          `json:"from"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Broadcaster sends typed cluster events and queries over serf user events/queries
+// and dispatches incoming ones to registered handlers.
+type Broadcaster struct {
+	mu      sync.RWMutex
+	serf    *serf.Serf
+	events  map[string]EventHandler
+	queries map[string]QueryHandler
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{events: map[string]EventHandler{}, queries: map[string]QueryHandler{}}
+}
+
+// HandleEvent registers the handler for a named event, replacing any previous one.
+func (b *Broadcaster) HandleEvent(name string, h EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events[name] = h
+}
+
+// HandleQuery registers the responder for a named query, replacing any previous one.
+func (b *Broadcaster) HandleQuery(name string, h QueryHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queries[name] = h
+}
+
+func (b *Broadcaster) attach(s *serf.Serf) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.serf = s
+}
+
+func (b *Broadcaster) agent() (*serf.Serf, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.serf == nil {
+		return nil, errors.New("broadcaster not attached to serf")
+	}
+	return b.serf, nil
+}
+
+// Broadcast sends a fire-and-forget event with a JSON payload to every member.
+func (b *Broadcaster) Broadcast(name string, v any) error {
+	s, err := b.agent()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.UserEvent(name, payload, false)
+}
+
+// Query sends a query with a JSON payload and collects responses until the timeout
+// elapses or ctx is cancelled.
+func (b *Broadcaster) Query(ctx context.Context, name string, v any, opts QueryOptions) ([]QueryResponse, error) {
+	s, err := b.agent()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Query(name, payload, &serf.QueryParam{FilterNodes: opts.FilterNodes, FilterTags: opts.FilterTags, Timeout: opts.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	out := []QueryResponse{}
+	for {
+		select {
+		case <-ctx.Done():
+			return out, ctx.Err()
+		case r, ok := <-resp.ResponseCh():
+			if !ok {
+				return out, nil
+			}
+			var qr QueryResponse
+			if err := json.Unmarshal(r.Payload, &qr); err != nil {
+				qr = QueryResponse{Error: fmt.Sprintf("bad response: %v", err)}
+			}
+			qr.From = r.From
+			out = append(out, qr)
+		}
+	}
+}
+
+func (b *Broadcaster) dispatchEvent(name string, payload []byte) {
+	b.mu.RLock()
+	h := b.events[name]
+	b.mu.RUnlock()
+	if h != nil {
+		h(payload)
+	}
+}
+
+// answer runs the registered handler and encodes its result; ok is false when
+// this member has no handler and should stay silent.
+func (b *Broadcaster) answer(name string, payload []byte) (resp []byte, ok bool) {
+	b.mu.RLock()
+	h := b.queries[name]
+	b.mu.RUnlock()
+	if h == nil {
+		return nil, false
+	}
+	var qr QueryResponse
+	v, err := h(payload)
+	if err != nil {
+		qr.Error = err.Error()
+	} else if qr.Payload, err = json.Marshal(v); err != nil {
+		qr.Error = err.Error()
+	}
+	out, _ := json.Marshal(qr)
+	return out, true
+}
+
+func (b *Broadcaster) respond(q *serf.Query) {
+	resp, ok := b.answer(q.Name, q.Payload)
+	if !ok {
+		return
+	}
+	if err := q.Respond(resp); err != nil {
+		log.Printf("serf query %s: respond: %v", q.Name, err)
+	}
+}
+
+// DiagnosticsHandler answers QueryDiagnostics with basic process information.
+func DiagnosticsHandler(nodeID, role string) QueryHandler {
+	started := time.Now()
+	return func([]byte) (any, error) {
+		return map[string]any{
+			"nodeId":     nodeID,
+			"role":       role,
+			"uptime":     time.Since(started).Round(time.Second).String(),
+			"goroutines": runtime.NumGoroutine(),
+			"goVersion":  runtime.Version(),
+		}, nil
+	}
+}
@@ -0,0 +1,52 @@
+package membership
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestBroadcasterDispatchAndAnswer(t *testing.T) {
+	b := NewBroadcaster()
+	var got string
+	b.HandleEvent(EventCacheInvalidate, func(p []byte) { got = string(p) })
+	b.dispatchEvent(EventCacheInvalidate, []byte(`{"key":"vms"}`))
+	b.dispatchEvent("unknown", []byte("ignored"))
+	if got != `{"key":"vms"}` {
+		t.Fatalf("event not dispatched: %q", got)
+	}
+
+	b.HandleQuery(QueryVMRunning, func(p []byte) (any, error) {
+		var req struct{ VMID string }
+		_ = json.Unmarshal(p, &req)
+		return map[string]bool{"running": req.VMID == "vm1"}, nil
+	})
+	b.HandleQuery("broken", func([]byte) (any, error) { return nil, errors.New("boom") })
+
+	raw, ok := b.answer(QueryVMRunning, []byte(`{"VMID":"vm1"}`))
+	if !ok {
+		t.Fatal("expected an answer")
+	}
+	var qr QueryResponse
+	if err := json.Unmarshal(raw, &qr); err != nil || string(qr.Payload) != `{"running":true}` {
+		t.Fatalf("unexpected response %s err=%v", raw, err)
+	}
+	raw, _ = b.answer("broken", nil)
+	if err := json.Unmarshal(raw, &qr); err != nil || qr.Error != "boom" {
+		t.Fatalf("handler error not propagated: %s", raw)
+	}
+	if _, ok := b.answer("nobody-handles-this", nil); ok {
+		t.Fatal("members without a handler must stay silent")
+	}
+}
+
+func TestBroadcasterRequiresSerf(t *testing.T) {
+	b := NewBroadcaster()
+	if err := b.Broadcast(EventConfigChanged, nil); err == nil {
+		t.Fatal("expected error before attach")
+	}
+	if _, err := b.Query(context.Background(), QueryDiagnostics, nil, QueryOptions{}); err == nil {
+		t.Fatal("expected error before attach")
+	}
+}
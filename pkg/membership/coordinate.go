@@ -0,0 +1,22 @@
+package membership
+
+import (
+	"github.com/hashicorp/serf/coordinate"
+	"github.com/hashicorp/serf/serf"
+
+	"clustering/pkg/api"
+)
+
+// CoordinateOf returns the cached Vivaldi coordinate of a member (including the local one).
+func CoordinateOf(s *serf.Serf, name string) *api.Coordinate {
+	var c *coordinate.Coordinate
+	if name == s.LocalMember().Name {
+		c, _ = s.GetCoordinate()
+	} else {
+		c, _ = s.GetCachedCoordinate(name)
+	}
+	if c == nil {
+		return nil
+	}
+	return &api.Coordinate{Vec: c.Vec, Error: c.Error, Adjustment: c.Adjustment, Height: c.Height}
+}
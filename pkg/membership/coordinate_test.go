@@ -0,0 +1,25 @@
+package membership
+
+import (
+	"testing"
+
+	"github.com/hashicorp/serf/coordinate"
+
+	"clustering/pkg/api"
+)
+
+func TestCoordinateRTTMatchesSerf(t *testing.T) {
+	a := &api.Coordinate{Vec: []float64{0.001, 0.002, 0.0}, Error: 0.2, Adjustment: 0.0001, Height: 0.00002}
+	b := &api.Coordinate{Vec: []float64{0.004, -0.002, 0.003}, Error: 0.3, Adjustment: -0.0002, Height: 0.00001}
+	ca := &coordinate.Coordinate{Vec: a.Vec, Error: a.Error, Adjustment: a.Adjustment, Height: a.Height}
+	cb := &coordinate.Coordinate{Vec: b.Vec, Error: b.Error, Adjustment: b.Adjustment, Height: b.Height}
+	if d, ok := a.RTT(b); !ok || d != ca.DistanceTo(cb) {
+		t.Fatalf("want %v, got %v (ok=%v)", ca.DistanceTo(cb), d, ok)
+	}
+	if _, ok := a.RTT(&api.Coordinate{Vec: []float64{0}}); ok {
+		t.Fatalf("coordinates of different dimensionality are not comparable")
+	}
+	if _, ok := a.RTT(nil); ok {
+		t.Fatalf("missing coordinate must not yield an RTT")
+	}
+}
@@ -0,0 +1,81 @@
+package membership
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// GenerateKey returns a new random base64-encoded 32-byte (AES-256) gossip key.
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// KeyFingerprint identifies a gossip key without revealing it: the first 8 bytes of its
+// SHA-256, hex-encoded.
+func KeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadKeyring builds the gossip keyring from a serf keyring file (a JSON list of base64
+// keys, primary first) or, if the file does not exist yet, from a single initial key which
+// is then written to the file so later rotations persist. It returns nil when neither is set,
+// which leaves gossip unencrypted.
+func LoadKeyring(path, initialKey string) (*memberlist.Keyring, error) {
+	var encoded []string
+	if path != "" {
+		b, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(b, &encoded); err != nil {
+				return nil, fmt.Errorf("parse keyring %s: %w", path, err)
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+	if len(encoded) == 0 && initialKey != "" {
+		encoded = []string{initialKey}
+		if path != "" {
+			if err := writeKeyringFile(path, encoded); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	keys := make([][]byte, 0, len(encoded))
+	for _, k := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("decode gossip key: %w", err)
+		}
+		if err := memberlist.ValidateKey(raw); err != nil {
+			return nil, err
+		}
+		keys = append(keys, raw)
+	}
+	return memberlist.NewKeyring(keys, keys[0])
+}
+
+func writeKeyringFile(path string, keys []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(keys, "", "  ")
+	return os.WriteFile(path, b, 0o600)
+}
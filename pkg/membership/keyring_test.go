@@ -0,0 +1,32 @@
+package membership
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadKeyringPersistsInitialKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "serf", "keyring")
+	kr, err := LoadKeyring(path, key)
+	if err != nil || kr == nil {
+		t.Fatalf("load: kr=%v err=%v", kr, err)
+	}
+	// second load reads the file even without the initial key
+	kr2, err := LoadKeyring(path, "")
+	if err != nil || kr2 == nil || len(kr2.GetKeys()) != 1 {
+		t.Fatalf("reload: kr=%v err=%v", kr2, err)
+	}
+}
+
+func TestLoadKeyringUnencryptedAndInvalid(t *testing.T) {
+	if kr, err := LoadKeyring("", ""); kr != nil || err != nil {
+		t.Fatalf("want no keyring, got %v %v", kr, err)
+	}
+	if _, err := LoadKeyring("", "c2hvcnQ="); err == nil {
+		t.Fatal("expected error for a key of invalid length")
+	}
+}
@@ -3,6 +3,7 @@ package membership
 import (
 	"log"
 	"net"
+	"strings"
 
 	"github.com/hashicorp/serf/serf"
 )
@@ -10,6 +11,12 @@ import (
 type Config struct {
 	NodeID   string
 	BindAddr string // host:port
+	// KeyringFile persists the gossip keyring; serf rewrites it on key rotation.
+	KeyringFile string
+	// EncryptKey is the initial base64 gossip key used when KeyringFile does not exist yet.
+	EncryptKey string
+	// Broadcaster, when set, is attached to the agent and receives user events and queries.
+	Broadcaster *Broadcaster
 }
 
 type Event struct {
@@ -29,6 +36,15 @@ func MustStartSerf(cfg Config) (*serf.Serf, <-chan Event) {
 	sc.MemberlistConfig.AdvertiseAddr = host
 	sc.MemberlistConfig.AdvertisePort = port
 
+	keyring, err := LoadKeyring(cfg.KeyringFile, cfg.EncryptKey)
+	if err != nil {
+		log.Fatalf("serf keyring: %v", err)
+	}
+	if keyring != nil {
+		sc.MemberlistConfig.Keyring = keyring
+		sc.KeyringFile = cfg.KeyringFile
+	}
+
 	ch := make(chan serf.Event, 64)
 	sc.EventCh = ch
 
@@ -36,6 +52,9 @@ func MustStartSerf(cfg Config) (*serf.Serf, <-chan Event) {
 	if err != nil {
 		log.Fatalf("serf create: %v", err)
 	}
+	if cfg.Broadcaster != nil {
+		cfg.Broadcaster.attach(s)
+	}
 
 	out := make(chan Event, 64)
 	go func() {
@@ -46,7 +65,14 @@ func MustStartSerf(cfg Config) (*serf.Serf, <-chan Event) {
 					out <- Event{Type: e.EventType().String(), Node: m.Name}
 				}
 			case serf.UserEvent:
+				if cfg.Broadcaster != nil {
+					cfg.Broadcaster.dispatchEvent(e.Name, e.Payload)
+				}
 				out <- Event{Type: "user:" + e.Name, Node: string(e.Payload)}
+			case *serf.Query:
+				if cfg.Broadcaster != nil {
+					go cfg.Broadcaster.respond(e)
+				}
 			}
 		}
 	}()
@@ -67,3 +93,12 @@ func hostPort(addr string) (string, int) {
 	}
 	return h, port
 }
+
+// PortOf returns the port part of a listen address such as ":9090" or "0.0.0.0:9090",
+// for advertising it in serf tags.
+func PortOf(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		return addr[i+1:]
+	}
+	return addr
+}
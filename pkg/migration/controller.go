@@ -39,18 +39,19 @@ func (c *Controller) Run(stop <-chan struct{}) {
 
 func (c *Controller) tick() {
 	st := c.state.GetStateCopy()
-	// find VMs on non-Alive nodes and re-place them
+	// Re-place VMs whose node is gone from the state. VMs on failed nodes are left to the
+	// failover controller, which fences the node first so no VM runs twice.
 	for _, vm := range st.VMs {
 		if vm.NodeID == "" {
 			continue
 		}
-		n, ok := st.Nodes[vm.NodeID]
-		if !ok || n.Status != "Alive" {
+		if _, ok := st.Nodes[vm.NodeID]; !ok {
 			// choose new node
 			if nid, ok := scheduler.ChooseNode(st, vm); ok {
 				vm.NodeID = nid
-				vm.Phase = "Migrating"
-				if err := c.st.Apply(context.Background(), store.NewCommand("UpsertVM", vm)); err != nil {
+				// the old node is gone, so this is a restart rather than a live migration
+				vm.Phase = "Scheduled"
+				if err := c.st.Apply(context.Background(), store.NewCommand("BindVM", vm)); err != nil {
 					log.Printf("migration propose error %s -> %s: %v", vm.ID, nid, err)
 				}
 			}
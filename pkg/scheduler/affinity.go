@@ -0,0 +1,220 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"clustering/pkg/api"
+)
+
+// topologyDomain returns the domain of n for a topology key: the node itself when the key
+// is empty, otherwise the node's value for the label. Nodes without the label belong to
+// no domain.
+func topologyDomain(n api.Node, key string) (string, bool) {
+	if key == "" {
+		return n.ID, true
+	}
+	v, ok := n.Labels[key]
+	return v, ok
+}
+
+func describeDomain(key, d string) string {
+	if key == "" {
+		return "node " + d
+	}
+	return key + "=" + d
+}
+
+func describeTopology(key string) string {
+	if key == "" {
+		return "node"
+	}
+	return key
+}
+
+func describeRequirement(r api.LabelRequirement) string {
+	switch r.Operator {
+	case api.LabelExists:
+		return r.Key
+	case api.LabelDoesNotExist:
+		return "!" + r.Key
+	}
+	return fmt.Sprintf("%s %s (%s)", r.Key, strings.ToLower(r.Operator), strings.Join(r.Values, ","))
+}
+
+// domainCounts counts the placed VMs other than vm that match sel, per topology domain.
+func domainCounts(state *api.ClusterState, vm api.VM, sel []api.LabelRequirement, key string) map[string]int {
+	counts := map[string]int{}
+	for _, other := range state.VMs {
+		if other.ID == vm.ID || other.NodeID == "" || !api.MatchLabels(sel, other.Labels) {
+			continue
+		}
+		n, ok := state.Nodes[other.NodeID]
+		if !ok {
+			continue
+		}
+		if d, ok := topologyDomain(n, key); ok {
+			counts[d]++
+		}
+	}
+	return counts
+}
+
+// vmAffinity enforces required VM affinity and anti-affinity terms against the VMs
+// already placed and, as a score plugin, rates nodes by the preferred terms they satisfy.
+// A required affinity term nothing matches yet is satisfied by a VM matching its own
+// selector, so the first replica of a group can be placed.
+type vmAffinity struct{}
+
+func (vmAffinity) Name() string { return PluginVMAffinity }
+func (vmAffinity) Filter(state *api.ClusterState, vm api.VM, n api.Node) string {
+	for _, t := range vm.Policy.VMAntiAffinity {
+		if t.Weight > 0 {
+			continue
+		}
+		d, ok := topologyDomain(n, t.TopologyKey)
+		if c := domainCounts(state, vm, t.Selector, t.TopologyKey)[d]; ok && c > 0 {
+			return fmt.Sprintf("anti-affinity with %d vms in %s", c, describeDomain(t.TopologyKey, d))
+		}
+	}
+	for _, t := range vm.Policy.VMAffinity {
+		if t.Weight > 0 {
+			continue
+		}
+		counts := domainCounts(state, vm, t.Selector, t.TopologyKey)
+		if d, ok := topologyDomain(n, t.TopologyKey); ok && counts[d] > 0 {
+			continue
+		}
+		if len(counts) == 0 && api.MatchLabels(t.Selector, vm.Labels) {
+			continue
+		}
+		return "no vms matching affinity on the node's " + describeTopology(t.TopologyKey)
+	}
+	return ""
+}
+
+func (vmAffinity) Score(state *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	raw := make([]int64, len(nodes))
+	preferred := false
+	rate := func(terms []api.VMAffinityTerm, sign int64) {
+		for _, t := range terms {
+			if t.Weight == 0 {
+				continue
+			}
+			preferred = true
+			counts := domainCounts(state, vm, t.Selector, t.TopologyKey)
+			for i, n := range nodes {
+				if d, ok := topologyDomain(n, t.TopologyKey); ok && counts[d] > 0 {
+					raw[i] += sign * int64(t.Weight)
+				}
+			}
+		}
+	}
+	rate(vm.Policy.VMAffinity, 1)
+	rate(vm.Policy.VMAntiAffinity, -1)
+	if !preferred {
+		return make([]int64, len(nodes))
+	}
+	return normalize(raw)
+}
+
+// normalize maps raw scores onto 0..MaxNodeScore, best first; equal scores all get 0.
+func normalize(raw []int64) []int64 {
+	out := make([]int64, len(raw))
+	if len(raw) == 0 {
+		return out
+	}
+	lo, hi := raw[0], raw[0]
+	for _, r := range raw {
+		lo, hi = min(lo, r), max(hi, r)
+	}
+	if hi == lo {
+		return out
+	}
+	for i, r := range raw {
+		out[i] = MaxNodeScore * (r - lo) / (hi - lo)
+	}
+	return out
+}
+
+// spreadSelector is the constraint's selector, or the VM's own labels when it has none.
+func spreadSelector(vm api.VM, c api.TopologySpreadConstraint) []api.LabelRequirement {
+	if len(c.Selector) > 0 {
+		return c.Selector
+	}
+	keys := make([]string, 0, len(vm.Labels))
+	for k := range vm.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sel := make([]api.LabelRequirement, 0, len(keys))
+	for _, k := range keys {
+		sel = append(sel, api.LabelRequirement{Key: k, Operator: api.LabelIn, Values: []string{vm.Labels[k]}})
+	}
+	return sel
+}
+
+// spreadCounts counts matching VMs in every domain of the constraint's key, including the
+// empty domains of alive, schedulable nodes.
+func spreadCounts(state *api.ClusterState, vm api.VM, c api.TopologySpreadConstraint) map[string]int {
+	counts := domainCounts(state, vm, spreadSelector(vm, c), c.TopologyKey)
+	for _, n := range state.Nodes {
+		if n.Status != "Alive" || n.Unschedulable || n.Maintenance {
+			continue
+		}
+		if d, ok := topologyDomain(n, c.TopologyKey); ok {
+			counts[d] += 0
+		}
+	}
+	return counts
+}
+
+func minCount(counts map[string]int) int {
+	lo := -1
+	for _, c := range counts {
+		if lo < 0 || c < lo {
+			lo = c
+		}
+	}
+	return max(lo, 0)
+}
+
+// topologySpread rejects nodes where placing the VM would leave its domain more than
+// MaxSkew VMs ahead of the emptiest one (DoNotSchedule constraints) and, as a score
+// plugin, prefers the domains with the fewest matching VMs for every constraint.
+type topologySpread struct{}
+
+func (topologySpread) Name() string { return PluginTopologySpread }
+func (topologySpread) Filter(state *api.ClusterState, vm api.VM, n api.Node) string {
+	for _, c := range vm.Policy.TopologySpread {
+		if c.WhenUnsatisfiable == api.ScheduleAnyway {
+			continue
+		}
+		d, ok := topologyDomain(n, c.TopologyKey)
+		if !ok {
+			return "node has no " + c.TopologyKey + " label"
+		}
+		counts := spreadCounts(state, vm, c)
+		if skew := counts[d] + 1 - minCount(counts); skew > max(c.MaxSkew, 1) {
+			return fmt.Sprintf("skew %d exceeds %d on %s", skew, max(c.MaxSkew, 1), describeDomain(c.TopologyKey, d))
+		}
+	}
+	return ""
+}
+
+func (topologySpread) Score(state *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	raw := make([]int64, len(nodes))
+	for _, c := range vm.Policy.TopologySpread {
+		counts := spreadCounts(state, vm, c)
+		for i, n := range nodes {
+			d, ok := topologyDomain(n, c.TopologyKey)
+			if !ok {
+				raw[i] -= int64(len(state.VMs))
+				continue
+			}
+			raw[i] -= int64(counts[d])
+		}
+	}
+	return normalize(raw)
+}
@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func zoneNodes() map[string]api.Node {
+	nodes := map[string]api.Node{}
+	for id, zone := range map[string]string{"n1": "a", "n2": "a", "n3": "b", "n4": "c"} {
+		nodes[id] = api.Node{ID: id, Status: "Alive", Capacity: api.Resources{CPU: 8000, Memory: 8192}, Labels: map[string]string{"zone": zone, "disk": "ssd"}}
+	}
+	n := nodes["n4"]
+	n.Labels["disk"] = "hdd"
+	nodes["n4"] = n
+	return nodes
+}
+
+func db(id, node string) api.VM {
+	return api.VM{ID: id, NodeID: node, Labels: map[string]string{"app": "db"}, Resources: api.Resources{CPU: 100, Memory: 100}}
+}
+
+func TestVMAntiAffinityKeepsReplicasApart(t *testing.T) {
+	st := api.ClusterState{Nodes: zoneNodes(), VMs: map[string]api.VM{"db1": db("db1", "n1"), "db2": db("db2", "n3")}}
+	vm := db("db3", "")
+	vm.Policy.VMAntiAffinity = []api.VMAffinityTerm{{Selector: []api.LabelRequirement{{Key: "app", Operator: api.LabelIn, Values: []string{"db"}}}}}
+	res, err := Schedule(st, vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nr := range res.Nodes {
+		if want := nr.NodeID == "n2" || nr.NodeID == "n4"; nr.Feasible != want {
+			t.Errorf("%s: feasible=%v, reasons %v", nr.NodeID, nr.Feasible, nr.Reasons)
+		}
+	}
+
+	// per zone only zone c is left
+	vm.Policy.VMAntiAffinity[0].TopologyKey = "zone"
+	if id, _ := ChooseNode(st, vm); id != "n4" {
+		t.Fatalf("want n4, got %s", id)
+	}
+	st.VMs["db3"] = db("db3", "n4")
+	vm.ID = "db4"
+	res, _ = Schedule(st, vm)
+	if res.NodeID != "" || !strings.Contains(res.Nodes[0].Reasons[0], "VMAffinity: anti-affinity with 1 vms in zone=") {
+		t.Fatalf("every zone holds a replica, got %+v", res)
+	}
+}
+
+func TestVMAffinityFollowsGroup(t *testing.T) {
+	st := api.ClusterState{Nodes: zoneNodes(), VMs: map[string]api.VM{}}
+	sel := []api.LabelRequirement{{Key: "app", Operator: api.LabelIn, Values: []string{"db"}}}
+	vm := db("db1", "")
+	vm.Policy.VMAffinity = []api.VMAffinityTerm{{Selector: sel, TopologyKey: "zone"}}
+	if _, ok := ChooseNode(st, vm); !ok {
+		t.Fatal("the first vm of a group matching its own selector must be placeable")
+	}
+	st.VMs["db1"] = db("db1", "n3")
+	vm.ID = "db2"
+	if id, _ := ChooseNode(st, vm); id != "n3" {
+		t.Fatalf("want zone b (n3), got %s", id)
+	}
+
+	// preferred affinity only scores
+	web := api.VM{ID: "web", Resources: api.Resources{CPU: 100, Memory: 100}}
+	web.Policy.VMAffinity = []api.VMAffinityTerm{{Selector: sel, TopologyKey: "zone", Weight: 50}}
+	if id, _ := ChooseNode(st, web); id != "n3" {
+		t.Fatalf("preferred affinity should pick n3, got %s", id)
+	}
+}
+
+func TestNodeAffinityExpressions(t *testing.T) {
+	st := api.ClusterState{Nodes: zoneNodes()}
+	vm := api.VM{ID: "vm1", Resources: api.Resources{CPU: 100, Memory: 100}}
+	vm.Policy.NodeAffinity = &api.NodeAffinity{
+		Required:  []api.LabelRequirement{{Key: "zone", Operator: api.LabelNotIn, Values: []string{"a"}}, {Key: "disk", Operator: api.LabelExists}},
+		Preferred: []api.PreferredLabels{{Weight: 10, Match: []api.LabelRequirement{{Key: "disk", Operator: api.LabelIn, Values: []string{"hdd"}}}}},
+	}
+	res, err := Schedule(st, vm)
+	if err != nil || res.NodeID != "n4" {
+		t.Fatalf("want n4, got %+v (%v)", res, err)
+	}
+	for _, nr := range res.Nodes {
+		if nr.NodeID == "n1" && (nr.Feasible || nr.Reasons[0] != "Affinity: node lacks labels zone notin (a)") {
+			t.Errorf("n1 should be rejected by the zone expression, got %+v", nr)
+		}
+	}
+}
+
+func TestTopologySpreadMaxSkew(t *testing.T) {
+	st := api.ClusterState{Nodes: zoneNodes(), VMs: map[string]api.VM{"db1": db("db1", "n1"), "db2": db("db2", "n3")}}
+	vm := db("db3", "")
+	vm.Policy.TopologySpread = []api.TopologySpreadConstraint{{TopologyKey: "zone", MaxSkew: 1}}
+	res, err := Schedule(st, vm)
+	if err != nil || res.NodeID != "n4" {
+		t.Fatalf("want the empty zone c, got %+v (%v)", res, err)
+	}
+	for _, nr := range res.Nodes {
+		if nr.NodeID == "n2" && (nr.Feasible || !strings.Contains(nr.Reasons[0], "skew 2 exceeds 1 on zone=a")) {
+			t.Errorf("n2 should exceed the skew, got %+v", nr)
+		}
+	}
+
+	// ScheduleAnyway prefers zone c without rejecting the others
+	vm.Policy.TopologySpread[0].WhenUnsatisfiable = api.ScheduleAnyway
+	st.Nodes["n4"] = api.Node{ID: "n4", Status: "Alive", Labels: map[string]string{"zone": "c"}}
+	res, _ = Schedule(st, vm)
+	if res.NodeID == "" || res.NodeID == "n4" {
+		t.Fatalf("n4 is full, want another node, got %+v", res)
+	}
+}
+
+func TestSchedulingPolicyValidate(t *testing.T) {
+	bad := []api.VMSchedulingPolicy{
+		{NodeAffinity: &api.NodeAffinity{Required: []api.LabelRequirement{{Key: "zone", Operator: "Like"}}}},
+		{NodeAffinity: &api.NodeAffinity{Required: []api.LabelRequirement{{Key: "zone", Operator: api.LabelIn}}}},
+		{NodeAffinity: &api.NodeAffinity{Preferred: []api.PreferredLabels{{Weight: 0}}}},
+		{VMAntiAffinity: []api.VMAffinityTerm{{}}},
+		{VMAffinity: []api.VMAffinityTerm{{Selector: []api.LabelRequirement{{Key: "app", Operator: api.LabelExists}}, Weight: 101}}},
+		{TopologySpread: []api.TopologySpreadConstraint{{MaxSkew: 1}}},
+		{TopologySpread: []api.TopologySpreadConstraint{{TopologyKey: "zone", WhenUnsatisfiable: "Maybe"}}},
+	}
+	for i, p := range bad {
+		if p.Validate() == nil {
+			t.Errorf("policy %d should be invalid", i)
+		}
+	}
+	ok := api.VMSchedulingPolicy{
+		VMAntiAffinity: []api.VMAffinityTerm{{Selector: []api.LabelRequirement{{Key: "app", Operator: api.LabelIn, Values: []string{"db"}}}, TopologyKey: "zone"}},
+		TopologySpread: []api.TopologySpreadConstraint{{TopologyKey: "zone", WhenUnsatisfiable: api.ScheduleAnyway}},
+	}
+	if err := ok.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
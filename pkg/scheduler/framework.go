@@ -0,0 +1,217 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+
+	"clustering/pkg/api"
+)
+
+// MaxNodeScore is the best score a score plugin gives a node.
+const MaxNodeScore = 100
+
+// FilterPlugin rejects nodes a VM cannot run on.
+type FilterPlugin interface {
+	Name() string
+	// Filter returns why the VM cannot run on n, or "" if it can.
+	Filter(state *api.ClusterState, vm api.VM, n api.Node) string
+}
+
+// ScorePlugin rates the nodes that passed every filter.
+type ScorePlugin interface {
+	Name() string
+	// Score returns a score between 0 and MaxNodeScore for each node, higher is better.
+	Score(state *api.ClusterState, vm api.VM, nodes []api.Node) []int64
+}
+
+var (
+	filterPlugins = map[string]FilterPlugin{}
+	scorePlugins  = map[string]ScorePlugin{}
+	// filterOrder keeps reasons in registration order.
+	filterOrder []string
+)
+
+// RegisterFilter makes a filter plugin available to profiles; it replaces a plugin of the
+// same name.
+func RegisterFilter(p FilterPlugin) {
+	if _, ok := filterPlugins[p.Name()]; !ok {
+		filterOrder = append(filterOrder, p.Name())
+	}
+	filterPlugins[p.Name()] = p
+}
+
+// RegisterScore makes a score plugin available to profiles.
+func RegisterScore(p ScorePlugin) { scorePlugins[p.Name()] = p }
+
+// DefaultProfile places VMs that do not name a profile.
+const DefaultProfile = "default"
+
+// BuiltinProfiles returns the profiles available without configuration: "default" spreads
+// VMs over the least allocated nodes, "binpack" fills the most allocated ones first. Both
+// prefer nodes close to the VM's NearNode/NearVolume, honour preferred affinity and
+// topology spread rules and avoid PreferNoSchedule taints.
+func BuiltinProfiles() []api.SchedulerProfile {
+	common := []api.PluginWeight{
+		{Name: PluginCoordinates, Weight: 10},
+		{Name: PluginAffinity, Weight: 5},
+		{Name: PluginVMAffinity, Weight: 5},
+		{Name: PluginTopologySpread, Weight: 5},
+		{Name: PluginTaintPreference, Weight: 100},
+	}
+	return []api.SchedulerProfile{
+		{Name: DefaultProfile, Scores: append([]api.PluginWeight{{Name: PluginSpread, Weight: 1}}, common...)},
+		{Name: "binpack", Scores: append([]api.PluginWeight{{Name: PluginBinPack, Weight: 1}}, common...)},
+	}
+}
+
+// Profiles returns the built-in profiles overlaid with the configured ones.
+func Profiles(cfg api.ClusterConfig) map[string]api.SchedulerProfile {
+	out := map[string]api.SchedulerProfile{}
+	for _, p := range append(BuiltinProfiles(), cfg.SchedulerProfiles...) {
+		out[p.Name] = p
+	}
+	return out
+}
+
+// ValidateProfiles checks configured profiles before they are stored.
+func ValidateProfiles(ps []api.SchedulerProfile) error {
+	seen := map[string]bool{}
+	for _, p := range ps {
+		if p.Name == "" {
+			return fmt.Errorf("scheduler profile name required")
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate scheduler profile %q", p.Name)
+		}
+		seen[p.Name] = true
+		for _, f := range p.Filters {
+			if _, ok := filterPlugins[f]; !ok {
+				return fmt.Errorf("profile %s: unknown filter plugin %q", p.Name, f)
+			}
+		}
+		for _, s := range p.Scores {
+			if _, ok := scorePlugins[s.Name]; !ok {
+				return fmt.Errorf("profile %s: unknown score plugin %q", p.Name, s.Name)
+			}
+			if s.Weight < 0 {
+				return fmt.Errorf("profile %s: negative weight for %s", p.Name, s.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// NodeResult is how one node fared in a scheduling attempt.
+type NodeResult struct {
+	NodeID   string `json:"nodeId"`
+	Feasible bool   `json:"feasible"`
+	// Reasons lists why filters rejected the node, as "plugin: reason".
+	Reasons []string `json:"reasons,omitempty"`
+	// Score is the weighted sum of Scores, which holds each plugin's weighted score.
+	Score  int64            `json:"score"`
+	Scores map[string]int64 `json:"scores,omitempty"`
+}
+
+// Result is the outcome of a scheduling attempt.
+type Result struct {
+	Profile string `json:"profile"`
+	// NodeID is the chosen node; empty when no node fits.
+	NodeID string `json:"nodeId,omitempty"`
+	// Nodes holds feasible nodes best first, then the rejected ones.
+	Nodes []NodeResult `json:"nodes"`
+}
+
+// profile resolves the VM's profile to its filter and score plugins.
+func profile(state api.ClusterState, vm api.VM) (string, []string, []api.PluginWeight, error) {
+	name := vm.Policy.Profile
+	if name == "" {
+		name = DefaultProfile
+	}
+	prof, ok := Profiles(state.Config)[name]
+	if !ok {
+		return name, nil, nil, fmt.Errorf("unknown scheduler profile %q", name)
+	}
+	filters := prof.Filters
+	if len(filters) == 0 {
+		filters = filterOrder
+	}
+	scores := prof.Scores
+	if len(scores) == 0 {
+		scores = BuiltinProfiles()[0].Scores
+	}
+	return name, filters, scores, nil
+}
+
+// filterNode runs the filters against one node and returns why they reject it.
+func filterNode(state *api.ClusterState, vm api.VM, n api.Node, filters []string) ([]string, error) {
+	var reasons []string
+	for _, f := range filters {
+		p, ok := filterPlugins[f]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter plugin %q", f)
+		}
+		if why := p.Filter(state, vm, n); why != "" {
+			reasons = append(reasons, f+": "+why)
+		}
+	}
+	return reasons, nil
+}
+
+// CheckBinding re-checks a placement against the given state with the Capacity and
+// Taints filters. It returns why n no longer fits vm, or nil.
+func CheckBinding(state *api.ClusterState, vm api.VM, n api.Node) []string {
+	reasons, _ := filterNode(state, vm, n, []string{PluginCapacity, PluginTaints})
+	return reasons
+}
+
+// Schedule runs the VM's profile against every node in the state.
+func Schedule(state api.ClusterState, vm api.VM) (Result, error) {
+	name, filters, scores, err := profile(state, vm)
+	res := Result{Profile: name}
+	if err != nil {
+		return res, err
+	}
+
+	ids := make([]string, 0, len(state.Nodes))
+	for id := range state.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var feasible []api.Node
+	var rejected []NodeResult
+	for _, id := range ids {
+		n := state.Nodes[id]
+		nr := NodeResult{NodeID: id}
+		if nr.Reasons, err = filterNode(&state, vm, n, filters); err != nil {
+			return res, fmt.Errorf("profile %s: %w", name, err)
+		}
+		if len(nr.Reasons) > 0 {
+			rejected = append(rejected, nr)
+			continue
+		}
+		feasible = append(feasible, n)
+	}
+
+	scored := make([]NodeResult, len(feasible))
+	for i, n := range feasible {
+		scored[i] = NodeResult{NodeID: n.ID, Feasible: true, Scores: map[string]int64{}}
+	}
+	if len(feasible) > 0 {
+		for _, w := range scores {
+			p, ok := scorePlugins[w.Name]
+			if !ok {
+				return res, fmt.Errorf("profile %s: unknown score plugin %q", name, w.Name)
+			}
+			for i, s := range p.Score(&state, vm, feasible) {
+				scored[i].Scores[w.Name] = s * int64(w.Weight)
+				scored[i].Score += s * int64(w.Weight)
+			}
+		}
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+	res.Nodes = append(scored, rejected...)
+	if len(scored) > 0 {
+		res.NodeID = scored[0].NodeID
+	}
+	return res, nil
+}
@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func TestScheduleExplainsRejectedNodes(t *testing.T) {
+	st := api.ClusterState{Nodes: map[string]api.Node{
+		"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}, Allocated: api.Resources{CPU: 900}},
+		"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}, Unschedulable: true},
+		"n3": {ID: "n3", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}, Labels: map[string]string{"net": "a"}},
+		"n4": {ID: "n4", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}},
+	},
+		Volumes:  map[string]api.Volume{"vol1": {ID: "vol1", Node: "n3"}},
+		Networks: map[string]api.Network{"lan": {ID: "lan", NodeSelector: map[string]string{"net": "a"}}},
+	}
+	vm := api.VM{ID: "vm1", Resources: api.Resources{CPU: 500, Memory: 100}, Volumes: []string{"vol1"}, Networks: []string{"lan"}}
+	res, err := Schedule(st, vm)
+	if err != nil || res.NodeID != "n3" || res.Profile != DefaultProfile {
+		t.Fatalf("want n3, got %+v (%v)", res, err)
+	}
+	reasons := map[string]string{}
+	for _, nr := range res.Nodes {
+		reasons[nr.NodeID] = strings.Join(nr.Reasons, "; ")
+	}
+	for id, want := range map[string]string{
+		"n1": "Capacity: insufficient cpu 100/500 free",
+		"n2": "NodeSchedulable: node is cordoned",
+		"n4": "NetworkReachability: network lan does not reach the node",
+	} {
+		if !strings.Contains(reasons[id], want) {
+			t.Errorf("%s: want reason %q, got %q", id, want, reasons[id])
+		}
+	}
+	if !strings.Contains(reasons["n4"], "VolumeLocality: volume vol1 is on node n3") {
+		t.Errorf("n4 should list every failing filter, got %q", reasons["n4"])
+	}
+}
+
+func TestScheduleProfiles(t *testing.T) {
+	st := api.ClusterState{Nodes: map[string]api.Node{
+		"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}, Allocated: api.Resources{CPU: 3000, Memory: 3000}},
+		"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}, Allocated: api.Resources{CPU: 1000, Memory: 1000}},
+	}}
+	vm := api.VM{ID: "vm1", Resources: api.Resources{CPU: 500, Memory: 200}}
+	if id, _ := ChooseNode(st, vm); id != "n2" {
+		t.Fatalf("default profile should spread to n2, got %s", id)
+	}
+	vm.Policy.Profile = "binpack"
+	if id, _ := ChooseNode(st, vm); id != "n1" {
+		t.Fatalf("binpack profile should fill n1, got %s", id)
+	}
+
+	// a configured profile that only checks capacity ignores cordons
+	st.Nodes["n1"] = api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}, Unschedulable: true}
+	st.Config.SchedulerProfiles = []api.SchedulerProfile{{Name: "loose", Filters: []string{PluginCapacity}, Scores: []api.PluginWeight{{Name: PluginSpread, Weight: 1}}}}
+	vm.Policy.Profile = "loose"
+	res, err := Schedule(st, vm)
+	if err != nil || res.NodeID != "n1" || res.Nodes[0].Scores[PluginSpread] != res.Nodes[0].Score {
+		t.Fatalf("unexpected result %+v (%v)", res, err)
+	}
+	vm.Policy.Profile = "missing"
+	if _, ok := ChooseNode(st, vm); ok {
+		t.Fatal("unknown profile must not place the vm")
+	}
+}
+
+func TestCapacityHonoursReservationAndOvercommit(t *testing.T) {
+	st := api.ClusterState{Nodes: map[string]api.Node{
+		"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 8192, Disk: 100}, Allocated: api.Resources{CPU: 3000, Memory: 1024},
+			Reserved: api.Resources{CPU: 1000, Memory: 1024, Disk: 10}},
+	}}
+	vm := api.VM{ID: "vm1", Resources: api.Resources{CPU: 2000, Memory: 1024, Disk: 50}}
+	res, _ := Schedule(st, vm)
+	if res.NodeID != "" || !strings.Contains(res.Nodes[0].Reasons[0], "cpu 0/2000 free") {
+		t.Fatalf("reserved cpu must not be handed out: %+v", res)
+	}
+
+	// 4:1 vCPU across the cluster, the node overrides memory
+	st.Config.Overcommit = api.Overcommit{CPU: 4, Memory: 1.5}
+	if id, _ := ChooseNode(st, vm); id != "n1" {
+		t.Fatalf("overcommitted cpu should fit, got %q", id)
+	}
+	n := st.Nodes["n1"]
+	n.Overcommit = &api.Overcommit{Memory: 1}
+	if a := n.Allocatable(st.Config.Overcommit); a.CPU != 12000 || a.Memory != 7168 || a.Disk != 90 {
+		t.Fatalf("unexpected allocatable %+v", a)
+	}
+
+	vm.Resources.Disk = 95
+	if id, ok := ChooseNode(st, vm); ok {
+		t.Fatalf("disk is never overcommitted, got %s", id)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	for _, ps := range [][]api.SchedulerProfile{
+		{{Name: ""}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", Filters: []string{"Nope"}}},
+		{{Name: "a", Scores: []api.PluginWeight{{Name: PluginSpread, Weight: -1}}}},
+	} {
+		if err := ValidateProfiles(ps); err == nil {
+			t.Errorf("%+v accepted", ps)
+		}
+	}
+	if err := ValidateProfiles([]api.SchedulerProfile{{Name: "a", Filters: []string{PluginCapacity}, Scores: []api.PluginWeight{{Name: PluginBalancedResources, Weight: 2}}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulateMovesPlacedVMAndSummarizes(t *testing.T) {
+	st := api.ClusterState{
+		Nodes: map[string]api.Node{
+			"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}, Allocated: api.Resources{CPU: 800, Memory: 512}},
+			"n2": {ID: "n2", Status: "Failed", Capacity: api.Resources{CPU: 1000, Memory: 1024}},
+		},
+		VMs: map[string]api.VM{"a": {ID: "a", NodeID: "n1", Resources: api.Resources{CPU: 800, Memory: 512}}},
+	}
+	// the vm's own allocation does not count against its node
+	if res, err := Simulate(st, SimulateRequest{VMID: "a"}); err != nil || res.NodeID != "n1" {
+		t.Fatalf("want n1, got %+v (%v)", res, err)
+	}
+	res, err := Simulate(st, SimulateRequest{VM: &api.VM{ID: "b", Resources: api.Resources{CPU: 500}}})
+	if err != nil || res.NodeID != "" {
+		t.Fatalf("want no fit, got %+v (%v)", res, err)
+	}
+	if got, want := res.Summary(), "0/2 nodes are available: 1 Capacity, 1 NodeSchedulable"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+	if _, err := Simulate(st, SimulateRequest{VMID: "x"}); !errors.Is(err, ErrUnknownVM) {
+		t.Fatalf("want ErrUnknownVM got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+
+	"clustering/pkg/api"
+)
+
+// HAStatus is the cluster's failover headroom under its HA admission control policy.
+type HAStatus struct {
+	Policy       string `json:"policy"`
+	HostFailures int    `json:"hostFailures,omitempty"`
+	// Capacity is the allocatable CPU and memory of the schedulable nodes, Reserved the
+	// part the policy keeps free for failover and Demand what the VMs that should run
+	// request. Headroom is what is left for new VMs; it is negative when the cluster
+	// cannot honour the policy.
+	Capacity  api.Resources `json:"capacity"`
+	Reserved  api.Resources `json:"reserved"`
+	Demand    api.Resources `json:"demand"`
+	Headroom  api.Resources `json:"headroom"`
+	Satisfied bool          `json:"satisfied"`
+	// TolerableFailures is how many of the largest nodes may fail with enough capacity
+	// left for every VM.
+	TolerableFailures int `json:"tolerableFailures"`
+	// Scenarios simulate failover after each node fails or, when more than one host
+	// failure must be tolerated, after the nodes running the most fail together.
+	Scenarios []FailureScenario `json:"scenarios"`
+	// AtRisk lists the VMs that fail to restart in at least one scenario.
+	AtRisk []string `json:"atRisk"`
+}
+
+// FailureScenario is the outcome of a simulated failover.
+type FailureScenario struct {
+	Failed        []string `json:"failed"`
+	Restarted     int      `json:"restarted"`
+	Unrestartable []string `json:"unrestartable"`
+}
+
+// haAdmission is the HAAdmission filter plugin: it rejects VMs that would use capacity
+// the HA policy reserves for failover. VMs that are already placed pass, so failover,
+// drains and migrations may always use the reserve.
+type haAdmission struct{}
+
+func (haAdmission) Name() string { return PluginHAAdmission }
+func (haAdmission) Filter(state *api.ClusterState, vm api.VM, _ api.Node) string {
+	if vm.NodeID != "" {
+		return ""
+	}
+	return HAAdmit(*state, vm)
+}
+
+// haDemand reports whether the VM counts against failover capacity: every VM except
+// the stopped ones, placed or not.
+func haDemand(vm api.VM) bool {
+	return vm.DesiredState != "Stopped"
+}
+
+// haCapacity sums the allocatable resources of the schedulable nodes, the part the
+// policy reserves and the demand of every VM but skip.
+func haCapacity(state api.ClusterState, skip string) (capacity, reserved, demand api.Resources) {
+	cfg := state.Config.HA.WithDefaults()
+	var cpus, mems []int
+	for _, n := range state.Nodes {
+		if (nodeSchedulable{}).Filter(nil, api.VM{}, n) != "" {
+			continue
+		}
+		a := n.Allocatable(state.Config.Overcommit)
+		capacity.CPU += a.CPU
+		capacity.Memory += a.Memory
+		cpus = append(cpus, a.CPU)
+		mems = append(mems, a.Memory)
+	}
+	switch cfg.Policy {
+	case api.HAPolicyHostFailures:
+		reserved = api.Resources{CPU: largest(cpus, cfg.HostFailures), Memory: largest(mems, cfg.HostFailures)}
+	case api.HAPolicyReserve:
+		reserved = api.Resources{CPU: capacity.CPU * cfg.CPUPercent / 100, Memory: capacity.Memory * cfg.MemoryPercent / 100}
+	}
+	for id, vm := range state.VMs {
+		if id != skip && haDemand(vm) {
+			demand.CPU += vm.Resources.CPU
+			demand.Memory += vm.Resources.Memory
+		}
+	}
+	return capacity, reserved, demand
+}
+
+// largest sums the k largest values.
+func largest(vals []int, k int) int {
+	sorted := append([]int(nil), vals...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	sum := 0
+	for _, v := range sorted[:min(k, len(sorted))] {
+		sum += v
+	}
+	return sum
+}
+
+// describeHA names the policy for messages.
+func describeHA(cfg api.HAConfig) string {
+	if cfg.Policy == api.HAPolicyReserve {
+		return fmt.Sprintf("reserving %d%% cpu and %d%% memory", cfg.CPUPercent, cfg.MemoryPercent)
+	}
+	return fmt.Sprintf("tolerating %d host failure(s)", cfg.HostFailures)
+}
+
+// HAAdmit checks a VM that is created or started against the HA admission control
+// policy and returns why it is refused, or "". Stopped VMs are always admitted.
+func HAAdmit(state api.ClusterState, vm api.VM) string {
+	cfg := state.Config.HA.WithDefaults()
+	if cfg.Policy == "" || !haDemand(vm) {
+		return ""
+	}
+	capacity, reserved, demand := haCapacity(state, vm.ID)
+	free := api.Resources{CPU: capacity.CPU - reserved.CPU - demand.CPU, Memory: capacity.Memory - reserved.Memory - demand.Memory}
+	if vm.Resources.CPU <= free.CPU && vm.Resources.Memory <= free.Memory {
+		return ""
+	}
+	return fmt.Sprintf("ha admission control (%s): vm needs cpu %d, memory %d MiB but only cpu %d, memory %d MiB are not reserved for failover",
+		describeHA(cfg), vm.Resources.CPU, vm.Resources.Memory, max(free.CPU, 0), max(free.Memory, 0))
+}
+
+// HAAdmitStart is HAAdmit for a create or update: only new VMs and VMs that leave the
+// Stopped state are checked, so running VMs can always be updated.
+func HAAdmitStart(state api.ClusterState, vm api.VM) string {
+	if prev, ok := state.VMs[vm.ID]; ok && haDemand(prev) {
+		return ""
+	}
+	return HAAdmit(state, vm)
+}
+
+// HAShortfall returns why the cluster does not honour its HA policy, or "".
+func HAShortfall(state api.ClusterState) string {
+	cfg := state.Config.HA.WithDefaults()
+	if cfg.Policy == "" {
+		return ""
+	}
+	capacity, reserved, demand := haCapacity(state, "")
+	if demand.CPU+reserved.CPU <= capacity.CPU && demand.Memory+reserved.Memory <= capacity.Memory {
+		return ""
+	}
+	return fmt.Sprintf("ha policy (%s) needs cpu %d, memory %d MiB but the cluster has cpu %d, memory %d MiB",
+		describeHA(cfg), demand.CPU+reserved.CPU, demand.Memory+reserved.Memory, capacity.CPU, capacity.Memory)
+}
+
+// HA reports the cluster's failover headroom. Besides the capacity arithmetic the
+// admission policy uses, it simulates node failures with the scheduler, restarting the
+// failed nodes' VMs in failover order, and lists the VMs that would find no node.
+func HA(state api.ClusterState) HAStatus {
+	cfg := state.Config.HA.WithDefaults()
+	st := HAStatus{Policy: cfg.Policy, HostFailures: cfg.HostFailures, Scenarios: []FailureScenario{}, AtRisk: []string{}}
+	st.Capacity, st.Reserved, st.Demand = haCapacity(state, "")
+	st.Headroom = api.Resources{CPU: st.Capacity.CPU - st.Reserved.CPU - st.Demand.CPU, Memory: st.Capacity.Memory - st.Reserved.Memory - st.Demand.Memory}
+	st.Satisfied = st.Headroom.CPU >= 0 && st.Headroom.Memory >= 0
+
+	var cpus, mems []int
+	hosted := map[string]api.Resources{}
+	for id, n := range state.Nodes {
+		if (nodeSchedulable{}).Filter(nil, api.VM{}, n) == "" {
+			a := n.Allocatable(state.Config.Overcommit)
+			cpus, mems = append(cpus, a.CPU), append(mems, a.Memory)
+		}
+		if n.Status == "Alive" && n.Standby == nil {
+			hosted[id] = api.Resources{}
+		}
+	}
+	for k := 1; k <= len(cpus); k++ {
+		if st.Demand.CPU > st.Capacity.CPU-largest(cpus, k) || st.Demand.Memory > st.Capacity.Memory-largest(mems, k) {
+			break
+		}
+		st.TolerableFailures = k
+	}
+
+	for _, vm := range state.VMs {
+		if r, ok := hosted[vm.NodeID]; ok && haDemand(vm) {
+			r.CPU += vm.Resources.CPU
+			r.Memory += vm.Resources.Memory
+			hosted[vm.NodeID] = r
+		}
+	}
+	ids := make([]string, 0, len(hosted))
+	for id := range hosted {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var scenarios [][]string
+	if failures := max(cfg.HostFailures, 1); failures == 1 {
+		for _, id := range ids {
+			scenarios = append(scenarios, []string{id})
+		}
+	} else {
+		sort.SliceStable(ids, func(i, j int) bool {
+			a, b := hosted[ids[i]], hosted[ids[j]]
+			return a.CPU+a.Memory > b.CPU+b.Memory
+		})
+		scenarios = append(scenarios, ids[:min(failures, len(ids))])
+	}
+	atRisk := map[string]bool{}
+	for _, failed := range scenarios {
+		sc := failover(state, failed)
+		for _, id := range sc.Unrestartable {
+			atRisk[id] = true
+		}
+		st.Scenarios = append(st.Scenarios, sc)
+	}
+	for id := range atRisk {
+		st.AtRisk = append(st.AtRisk, id)
+	}
+	sort.Strings(st.AtRisk)
+	return st
+}
+
+// failover simulates restarting the VMs of the failed nodes on the others.
+func failover(state api.ClusterState, failed []string) FailureScenario {
+	sc := FailureScenario{Failed: append([]string(nil), failed...), Unrestartable: []string{}}
+	sort.Strings(sc.Failed)
+	down := map[string]bool{}
+	for _, id := range failed {
+		down[id] = true
+	}
+	var vms []api.VM
+	for _, vm := range state.VMs {
+		if down[vm.NodeID] && haDemand(vm) {
+			vms = append(vms, vm)
+		}
+	}
+	for _, vm := range api.StartOrder(vms) {
+		target, ok := ChooseNodeExcluding(state, vm, failed...)
+		if !ok {
+			sc.Unrestartable = append(sc.Unrestartable, vm.ID)
+			continue
+		}
+		state = withPlacement(state, []api.VM{vm}, target)
+		sc.Restarted++
+	}
+	return sc
+}
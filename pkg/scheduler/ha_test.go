@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func haCluster() api.ClusterState {
+	node := func(id string, cpu int) api.Node {
+		return api.Node{ID: id, Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 8192}, Allocated: api.Resources{CPU: cpu, Memory: cpu * 2}}
+	}
+	vm := func(id, node string, cpu int) api.VM {
+		return api.VM{ID: id, NodeID: node, Phase: "Running", Resources: api.Resources{CPU: cpu, Memory: cpu * 2}}
+	}
+	return api.ClusterState{
+		Config: api.ClusterConfig{HA: api.HAConfig{Policy: api.HAPolicyHostFailures}},
+		Nodes:  map[string]api.Node{"n1": node("n1", 2000), "n2": node("n2", 2000), "n3": node("n3", 1000)},
+		VMs:    map[string]api.VM{"a": vm("a", "n1", 2000), "b": vm("b", "n2", 2000), "c": vm("c", "n3", 1000)},
+	}
+}
+
+func TestHAAdmitKeepsFailoverCapacity(t *testing.T) {
+	st := haCluster()
+	// 12000 cpu, one 4000 node reserved, 5000 in use
+	if why := HAAdmit(st, api.VM{ID: "new", Resources: api.Resources{CPU: 3000, Memory: 4096}}); why != "" {
+		t.Fatalf("want admitted, got %q", why)
+	}
+	big := api.VM{ID: "big", Resources: api.Resources{CPU: 3500, Memory: 4096}}
+	if why := HAAdmit(st, big); !strings.Contains(why, "1 host failure") {
+		t.Fatalf("want refusal, got %q", why)
+	}
+	big.DesiredState = "Stopped"
+	if why := HAAdmitStart(st, big); why != "" {
+		t.Fatalf("stopped vms need no capacity, got %q", why)
+	}
+	// running VMs were admitted already
+	a := st.VMs["a"]
+	a.Resources.CPU = 5000
+	if why := HAAdmitStart(st, a); why != "" {
+		t.Fatalf("want update admitted, got %q", why)
+	}
+
+	// the scheduler refuses to place it, but failover may use the reserve
+	big.DesiredState = ""
+	if res, err := Schedule(st, big); err != nil || res.NodeID != "" {
+		t.Fatalf("want no node for big, got %+v, %v", res, err)
+	}
+	moved := st.VMs["c"]
+	if nid, ok := ChooseNodeExcluding(st, moved, "n3"); !ok || nid == "" {
+		t.Fatalf("want c restarted elsewhere")
+	}
+
+	st.Config.HA = api.HAConfig{Policy: api.HAPolicyReserve, CPUPercent: 60, MemoryPercent: 10}
+	if HAShortfall(st) == "" {
+		t.Fatalf("5000 cpu in use does not fit 40%% of 12000")
+	}
+	st.Config.HA = api.HAConfig{}
+	if HAAdmit(st, big) != "" || HAShortfall(st) != "" {
+		t.Fatalf("admission control is off without a policy")
+	}
+}
+
+func TestHAStatusListsUnrestartableVMs(t *testing.T) {
+	st := haCluster()
+	hs := HA(st)
+	if !hs.Satisfied || hs.TolerableFailures != 1 || len(hs.Scenarios) != 3 || len(hs.AtRisk) != 0 {
+		t.Fatalf("want every single failure covered, got %+v", hs)
+	}
+	if hs.Headroom != (api.Resources{CPU: 3000, Memory: 6384}) {
+		t.Fatalf("want 3000 cpu, 6384 MiB headroom, got %+v", hs.Headroom)
+	}
+
+	// c no longer fits the 2000 left on n1 or n2 when n3 fails
+	c := st.VMs["c"]
+	c.Resources = api.Resources{CPU: 3000, Memory: 6000}
+	st.VMs["c"] = c
+	n3 := st.Nodes["n3"]
+	n3.Allocated = c.Resources
+	st.Nodes["n3"] = n3
+	hs = HA(st)
+	if !hs.Satisfied || len(hs.AtRisk) != 1 || hs.AtRisk[0] != "c" {
+		t.Fatalf("want c at risk, got %+v", hs)
+	}
+	for _, sc := range hs.Scenarios {
+		if want := sc.Failed[0] == "n3"; want != (len(sc.Unrestartable) == 1) {
+			t.Fatalf("unexpected scenario %+v", sc)
+		}
+	}
+
+	// two failures: the two busiest nodes go down together
+	st.Config.HA.HostFailures = 2
+	hs = HA(st)
+	if hs.Satisfied || len(hs.Scenarios) != 1 || len(hs.Scenarios[0].Failed) != 2 || len(hs.AtRisk) == 0 {
+		t.Fatalf("want a shortfall for two failures, got %+v", hs)
+	}
+}
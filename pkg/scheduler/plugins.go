@@ -0,0 +1,332 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"clustering/pkg/api"
+)
+
+// Built-in plugin names.
+const (
+	PluginNodeSchedulable     = "NodeSchedulable"
+	PluginCapacity            = "Capacity"
+	PluginTaints              = "Taints"
+	PluginAffinity            = "Affinity"
+	PluginVolumeLocality      = "VolumeLocality"
+	PluginNetworkReachability = "NetworkReachability"
+	PluginVMAffinity          = "VMAffinity"
+	PluginTopologySpread      = "TopologySpread"
+	PluginHAAdmission         = "HAAdmission"
+
+	PluginSpread            = "Spread"
+	PluginBinPack           = "BinPack"
+	PluginBalancedResources = "BalancedResources"
+	PluginCoordinates       = "Coordinates"
+	PluginTaintPreference   = "TaintPreference"
+)
+
+func init() {
+	for _, p := range []FilterPlugin{nodeSchedulable{}, capacity{}, taints{}, affinity{}, volumeLocality{}, networkReachability{}, vmAffinity{}, topologySpread{}, haAdmission{}} {
+		RegisterFilter(p)
+	}
+	for _, p := range []ScorePlugin{spread{}, binPack{}, balancedResources{}, coordinates{}, taintPreference{}, affinity{}, vmAffinity{}, topologySpread{}} {
+		RegisterScore(p)
+	}
+}
+
+// nodeSchedulable keeps VMs off nodes that are not alive, cordoned or in standby.
+type nodeSchedulable struct{}
+
+func (nodeSchedulable) Name() string { return PluginNodeSchedulable }
+func (nodeSchedulable) Filter(_ *api.ClusterState, _ api.VM, n api.Node) string {
+	switch {
+	case n.Standby != nil:
+		return "node is in standby"
+	case n.Status != "Alive":
+		return "node is " + strings.ToLower(n.Status)
+	case n.Maintenance:
+		return "node is in maintenance"
+	case n.Unschedulable:
+		return "node is cordoned"
+	}
+	return ""
+}
+
+// capacity checks the VM's requested CPU, memory and (when the node reports it) disk
+// against what is left of the node's allocatable resources. Room freed by preemption is
+// kept for the preempting VM: unplaced VMs nominated to the node count against it unless
+// they are less important than the VM.
+type capacity struct{}
+
+func (capacity) Name() string { return PluginCapacity }
+func (capacity) Filter(state *api.ClusterState, vm api.VM, n api.Node) string {
+	alloc := n.Allocatable(state.Config.Overcommit)
+	used := n.Allocated
+	held := nominated(state, vm, n.ID)
+	used.CPU += held.CPU
+	used.Memory += held.Memory
+	used.Disk += held.Disk
+	var short []string
+	if used.CPU+vm.Resources.CPU > alloc.CPU {
+		short = append(short, fmt.Sprintf("cpu %d/%d free", max(alloc.CPU-used.CPU, 0), vm.Resources.CPU))
+	}
+	if used.Memory+vm.Resources.Memory > alloc.Memory {
+		short = append(short, fmt.Sprintf("memory %d/%d MiB free", max(alloc.Memory-used.Memory, 0), vm.Resources.Memory))
+	}
+	if n.Capacity.Disk > 0 && used.Disk+vm.Resources.Disk > alloc.Disk {
+		short = append(short, fmt.Sprintf("disk %d/%d GiB free", max(alloc.Disk-used.Disk, 0), vm.Resources.Disk))
+	}
+	if len(short) == 0 {
+		return ""
+	}
+	if held != (api.Resources{}) {
+		short = append(short, "room held for preempting vms")
+	}
+	return "insufficient " + strings.Join(short, ", ")
+}
+
+// nominated sums the requests of the unplaced VMs nominated to the node that are at
+// least as important as vm.
+func nominated(state *api.ClusterState, vm api.VM, nodeID string) api.Resources {
+	var held api.Resources
+	prio := state.Priority(vm)
+	for _, v := range state.VMs {
+		if v.NominatedNode != nodeID || v.NodeID != "" || v.ID == vm.ID || state.Priority(v) < prio {
+			continue
+		}
+		held.CPU += v.Resources.CPU
+		held.Memory += v.Resources.Memory
+		held.Disk += v.Resources.Disk
+	}
+	return held
+}
+
+// taints rejects nodes with NoSchedule or NoExecute taints the VM does not tolerate.
+type taints struct{}
+
+func (taints) Name() string { return PluginTaints }
+func (taints) Filter(_ *api.ClusterState, vm api.VM, n api.Node) string {
+	var keys []string
+	for _, t := range api.UntoleratedTaints(n, vm.Policy.Tolerations, api.TaintNoSchedule, api.TaintNoExecute) {
+		keys = append(keys, t.Key+":"+t.Effect)
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return "untolerated taints " + strings.Join(keys, ", ")
+}
+
+// affinity requires every label in the VM's Affinity on the node with the same value and
+// the node to match every required NodeAffinity expression. As a score plugin it rates
+// nodes by the share of preferred NodeAffinity weight they match.
+type affinity struct{}
+
+func (affinity) Name() string { return PluginAffinity }
+func (affinity) Filter(_ *api.ClusterState, vm api.VM, n api.Node) string {
+	var missing []string
+	for k, v := range vm.Policy.Affinity {
+		if n.Labels[k] != v {
+			missing = append(missing, k+"="+v)
+		}
+	}
+	if na := vm.Policy.NodeAffinity; na != nil {
+		for _, r := range na.Required {
+			if !r.Matches(n.Labels) {
+				missing = append(missing, describeRequirement(r))
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	sort.Strings(missing)
+	return "node lacks labels " + strings.Join(missing, ", ")
+}
+
+func (affinity) Score(_ *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	out := make([]int64, len(nodes))
+	na := vm.Policy.NodeAffinity
+	if na == nil || len(na.Preferred) == 0 {
+		return out
+	}
+	total := 0
+	for _, t := range na.Preferred {
+		total += t.Weight
+	}
+	for i, n := range nodes {
+		got := 0
+		for _, t := range na.Preferred {
+			if api.MatchLabels(t.Match, n.Labels) {
+				got += t.Weight
+			}
+		}
+		out[i] = clampScore(float64(got) / float64(total))
+	}
+	return out
+}
+
+// volumeLocality places VMs on the node holding their node-local volumes.
+type volumeLocality struct{}
+
+func (volumeLocality) Name() string { return PluginVolumeLocality }
+func (volumeLocality) Filter(state *api.ClusterState, vm api.VM, n api.Node) string {
+	for _, id := range vm.Volumes {
+		if vol, ok := state.Volumes[id]; ok && vol.Node != "" && vol.Node != n.ID {
+			return "volume " + id + " is on node " + vol.Node
+		}
+	}
+	return ""
+}
+
+// networkReachability requires the VM's networks to reach the node: the network's node
+// selector must match and the node must not report NetworkUnavailable.
+type networkReachability struct{}
+
+func (networkReachability) Name() string { return PluginNetworkReachability }
+func (networkReachability) Filter(state *api.ClusterState, vm api.VM, n api.Node) string {
+	if len(vm.Networks) == 0 {
+		return ""
+	}
+	if c, ok := n.Condition(api.NodeNetworkUnavailable); ok && c.Status == api.ConditionTrue {
+		return "node network unavailable"
+	}
+	for _, id := range vm.Networks {
+		nw, ok := state.Networks[id]
+		if !ok {
+			continue
+		}
+		for k, v := range nw.NodeSelector {
+			if n.Labels[k] != v {
+				return "network " + id + " does not reach the node"
+			}
+		}
+	}
+	return ""
+}
+
+// freeFractions returns the node's unallocated share of its allocatable CPU and memory
+// once the VM is placed.
+func freeFractions(state *api.ClusterState, vm api.VM, n api.Node) (cpu, mem float64) {
+	frac := func(capacity, used int) float64 {
+		if capacity <= 0 {
+			return 0
+		}
+		return float64(capacity-used) / float64(capacity)
+	}
+	alloc := n.Allocatable(state.Config.Overcommit)
+	return frac(alloc.CPU, n.Allocated.CPU+vm.Resources.CPU), frac(alloc.Memory, n.Allocated.Memory+vm.Resources.Memory)
+}
+
+func clampScore(f float64) int64 {
+	s := int64(f * MaxNodeScore)
+	return min(max(s, 0), MaxNodeScore)
+}
+
+// spread prefers the least allocated nodes.
+type spread struct{}
+
+func (spread) Name() string { return PluginSpread }
+func (spread) Score(state *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	out := make([]int64, len(nodes))
+	for i, n := range nodes {
+		cpu, mem := freeFractions(state, vm, n)
+		out[i] = clampScore((cpu + mem) / 2)
+	}
+	return out
+}
+
+// binPack prefers the most allocated nodes, keeping others empty.
+type binPack struct{}
+
+func (binPack) Name() string { return PluginBinPack }
+func (binPack) Score(state *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	out := make([]int64, len(nodes))
+	for i, n := range nodes {
+		cpu, mem := freeFractions(state, vm, n)
+		out[i] = clampScore(1 - (cpu+mem)/2)
+	}
+	return out
+}
+
+// balancedResources prefers nodes whose CPU and memory are allocated evenly.
+type balancedResources struct{}
+
+func (balancedResources) Name() string { return PluginBalancedResources }
+func (balancedResources) Score(state *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	out := make([]int64, len(nodes))
+	for i, n := range nodes {
+		cpu, mem := freeFractions(state, vm, n)
+		d := cpu - mem
+		if d < 0 {
+			d = -d
+		}
+		out[i] = clampScore(1 - d)
+	}
+	return out
+}
+
+// coordinates prefers nodes with the lowest estimated RTT to the VM's NearNode or
+// NearVolume; nodes without a coordinate score 0.
+type coordinates struct{}
+
+func (coordinates) Name() string { return PluginCoordinates }
+func (coordinates) Score(state *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	out := make([]int64, len(nodes))
+	target := nearCoordinate(*state, vm.Policy)
+	if target == nil {
+		return out
+	}
+	rtts := make([]time.Duration, len(nodes))
+	known := make([]bool, len(nodes))
+	var lo, hi time.Duration = -1, 0
+	for i, n := range nodes {
+		if rtts[i], known[i] = n.Coordinate.RTT(target); !known[i] {
+			continue
+		}
+		if lo < 0 || rtts[i] < lo {
+			lo = rtts[i]
+		}
+		if rtts[i] > hi {
+			hi = rtts[i]
+		}
+	}
+	for i := range nodes {
+		switch {
+		case !known[i]:
+		case hi == lo:
+			out[i] = MaxNodeScore
+		default:
+			out[i] = int64(MaxNodeScore * (hi - rtts[i]) / (hi - lo))
+		}
+	}
+	return out
+}
+
+// nearCoordinate resolves the coordinate a VM wants to be placed close to, if any.
+func nearCoordinate(state api.ClusterState, p api.VMSchedulingPolicy) *api.Coordinate {
+	nodeID := p.NearNode
+	if nodeID == "" && p.NearVolume != "" {
+		nodeID = state.Volumes[p.NearVolume].Node
+	}
+	if nodeID == "" {
+		return nil
+	}
+	return state.Nodes[nodeID].Coordinate
+}
+
+// taintPreference avoids nodes with PreferNoSchedule taints the VM does not tolerate.
+type taintPreference struct{}
+
+func (taintPreference) Name() string { return PluginTaintPreference }
+func (taintPreference) Score(_ *api.ClusterState, vm api.VM, nodes []api.Node) []int64 {
+	out := make([]int64, len(nodes))
+	for i, n := range nodes {
+		if len(api.UntoleratedTaints(n, vm.Policy.Tolerations, api.TaintPreferNoSchedule)) == 0 {
+			out[i] = MaxNodeScore
+		}
+	}
+	return out
+}
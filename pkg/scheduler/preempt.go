@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+
+	"clustering/pkg/api"
+)
+
+// Preempt plans the eviction that lets a VM no node fits run: on every node it evicts the
+// VMs of lower priority, checks that the VM then passes the profile's filters and spares
+// again the most important victims it can do without. The node whose most important
+// victim has the lowest priority wins, then the one with fewer victims. NodeID is empty
+// when no eviction makes room.
+func Preempt(state api.ClusterState, vm api.VM) (api.Preemption, error) {
+	name, filters, _, err := profile(state, vm)
+	if err != nil {
+		return api.Preemption{}, err
+	}
+	prio := state.Priority(vm)
+	ids := make([]string, 0, len(state.Nodes))
+	for id := range state.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var best api.Preemption
+	bestTop := 0
+	for _, id := range ids {
+		var cands []api.VM
+		for _, v := range state.VMs {
+			if v.NodeID == id && v.ID != vm.ID && state.Priority(v) < prio {
+				cands = append(cands, v)
+			}
+		}
+		if len(cands) == 0 {
+			continue
+		}
+		sort.Slice(cands, func(i, j int) bool {
+			if pi, pj := state.Priority(cands[i]), state.Priority(cands[j]); pi != pj {
+				return pi < pj
+			}
+			return cands[i].ID < cands[j].ID
+		})
+		trial := withPlacement(state, cands, "")
+		reasons, err := filterNode(&trial, vm, trial.Nodes[id], filters)
+		if err != nil {
+			return api.Preemption{}, fmt.Errorf("profile %s: %w", name, err)
+		}
+		if len(reasons) > 0 {
+			continue
+		}
+		// spare the most important candidates first; victims end up highest priority first
+		var victims []string
+		top := 0
+		for i := len(cands) - 1; i >= 0; i-- {
+			spared := withPlacement(trial, cands[i:i+1], id)
+			if reasons, _ := filterNode(&spared, vm, spared.Nodes[id], filters); len(reasons) == 0 {
+				trial = spared
+				continue
+			}
+			if len(victims) == 0 {
+				top = state.Priority(cands[i])
+			}
+			victims = append(victims, cands[i].ID)
+		}
+		if len(victims) == 0 {
+			continue
+		}
+		if best.NodeID == "" || top < bestTop || (top == bestTop && len(victims) < len(best.Victims)) {
+			best, bestTop = api.Preemption{VMID: vm.ID, NodeID: id, Victims: victims}, top
+		}
+	}
+	return best, nil
+}
+
+// withPlacement returns a copy of state with vms assigned to nodeID, or unassigned when
+// it is empty, and node allocations adjusted.
+func withPlacement(state api.ClusterState, vms []api.VM, nodeID string) api.ClusterState {
+	nodes := make(map[string]api.Node, len(state.Nodes))
+	for id, n := range state.Nodes {
+		nodes[id] = n
+	}
+	all := make(map[string]api.VM, len(state.VMs))
+	for id, v := range state.VMs {
+		all[id] = v
+	}
+	for _, v := range vms {
+		v = all[v.ID]
+		if n, ok := nodes[v.NodeID]; ok {
+			n.Allocated.CPU -= v.Resources.CPU
+			n.Allocated.Memory -= v.Resources.Memory
+			n.Allocated.Disk -= v.Resources.Disk
+			nodes[v.NodeID] = n
+		}
+		if n, ok := nodes[nodeID]; ok {
+			n.Allocated.CPU += v.Resources.CPU
+			n.Allocated.Memory += v.Resources.Memory
+			n.Allocated.Disk += v.Resources.Disk
+			nodes[nodeID] = n
+		}
+		v.NodeID = nodeID
+		all[v.ID] = v
+	}
+	state.Nodes, state.VMs = nodes, all
+	return state
+}
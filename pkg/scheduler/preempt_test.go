@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"slices"
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func TestPreemptPicksMinimalLowestPriorityVictims(t *testing.T) {
+	vm := func(id, node string, cpu, prio int) api.VM {
+		return api.VM{ID: id, NodeID: node, Resources: api.Resources{CPU: cpu, Memory: 100}, Policy: api.VMSchedulingPolicy{Priority: prio}}
+	}
+	st := api.ClusterState{
+		Nodes: map[string]api.Node{
+			"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}, Allocated: api.Resources{CPU: 4000, Memory: 300}},
+			"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}, Allocated: api.Resources{CPU: 4000, Memory: 300}},
+		},
+		VMs: map[string]api.VM{
+			// n1: evicting the two priority-1 VMs is enough, the priority-5 one is spared
+			"a": vm("a", "n1", 1000, 1), "b": vm("b", "n1", 1000, 1), "c": vm("c", "n1", 2000, 5),
+			// n2 would need a priority-50 victim
+			"d": vm("d", "n2", 3000, 50), "e": vm("e", "n2", 1000, 200),
+		},
+		PriorityClasses: map[string]api.PriorityClass{"critical": {Name: "critical", Value: 100}},
+	}
+	high := vm("high", "", 2000, 0)
+	high.Policy.PriorityClass = "critical"
+	if id, ok := ChooseNode(st, high); ok {
+		t.Fatalf("nothing should fit without preemption, got %s", id)
+	}
+	p, err := Preempt(st, high)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(p.Victims)
+	if p.NodeID != "n1" || !slices.Equal(p.Victims, []string{"a", "b"}) {
+		t.Fatalf("want a and b evicted from n1, got %+v", p)
+	}
+
+	// VMs of equal or higher priority are never victims
+	high.Policy.PriorityClass = ""
+	high.Policy.Priority = 1
+	if p, _ := Preempt(st, high); p.NodeID != "" {
+		t.Fatalf("priority 1 cannot preempt, got %+v", p)
+	}
+
+	st.PriorityClasses["critical"] = api.PriorityClass{Name: "critical", Value: 100, PreemptionPolicy: api.PreemptNever}
+	high.Policy.PriorityClass = "critical"
+	if st.CanPreempt(high) || st.Priority(high) != 100 {
+		t.Fatal("a Never class keeps its priority but must not preempt")
+	}
+}
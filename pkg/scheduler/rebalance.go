@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	"clustering/pkg/api"
+)
+
+// Utilization is a node's CPU and memory load as a fraction of its capacity.
+type Utilization struct {
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+}
+
+// Migration is a live migration the rebalancer recommends.
+type Migration struct {
+	VMID string `json:"vmId"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Imbalance is what is left once this migration and the ones before it are done.
+	Imbalance float64 `json:"imbalance"`
+	// Hot is set when the VM leaves an overloaded node; Partial mode applies only these.
+	Hot bool `json:"hot,omitempty"`
+}
+
+// RebalancePlan is the rebalancer's view of the cluster: node utilisation, the imbalance
+// and the migrations that lower it, in the order they should run.
+type RebalancePlan struct {
+	Mode       string                 `json:"mode"`
+	Imbalance  float64                `json:"imbalance"`
+	Threshold  float64                `json:"threshold"`
+	Nodes      map[string]Utilization `json:"nodes"`
+	Migrations []Migration            `json:"migrations"`
+}
+
+// nodeLoad is a node's CPU and memory load next to its capacity.
+type nodeLoad struct {
+	cpu, mem, capCPU, capMem float64
+}
+
+func (l nodeLoad) utilization() Utilization {
+	frac := func(used, capacity float64) float64 {
+		if capacity <= 0 {
+			return 0
+		}
+		return used / capacity
+	}
+	return Utilization{CPU: frac(l.cpu, l.capCPU), Memory: frac(l.mem, l.capMem)}
+}
+
+// NodeLoad is the CPU and memory in use on a node: what its agent reports or, for
+// resources it reports none of, what is allocated on it.
+func NodeLoad(n api.Node) api.Resources {
+	used := func(usage, allocated int) int {
+		if usage > 0 {
+			return usage
+		}
+		return allocated
+	}
+	return api.Resources{CPU: used(n.Usage.CPU, n.Allocated.CPU), Memory: used(n.Usage.Memory, n.Allocated.Memory)}
+}
+
+func loadOf(n api.Node) nodeLoad {
+	l := NodeLoad(n)
+	return nodeLoad{cpu: float64(l.CPU), mem: float64(l.Memory), capCPU: float64(n.Capacity.CPU), capMem: float64(n.Capacity.Memory)}
+}
+
+// vmLoad estimates the VM's share of its node's reported usage from its share of the
+// node's allocation; without reported usage it is what the VM requests.
+func vmLoad(n api.Node, vm api.VM) nodeLoad {
+	share := func(request, usage, allocated int) float64 {
+		if usage > 0 && allocated > 0 {
+			return float64(request) * float64(usage) / float64(allocated)
+		}
+		return float64(request)
+	}
+	return nodeLoad{cpu: share(vm.Resources.CPU, n.Usage.CPU, n.Allocated.CPU), mem: share(vm.Resources.Memory, n.Usage.Memory, n.Allocated.Memory)}
+}
+
+// imbalance is the mean of the standard deviations of node CPU and memory utilisation.
+func imbalance(loads map[string]nodeLoad) float64 {
+	if len(loads) == 0 {
+		return 0
+	}
+	stddev := func(f func(Utilization) float64) float64 {
+		var sum, sq float64
+		for _, l := range loads {
+			u := f(l.utilization())
+			sum += u
+			sq += u * u
+		}
+		mean := sum / float64(len(loads))
+		return math.Sqrt(max(sq/float64(len(loads))-mean*mean, 0))
+	}
+	return (stddev(func(u Utilization) float64 { return u.CPU }) + stddev(func(u Utilization) float64 { return u.Memory })) / 2
+}
+
+// moveLoad returns a copy of loads with vm moved from one node to another.
+func moveLoad(loads map[string]nodeLoad, from, to string, vm nodeLoad) map[string]nodeLoad {
+	out := make(map[string]nodeLoad, len(loads))
+	for id, l := range loads {
+		out[id] = l
+	}
+	f, t := out[from], out[to]
+	f.cpu, f.mem = f.cpu-vm.cpu, f.mem-vm.mem
+	t.cpu, t.mem = t.cpu+vm.cpu, t.mem+vm.mem
+	out[from], out[to] = f, t
+	return out
+}
+
+// rebalanceable reports whether the rebalancer moves VMs off and onto the node: it must
+// be alive, schedulable, not fenced and able to live-migrate.
+func rebalanceable(n api.Node) bool {
+	return nodeSchedulable{}.Filter(nil, api.VM{}, n) == "" && n.Fence == nil && slices.Contains(n.Capabilities, api.CapLiveMigrate)
+}
+
+// Rebalance plans live migrations that even out node utilisation. While the imbalance is
+// above the configured threshold it greedily picks the running VM and target node that
+// lower it most, at least by MinImprovement, and at most MaxMigrations of them. A target
+// must pass the VM's profile filters as if the VM had left its node, so migrations never
+// break capacity, taints, affinity, anti-affinity or topology spread rules.
+func Rebalance(state api.ClusterState) RebalancePlan {
+	cfg := state.Config.DRS.WithDefaults()
+	plan := RebalancePlan{Mode: cfg.Mode, Threshold: cfg.Threshold, Nodes: map[string]Utilization{}, Migrations: []Migration{}}
+	loads := map[string]nodeLoad{}
+	var ids []string
+	for id, n := range state.Nodes {
+		if rebalanceable(n) {
+			loads[id] = loadOf(n)
+			plan.Nodes[id] = loads[id].utilization()
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	plan.Imbalance = imbalance(loads)
+
+	// VM loads are estimated once, from the nodes they run on now
+	var cands []api.VM
+	share := map[string]nodeLoad{}
+	for _, vm := range state.VMs {
+		if _, ok := loads[vm.NodeID]; ok && vm.Phase == "Running" {
+			cands = append(cands, vm)
+			share[vm.ID] = vmLoad(state.Nodes[vm.NodeID], vm)
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].ID < cands[j].ID })
+
+	cur := plan.Imbalance
+	moved := map[string]bool{}
+	for len(plan.Migrations) < cfg.MaxMigrations && cur > cfg.Threshold {
+		limit := cur - cfg.MinImprovement
+		var best Migration
+		found := false
+		for _, vm := range cands {
+			if moved[vm.ID] {
+				continue
+			}
+			_, filters, _, err := profile(state, vm)
+			if err != nil {
+				continue
+			}
+			trial := withPlacement(state, []api.VM{vm}, "")
+			for _, to := range ids {
+				if to == vm.NodeID {
+					continue
+				}
+				after := imbalance(moveLoad(loads, vm.NodeID, to, share[vm.ID]))
+				if after > limit || (found && after >= best.Imbalance) {
+					continue
+				}
+				if reasons, _ := filterNode(&trial, vm, trial.Nodes[to], filters); len(reasons) > 0 {
+					continue
+				}
+				best, found = Migration{VMID: vm.ID, From: vm.NodeID, To: to, Imbalance: after}, true
+			}
+		}
+		if !found {
+			break
+		}
+		u := loads[best.From].utilization()
+		best.Hot = u.CPU > cfg.HotUtilization || u.Memory > cfg.HotUtilization
+		loads = moveLoad(loads, best.From, best.To, share[best.VMID])
+		state = withPlacement(state, []api.VM{state.VMs[best.VMID]}, best.To)
+		moved[best.VMID] = true
+		cur = best.Imbalance
+		plan.Migrations = append(plan.Migrations, best)
+	}
+	return plan
+}
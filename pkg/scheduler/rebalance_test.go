@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"testing"
+
+	"clustering/pkg/api"
+)
+
+func TestRebalanceRecommendsBoundedMigrations(t *testing.T) {
+	caps := []string{api.CapLiveMigrate}
+	node := func(id string, alloc, usage api.Resources) api.Node {
+		return api.Node{ID: id, Status: "Alive", Capabilities: caps, Capacity: api.Resources{CPU: 4000, Memory: 8192}, Allocated: alloc, Usage: usage}
+	}
+	web := []api.VMAffinityTerm{{Selector: []api.LabelRequirement{{Key: "app", Operator: api.LabelIn, Values: []string{"web"}}}}}
+	vm := func(id, node, app string) api.VM {
+		v := api.VM{ID: id, NodeID: node, Phase: "Running", Labels: map[string]string{"app": app}, Resources: api.Resources{CPU: 1000, Memory: 2048}}
+		if app == "web" {
+			v.Policy.VMAntiAffinity = web
+		}
+		return v
+	}
+	st := api.ClusterState{
+		Nodes: map[string]api.Node{
+			"n1": node("n1", api.Resources{CPU: 3000, Memory: 6144}, api.Resources{CPU: 3600, Memory: 7000}),
+			"n2": node("n2", api.Resources{CPU: 1000, Memory: 2048}, api.Resources{CPU: 900, Memory: 1800}),
+		},
+		VMs: map[string]api.VM{"w1": vm("w1", "n1", "web"), "x1": vm("x1", "n1", "db"), "x2": vm("x2", "n1", "db"), "w2": vm("w2", "n2", "web")},
+	}
+
+	// the only other node already runs a web VM, so w1 stays put
+	plan := Rebalance(st)
+	if plan.Mode != api.DRSManual || plan.Imbalance <= plan.Threshold || len(plan.Migrations) == 0 {
+		t.Fatalf("want recommendations, got %+v", plan)
+	}
+	for _, m := range plan.Migrations {
+		if m.VMID == "w1" {
+			t.Fatalf("w1 must not join w2: %+v", plan.Migrations)
+		}
+	}
+
+	st.Nodes["n3"] = node("n3", api.Resources{}, api.Resources{})
+	st.Config.DRS.MaxMigrations = 2
+	plan = Rebalance(st)
+	if len(plan.Migrations) != 2 {
+		t.Fatalf("want 2 migrations, got %+v", plan.Migrations)
+	}
+	prev := plan.Imbalance
+	for _, m := range plan.Migrations {
+		if m.From != "n1" || m.Imbalance >= prev || (m.VMID == "w1" && m.To == "n2") {
+			t.Fatalf("unexpected migration %+v after imbalance %.3f", m, prev)
+		}
+		prev = m.Imbalance
+	}
+	if !plan.Migrations[0].Hot {
+		t.Fatalf("n1 is overloaded: %+v", plan.Migrations[0])
+	}
+
+	// nodes that cannot live-migrate are left alone
+	n3 := st.Nodes["n3"]
+	n3.Capabilities = nil
+	st.Nodes["n3"] = n3
+	st.Config.DRS.Threshold = 1
+	if plan := Rebalance(st); len(plan.Migrations) != 0 || len(plan.Nodes) != 2 {
+		t.Fatalf("want no migrations over two nodes, got %+v", plan)
+	}
+}
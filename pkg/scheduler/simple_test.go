@@ -43,3 +43,59 @@ func TestChooseNodeWithAffinity(t *testing.T) {
 		t.Fatalf("expected n2 got %s ok=%v", id, ok)
 	}
 }
+
+func TestChooseNodeNearNode(t *testing.T) {
+	coord := func(x float64) *api.Coordinate { return &api.Coordinate{Vec: []float64{x, 0}} }
+	st := api.ClusterState{Nodes: map[string]api.Node{
+		"db": {ID: "db", Status: "Alive", Capacity: api.Resources{CPU: 100, Memory: 100}, Coordinate: coord(0)},
+		"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}, Coordinate: coord(0.050)},
+		"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}, Allocated: api.Resources{CPU: 1500}, Coordinate: coord(0.001)},
+		"n3": {ID: "n3", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}},
+	}, Volumes: map[string]api.Volume{"vol1": {ID: "vol1", Node: "db"}}}
+	vm := api.VM{ID: "vm1", Resources: api.Resources{CPU: 300, Memory: 200}, Policy: api.VMSchedulingPolicy{NearNode: "db"}}
+	if id, ok := ChooseNode(st, vm); !ok || id != "n2" {
+		t.Fatalf("expected closest node n2 got %s ok=%v", id, ok)
+	}
+	vm.Policy = api.VMSchedulingPolicy{NearVolume: "vol1"}
+	if id, ok := ChooseNode(st, vm); !ok || id != "n2" {
+		t.Fatalf("expected node closest to volume n2 got %s ok=%v", id, ok)
+	}
+}
+
+func TestChooseNodeHonoursTaints(t *testing.T) {
+	st := api.ClusterState{Nodes: map[string]api.Node{
+		"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}, Taints: []api.Taint{{Key: api.TaintDiskPressure, Effect: api.TaintNoSchedule}}},
+		"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}, Allocated: api.Resources{CPU: 1500}, Taints: []api.Taint{{Key: "gpu", Value: "true", Effect: api.TaintPreferNoSchedule}}},
+	}}
+	vm := api.VM{ID: "vm1", Resources: api.Resources{CPU: 100, Memory: 100}}
+	if id, ok := ChooseNode(st, vm); !ok || id != "n2" {
+		t.Fatalf("expected n2 got %s ok=%v", id, ok)
+	}
+	n3 := api.Node{ID: "n3", Status: "Alive", Capacity: api.Resources{CPU: 2000, Memory: 2048}, Allocated: api.Resources{CPU: 1800}}
+	st.Nodes["n3"] = n3
+	if id, _ := ChooseNode(st, vm); id != "n3" {
+		t.Fatalf("PreferNoSchedule node should come last, got %s", id)
+	}
+	vm.Policy.Tolerations = []api.Toleration{{Key: api.TaintDiskPressure, Operator: "Exists"}}
+	if id, _ := ChooseNode(st, vm); id != "n1" {
+		t.Fatalf("tolerating vm should use n1, got %s", id)
+	}
+}
+
+func TestChooseNodeExcludingAndCordon(t *testing.T) {
+	st := api.ClusterState{Nodes: map[string]api.Node{
+		"n1": {ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}},
+		"n2": {ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}, Allocated: api.Resources{CPU: 500}},
+		"n3": {ID: "n3", Status: "Alive", Capacity: api.Resources{CPU: 4000, Memory: 4096}, Unschedulable: true},
+	}}
+	vm := api.VM{ID: "vm1", NodeID: "n1", Resources: api.Resources{CPU: 100, Memory: 100}}
+	if id, ok := ChooseNodeExcluding(st, vm, "n1"); !ok || id != "n2" {
+		t.Fatalf("want n2 got %s ok=%v", id, ok)
+	}
+	if len(st.Nodes) != 3 {
+		t.Fatal("caller's node map modified")
+	}
+	if _, ok := ChooseNodeExcluding(st, vm, "n1", "n2"); ok {
+		t.Fatal("cordoned node must not be chosen")
+	}
+}
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"clustering/pkg/api"
+)
+
+// ErrUnknownVM is returned when a simulation names a VM that does not exist.
+var ErrUnknownVM = errors.New("unknown vm")
+
+// SimulateRequest asks where a VM would be placed. VMID names a VM in the state and VM
+// describes a hypothetical one (VM wins when both are set). Nodes, VMs and Profiles are
+// applied to a copy of the state first, adding or replacing entries by ID or name;
+// RemoveNodes drops nodes.
+type SimulateRequest struct {
+	VMID        string                 `json:"vmId,omitempty"`
+	VM          *api.VM                `json:"vm,omitempty"`
+	Nodes       map[string]api.Node    `json:"nodes,omitempty"`
+	RemoveNodes []string               `json:"removeNodes,omitempty"`
+	VMs         map[string]api.VM      `json:"vms,omitempty"`
+	Profiles    []api.SchedulerProfile `json:"profiles,omitempty"`
+}
+
+// Simulate runs the scheduler for the request without changing anything. A VM that is
+// already placed is scheduled as if it left its node.
+func Simulate(state api.ClusterState, req SimulateRequest) (Result, error) {
+	var vm api.VM
+	switch {
+	case req.VM != nil:
+		vm = *req.VM
+	case req.VMID != "":
+		v, ok := state.VMs[req.VMID]
+		if !ok {
+			return Result{}, fmt.Errorf("%w %s", ErrUnknownVM, req.VMID)
+		}
+		vm = v
+	default:
+		return Result{}, errors.New("vmId or vm required")
+	}
+	if err := ValidateProfiles(req.Profiles); err != nil {
+		return Result{}, err
+	}
+
+	nodes := make(map[string]api.Node, len(state.Nodes))
+	for id, n := range state.Nodes {
+		nodes[id] = n
+	}
+	for id, n := range req.Nodes {
+		n.ID = id
+		nodes[id] = n
+	}
+	for _, id := range req.RemoveNodes {
+		delete(nodes, id)
+	}
+	state.Nodes = nodes
+	if len(req.VMs) > 0 {
+		vms := make(map[string]api.VM, len(state.VMs)+len(req.VMs))
+		for id, v := range state.VMs {
+			vms[id] = v
+		}
+		for id, v := range req.VMs {
+			v.ID = id
+			vms[id] = v
+		}
+		state.VMs = vms
+		recomputeAllocations(&state)
+	}
+	if len(req.Profiles) > 0 {
+		state.Config.SchedulerProfiles = append(append([]api.SchedulerProfile(nil), state.Config.SchedulerProfiles...), req.Profiles...)
+	}
+	if n, ok := state.Nodes[vm.NodeID]; ok && state.VMs[vm.ID].NodeID == vm.NodeID {
+		n.Allocated.CPU -= vm.Resources.CPU
+		n.Allocated.Memory -= vm.Resources.Memory
+		n.Allocated.Disk -= vm.Resources.Disk
+		state.Nodes[n.ID] = n
+	}
+	vm.NodeID = ""
+	return Schedule(state, vm)
+}
+
+// recomputeAllocations sums the resources of the VMs assigned to each node.
+func recomputeAllocations(state *api.ClusterState) {
+	for id, n := range state.Nodes {
+		n.Allocated = api.Resources{}
+		state.Nodes[id] = n
+	}
+	for _, v := range state.VMs {
+		if n, ok := state.Nodes[v.NodeID]; ok {
+			n.Allocated.CPU += v.Resources.CPU
+			n.Allocated.Memory += v.Resources.Memory
+			n.Allocated.Disk += v.Resources.Disk
+			state.Nodes[v.NodeID] = n
+		}
+	}
+}
+
+// Summary describes the result in one line, e.g. "placed on n3" or "0/4 nodes are
+// available: 3 Capacity, 1 NodeSchedulable".
+func (r Result) Summary() string {
+	if r.NodeID != "" {
+		return "placed on " + r.NodeID
+	}
+	if len(r.Nodes) == 0 {
+		return "no nodes in the cluster"
+	}
+	counts := map[string]int{}
+	for _, nr := range r.Nodes {
+		for _, reason := range nr.Reasons {
+			plugin, _, _ := strings.Cut(reason, ":")
+			counts[plugin]++
+		}
+	}
+	plugins := make([]string, 0, len(counts))
+	for p := range counts {
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		if counts[plugins[i]] != counts[plugins[j]] {
+			return counts[plugins[i]] > counts[plugins[j]]
+		}
+		return plugins[i] < plugins[j]
+	})
+	parts := make([]string, len(plugins))
+	for i, p := range plugins {
+		parts[i] = fmt.Sprintf("%d %s", counts[p], p)
+	}
+	return fmt.Sprintf("0/%d nodes are available: %s", len(r.Nodes), strings.Join(parts, ", "))
+}
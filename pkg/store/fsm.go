@@ -2,31 +2,62 @@ package store
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 
 	"clustering/pkg/api"
+	"clustering/pkg/scheduler"
 
 	"github.com/hashicorp/raft"
 )
 
+// MaxEvents bounds the cluster events kept in the state.
+const MaxEvents = 1000
+
 type FSM struct {
 	mu    sync.RWMutex
 	state api.ClusterState
+	// watchers are called after every applied command, in apply order.
+	watchers []func(Command)
 }
 
 func NewFSM() *FSM {
-	return &FSM{state: api.ClusterState{Nodes: map[string]api.Node{}, VMs: map[string]api.VM{}, Templates: map[string]api.VMTemplate{}, Volumes: map[string]api.Volume{}, Networks: map[string]api.Network{}, StoragePools: map[string]api.StoragePool{}, Config: api.ClusterConfig{DesiredVoters: 5, DesiredNonVoters: 2}, ConfigVersion: 1, ConfigHistory: []api.ClusterConfig{}}}
+	return &FSM{state: api.ClusterState{Nodes: map[string]api.Node{}, VMs: map[string]api.VM{}, Templates: map[string]api.VMTemplate{}, Volumes: map[string]api.Volume{}, Networks: map[string]api.Network{}, StoragePools: map[string]api.StoragePool{}, PriorityClasses: map[string]api.PriorityClass{}, Config: api.ClusterConfig{DesiredVoters: 5, DesiredNonVoters: 2}, ConfigVersion: 1, ConfigHistory: []api.ClusterConfig{}}}
 }
 
 func (f *FSM) Apply(l *raft.Log) interface{} {
-	f.mu.Lock()
-	defer f.mu.Unlock()
 	var cmd Command
 	if err := json.Unmarshal(l.Data, &cmd); err != nil {
 		return err
 	}
-	return f.applyCommand(cmd)
+	f.mu.Lock()
+	res := f.applyCommand(cmd)
+	watchers := f.watchers
+	f.mu.Unlock()
+	f.notify(watchers, cmd)
+	return res
+}
+
+// Watch registers fn to be called with every command after it was applied, and with a
+// RestoreCommand after a snapshot replaced the state. fn runs on the apply path and must
+// not block.
+func (f *FSM) Watch(fn func(Command)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchers = append(f.watchers, fn)
+}
+
+// RestoreCommand is the command type watchers see after a snapshot restore.
+const RestoreCommand = "Restore"
+
+func (f *FSM) notify(watchers []func(Command), cmd Command) {
+	for _, fn := range watchers {
+		fn(cmd)
+	}
 }
 
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
@@ -47,8 +78,10 @@ func (f *FSM) Restore(rc io.ReadCloser) error {
 		return err
 	}
 	f.mu.Lock()
-	defer f.mu.Unlock()
 	f.state = s
+	watchers := f.watchers
+	f.mu.Unlock()
+	f.notify(watchers, Command{Type: RestoreCommand})
 	return nil
 }
 
@@ -57,6 +90,15 @@ func (f *FSM) applyCommand(c Command) interface{} {
 	case "UpsertNode":
 		var n api.Node
 		_ = json.Unmarshal(c.Payload, &n)
+		if prev, ok := f.state.Nodes[n.ID]; ok {
+			// heartbeat- and controller-owned fields are not part of a membership upsert
+			n.Usage, n.RunningVMs, n.DriverVersion = prev.Usage, prev.RunningVMs, prev.DriverVersion
+			n.AgentUptime, n.LastHeartbeat = prev.AgentUptime, prev.LastHeartbeat
+			n.Ready, n.ReadyReason = prev.Ready, prev.ReadyReason
+			n.Checks, n.Conditions, n.Taints = prev.Checks, prev.Conditions, prev.Taints
+			n.Unschedulable, n.Maintenance, n.Drain = prev.Unschedulable, prev.Maintenance, prev.Drain
+			n.Fence, n.Standby = prev.Fence, prev.Standby
+		}
 		f.state.Nodes[n.ID] = n
 		// recompute allocations: naive aggregate VMs on node
 		n.Allocated = api.Resources{}
@@ -68,6 +110,104 @@ func (f *FSM) applyCommand(c Command) interface{} {
 			}
 		}
 		f.state.Nodes[n.ID] = n
+	case "NodeHeartbeat":
+		var hb api.NodeHeartbeat
+		_ = json.Unmarshal(c.Payload, &hb)
+		n, ok := f.state.Nodes[hb.NodeID]
+		if !ok {
+			return nil
+		}
+		n.Usage, n.RunningVMs, n.DriverVersion = hb.Usage, hb.RunningVMs, hb.DriverVersion
+		n.AgentUptime, n.LastHeartbeat = hb.UptimeSeconds, hb.Time
+		f.state.Nodes[n.ID] = n
+	case "SetNodeReadiness":
+		var nr api.NodeReadiness
+		_ = json.Unmarshal(c.Payload, &nr)
+		if n, ok := f.state.Nodes[nr.NodeID]; ok {
+			n.Ready, n.ReadyReason = nr.Ready, nr.Reason
+			status := api.ConditionTrue
+			if !nr.Ready {
+				status = api.ConditionFalse
+			}
+			n.Conditions = api.SetCondition(n.Conditions, api.NodeCondition{Type: api.NodeReady, Status: status, Reason: nr.Reason, LastTransitionTime: nr.Time})
+			n.Taints = api.SyncConditionTaints(n.Taints, n.Conditions)
+			f.state.Nodes[n.ID] = n
+		}
+	case "SetNodeHealth":
+		var h api.NodeHealth
+		_ = json.Unmarshal(c.Payload, &h)
+		if n, ok := f.state.Nodes[h.NodeID]; ok {
+			n.Checks = h.Checks
+			for _, cond := range h.Conditions {
+				n.Conditions = api.SetCondition(n.Conditions, cond)
+			}
+			n.Taints = api.SyncConditionTaints(n.Taints, n.Conditions)
+			f.state.Nodes[n.ID] = n
+		}
+	case "SetNodeConditions":
+		var nc api.NodeConditions
+		_ = json.Unmarshal(c.Payload, &nc)
+		if n, ok := f.state.Nodes[nc.NodeID]; ok {
+			for _, cond := range nc.Conditions {
+				n.Conditions = api.SetCondition(n.Conditions, cond)
+			}
+			n.Taints = api.SyncConditionTaints(n.Taints, n.Conditions)
+			f.state.Nodes[n.ID] = n
+		}
+	case "SetNodeSchedulable":
+		var ns api.NodeSchedulability
+		_ = json.Unmarshal(c.Payload, &ns)
+		if n, ok := f.state.Nodes[ns.NodeID]; ok {
+			n.Unschedulable, n.Maintenance = ns.Unschedulable || ns.Maintenance, ns.Maintenance
+			f.state.Nodes[n.ID] = n
+		}
+	case "SetNodeDrain":
+		var ds api.DrainStatus
+		_ = json.Unmarshal(c.Payload, &ds)
+		if n, ok := f.state.Nodes[ds.NodeID]; ok {
+			n.Drain = &ds
+			f.state.Nodes[n.ID] = n
+		}
+	case "SetNodeFence":
+		var nf api.NodeFence
+		_ = json.Unmarshal(c.Payload, &nf)
+		if n, ok := f.state.Nodes[nf.NodeID]; ok {
+			n.Fence = nf.Fence
+			f.state.Nodes[n.ID] = n
+		}
+	case "SetNodeStandby":
+		var ns api.NodeStandby
+		_ = json.Unmarshal(c.Payload, &ns)
+		if n, ok := f.state.Nodes[ns.NodeID]; ok {
+			n.Standby = ns.Standby
+			f.state.Nodes[n.ID] = n
+		}
+	case "RecordEvent":
+		var ev api.Event
+		_ = json.Unmarshal(c.Payload, &ev)
+		f.state.Events = append(f.state.Events, ev)
+		if over := len(f.state.Events) - MaxEvents; over > 0 {
+			f.state.Events = append([]api.Event(nil), f.state.Events[over:]...)
+		}
+	case "SetNodeTaints":
+		// replaces the operator taints; condition-derived taints are kept
+		var nt api.NodeTaints
+		_ = json.Unmarshal(c.Payload, &nt)
+		if n, ok := f.state.Nodes[nt.NodeID]; ok {
+			var taints []api.Taint
+			for _, t := range nt.Taints {
+				if !api.IsConditionTaint(t) {
+					taints = append(taints, t)
+				}
+			}
+			for _, t := range n.Taints {
+				if api.IsConditionTaint(t) {
+					taints = append(taints, t)
+				}
+			}
+			n.Taints = taints
+			f.state.Nodes[n.ID] = n
+		}
 	case "DeleteNode":
 		var id string
 		_ = json.Unmarshal(c.Payload, &id)
@@ -75,36 +215,66 @@ func (f *FSM) applyCommand(c Command) interface{} {
 	case "UpsertVM":
 		var v api.VM
 		_ = json.Unmarshal(c.Payload, &v)
-		f.state.VMs[v.ID] = v
-		// adjust allocation for node
-		if n, ok := f.state.Nodes[v.NodeID]; ok {
-			n.Allocated.CPU += v.Resources.CPU
-			n.Allocated.Memory += v.Resources.Memory
-			n.Allocated.Disk += v.Resources.Disk
-			f.state.Nodes[v.NodeID] = n
+		f.upsertVM(v)
+	case "BindVM":
+		var v api.VM
+		_ = json.Unmarshal(c.Payload, &v)
+		if why := f.bind(v); why != "" {
+			return fmt.Errorf("%w: vm %s on %s: %s", ErrBindConflict, v.ID, v.NodeID, why)
 		}
+	case "BindVMs":
+		var vms []api.VM
+		_ = json.Unmarshal(c.Payload, &vms)
+		conflicts := BindConflicts{}
+		for _, v := range vms {
+			if why := f.bind(v); why != "" {
+				conflicts[v.ID] = why
+			}
+		}
+		if len(conflicts) > 0 {
+			return conflicts
+		}
+	case "UpdateVMStatus":
+		var st api.VMStatus
+		_ = json.Unmarshal(c.Payload, &st)
+		v, ok := f.state.VMs[st.VMID]
+		// Ignore reports from a node the VM is no longer assigned to (e.g. after a migration).
+		if !ok || v.NodeID != st.NodeID {
+			return nil
+		}
+		v.Phase = st.Phase
+		v.Status = &st
+		f.state.VMs[v.ID] = v
 	case "DeleteVM":
 		var id string
 		_ = json.Unmarshal(c.Payload, &id)
 		v, ok := f.state.VMs[id]
 		delete(f.state.VMs, id)
 		if ok {
-			if n, ok2 := f.state.Nodes[v.NodeID]; ok2 {
-				n.Allocated.CPU -= v.Resources.CPU
-				n.Allocated.Memory -= v.Resources.Memory
-				n.Allocated.Disk -= v.Resources.Disk
-				if n.Allocated.CPU < 0 {
-					n.Allocated.CPU = 0
-				}
-				if n.Allocated.Memory < 0 {
-					n.Allocated.Memory = 0
-				}
-				if n.Allocated.Disk < 0 {
-					n.Allocated.Disk = 0
-				}
-				f.state.Nodes[v.NodeID] = n
+			f.release(v)
+		}
+	case "PreemptVM":
+		var p api.Preemption
+		_ = json.Unmarshal(c.Payload, &p)
+		vm, ok := f.state.VMs[p.VMID]
+		if !ok {
+			return nil
+		}
+		for _, id := range p.Victims {
+			v, ok := f.state.VMs[id]
+			if !ok || v.NodeID != p.NodeID {
+				continue
+			}
+			f.release(v)
+			st := api.VMStatus{VMID: id, Phase: "Pending", Reason: api.VMReasonPreempted, Message: "preempted by " + p.VMID, ObservedAt: p.Time}
+			if v.Status != nil {
+				st.Restarts, st.LastRestartAt = v.Status.Restarts, v.Status.LastRestartAt
 			}
+			v.NodeID, v.Phase, v.Status = "", "Pending", &st
+			f.state.VMs[id] = v
 		}
+		vm.NominatedNode = p.NodeID
+		f.state.VMs[vm.ID] = vm
 	case "SetConfig":
 		var cfg api.ClusterConfig
 		_ = json.Unmarshal(c.Payload, &cfg)
@@ -159,10 +329,101 @@ func (f *FSM) applyCommand(c Command) interface{} {
 		var id string
 		_ = json.Unmarshal(c.Payload, &id)
 		delete(f.state.Templates, id)
+	case "UpsertPriorityClass":
+		var pc api.PriorityClass
+		_ = json.Unmarshal(c.Payload, &pc)
+		if f.state.PriorityClasses == nil {
+			f.state.PriorityClasses = map[string]api.PriorityClass{}
+		}
+		f.state.PriorityClasses[pc.Name] = pc
+	case "DeletePriorityClass":
+		var name string
+		_ = json.Unmarshal(c.Payload, &name)
+		delete(f.state.PriorityClasses, name)
 	}
 	return nil
 }
 
+// ErrBindConflict rejects a BindVM whose node no longer fits the VM.
+var ErrBindConflict = errors.New("bind conflict")
+
+// BindConflicts is returned by BindVMs when some VMs could not be bound: why, by VM ID.
+// The other VMs were bound.
+type BindConflicts map[string]string
+
+func (b BindConflicts) Error() string {
+	ids := make([]string, 0, len(b))
+	for id := range b {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for i, id := range ids {
+		ids[i] = id + ": " + b[id]
+	}
+	return ErrBindConflict.Error() + ": " + strings.Join(ids, "; ")
+}
+
+func (b BindConflicts) Is(target error) bool { return target == ErrBindConflict }
+
+// UpsertVMCommand returns the command that stores a VM given the current state: BindVM,
+// which re-checks the node, for a VM that is new with a node or moves to another node,
+// and UpsertVM otherwise, so a VM can still be updated (stopped, say) on a node that no
+// longer fits it.
+func UpsertVMCommand(state api.ClusterState, vm api.VM) Command {
+	if prev, ok := state.VMs[vm.ID]; vm.NodeID != "" && (!ok || prev.NodeID != vm.NodeID) {
+		return NewCommand("BindVM", vm)
+	}
+	return NewCommand("UpsertVM", vm)
+}
+
+// bind stores a VM placed on v.NodeID after re-checking against the authoritative state
+// that the node still fits it; the VM's resources move over from its previous node. It
+// returns why the node does not fit, or "".
+func (f *FSM) bind(v api.VM) string {
+	n, ok := f.state.Nodes[v.NodeID]
+	if !ok {
+		return "node not found"
+	}
+	if prev, ok := f.state.VMs[v.ID]; ok && prev.NodeID == v.NodeID {
+		n.Allocated.CPU -= prev.Resources.CPU
+		n.Allocated.Memory -= prev.Resources.Memory
+		n.Allocated.Disk -= prev.Resources.Disk
+	}
+	if reasons := scheduler.CheckBinding(&f.state, v, n); len(reasons) > 0 {
+		return strings.Join(reasons, "; ")
+	}
+	f.upsertVM(v)
+	return ""
+}
+
+// upsertVM stores a VM and moves its allocation from its previous placement, if any,
+// to its node.
+func (f *FSM) upsertVM(v api.VM) {
+	if prev, ok := f.state.VMs[v.ID]; ok {
+		f.release(prev)
+	}
+	f.state.VMs[v.ID] = v
+	// adjust allocation for node
+	if n, ok := f.state.Nodes[v.NodeID]; ok {
+		n.Allocated.CPU += v.Resources.CPU
+		n.Allocated.Memory += v.Resources.Memory
+		n.Allocated.Disk += v.Resources.Disk
+		f.state.Nodes[v.NodeID] = n
+	}
+}
+
+// release returns a VM's resources to the node it was assigned to.
+func (f *FSM) release(v api.VM) {
+	n, ok := f.state.Nodes[v.NodeID]
+	if !ok {
+		return
+	}
+	n.Allocated.CPU = max(n.Allocated.CPU-v.Resources.CPU, 0)
+	n.Allocated.Memory = max(n.Allocated.Memory-v.Resources.Memory, 0)
+	n.Allocated.Disk = max(n.Allocated.Disk-v.Resources.Disk, 0)
+	f.state.Nodes[v.NodeID] = n
+}
+
 type snapshot struct {
 	state api.ClusterState
 }
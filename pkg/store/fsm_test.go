@@ -3,8 +3,12 @@ package store
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"clustering/pkg/api"
 
@@ -114,3 +118,258 @@ func (s *sink) Close() error                { return nil }
 func (s *sink) Write(p []byte) (int, error) { return s.b.Write(p) }
 
 // (no fake raft.Log type needed)
+
+func TestFSMUpdateVMStatusIgnoresOtherNodes(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertVM", api.VM{ID: "vm1", NodeID: "n1", Phase: "Pending"})))
+
+	f.Apply(mkLog(NewCommand("UpdateVMStatus", api.VMStatus{VMID: "vm1", NodeID: "n2", Phase: "Stopped"})))
+	if vm := f.GetStateCopy().VMs["vm1"]; vm.Phase != "Pending" || vm.Status != nil {
+		t.Fatalf("report from unassigned node applied: %+v", vm)
+	}
+
+	f.Apply(mkLog(NewCommand("UpdateVMStatus", api.VMStatus{VMID: "vm1", NodeID: "n1", Phase: "Running"})))
+	if vm := f.GetStateCopy().VMs["vm1"]; vm.Phase != "Running" || vm.Status == nil || vm.Status.NodeID != "n1" {
+		t.Fatalf("status not applied: %+v", vm)
+	}
+}
+
+func TestFSMUpdateVMStatusOfUnplacedVM(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertVM", api.VM{ID: "vm1", Phase: "Pending", Resources: api.Resources{CPU: 500}})))
+
+	st := api.VMStatus{VMID: "vm1", Phase: "Pending", Reason: api.VMReasonFailedScheduling}
+	f.Apply(mkLog(NewCommand("UpdateVMStatus", st)))
+	if vm := f.GetStateCopy().VMs["vm1"]; vm.Status == nil || vm.Status.Reason != api.VMReasonFailedScheduling || vm.Resources.CPU != 500 {
+		t.Fatalf("status not applied or spec lost: %+v", vm)
+	}
+
+	// a status for a deleted VM does not bring it back
+	f.Apply(mkLog(NewCommand("DeleteVM", "vm1")))
+	f.Apply(mkLog(NewCommand("UpdateVMStatus", st)))
+	if _, ok := f.GetStateCopy().VMs["vm1"]; ok {
+		t.Fatal("deleted vm recreated by a status update")
+	}
+}
+
+func TestFSMHeartbeatSurvivesNodeUpsert(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive"})))
+	now := time.Now().UTC().Truncate(time.Second)
+	f.Apply(mkLog(NewCommand("NodeHeartbeat", api.NodeHeartbeat{NodeID: "n1", Time: now, Usage: api.Resources{CPU: 250}, RunningVMs: []string{"vm1"}})))
+	f.Apply(mkLog(NewCommand("SetNodeReadiness", api.NodeReadiness{NodeID: "n1", Ready: true})))
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Address: "10.0.0.1:9090"})))
+
+	n := f.GetStateCopy().Nodes["n1"]
+	if n.Address != "10.0.0.1:9090" || !n.LastHeartbeat.Equal(now) || n.Usage.CPU != 250 || len(n.RunningVMs) != 1 || !n.Ready {
+		t.Fatalf("heartbeat fields lost or upsert not applied: %+v", n)
+	}
+	if r := f.Apply(mkLog(NewCommand("NodeHeartbeat", api.NodeHeartbeat{NodeID: "unknown"}))); r != nil {
+		t.Fatalf("heartbeat for unknown node: %v", r)
+	}
+	if _, ok := f.GetStateCopy().Nodes["unknown"]; ok {
+		t.Fatal("heartbeat must not create nodes")
+	}
+}
+
+func TestFSMNodeHealthMergesConditions(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive"})))
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	f.Apply(mkLog(NewCommand("SetNodeHealth", api.NodeHealth{NodeID: "n1",
+		Checks:     []api.CheckResult{{Name: "healthz", Type: "agent", OK: true}},
+		Conditions: []api.NodeCondition{{Type: api.NodeHealthy, Status: api.ConditionTrue, LastTransitionTime: t0}}})))
+	f.Apply(mkLog(NewCommand("SetNodeHealth", api.NodeHealth{NodeID: "n1",
+		Checks: []api.CheckResult{{Name: "healthz", Type: "agent", OK: false, ConsecutiveFailures: 1}},
+		Conditions: []api.NodeCondition{
+			{Type: api.NodeHealthy, Status: api.ConditionTrue, LastTransitionTime: t1},
+			{Type: api.NodeDiskPressure, Status: api.ConditionTrue, LastTransitionTime: t1},
+		}})))
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive"})))
+
+	n := f.GetStateCopy().Nodes["n1"]
+	if len(n.Checks) != 1 || n.Checks[0].ConsecutiveFailures != 1 {
+		t.Fatalf("checks not replaced: %+v", n.Checks)
+	}
+	if c, ok := n.Condition(api.NodeHealthy); !ok || !c.LastTransitionTime.Equal(t0) {
+		t.Fatalf("unchanged condition must keep its transition time: %+v", n.Conditions)
+	}
+	if c, ok := n.Condition(api.NodeDiskPressure); !ok || c.Status != api.ConditionTrue {
+		t.Fatalf("new condition not added: %+v", n.Conditions)
+	}
+}
+
+func TestFSMConditionTaints(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive"})))
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.Apply(mkLog(NewCommand("SetNodeTaints", api.NodeTaints{NodeID: "n1", Taints: []api.Taint{{Key: "gpu", Effect: api.TaintNoSchedule}, {Key: api.TaintNotReady, Effect: api.TaintNoSchedule}}})))
+	f.Apply(mkLog(NewCommand("SetNodeReadiness", api.NodeReadiness{NodeID: "n1", Ready: false, Reason: "NoHeartbeat", Time: t0})))
+	f.Apply(mkLog(NewCommand("SetNodeConditions", api.NodeConditions{NodeID: "n1", Conditions: []api.NodeCondition{{Type: api.NodeMemoryPressure, Status: api.ConditionTrue, LastTransitionTime: t0}}})))
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive"})))
+
+	keys := func() map[string]api.Taint {
+		out := map[string]api.Taint{}
+		for _, tt := range f.GetStateCopy().Nodes["n1"].Taints {
+			out[tt.Key] = tt
+		}
+		return out
+	}
+	got := keys()
+	if len(got) != 3 || got["gpu"].Effect != api.TaintNoSchedule || got[api.TaintMemoryPressure].Effect != api.TaintNoSchedule {
+		t.Fatalf("unexpected taints: %+v", got)
+	}
+	if nr := got[api.TaintNotReady]; nr.Effect != api.TaintNoExecute || !nr.TimeAdded.Equal(t0) {
+		t.Fatalf("operator cannot set condition taints; want derived not-ready taint, got %+v", nr)
+	}
+
+	f.Apply(mkLog(NewCommand("SetNodeReadiness", api.NodeReadiness{NodeID: "n1", Ready: true, Time: t0.Add(time.Minute)})))
+	if got := keys(); len(got) != 2 {
+		t.Fatalf("not-ready taint not removed: %+v", got)
+	}
+}
+
+func TestFSMEventsAreBounded(t *testing.T) {
+	f := NewFSM()
+	for i := 0; i < MaxEvents+5; i++ {
+		f.Apply(mkLog(NewCommand("RecordEvent", api.Event{Reason: "R", Object: fmt.Sprint(i)})))
+	}
+	ev := f.GetStateCopy().Events
+	if len(ev) != MaxEvents || ev[0].Object != "5" || ev[len(ev)-1].Object != fmt.Sprint(MaxEvents+4) {
+		t.Fatalf("want the last %d events, got %d starting at %s", MaxEvents, len(ev), ev[0].Object)
+	}
+}
+
+func TestFSMPreemptVMReleasesVictims(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}})))
+	f.Apply(mkLog(NewCommand("UpsertVM", api.VM{ID: "low", NodeID: "n1", Phase: "Running", Resources: api.Resources{CPU: 600, Memory: 512}})))
+	f.Apply(mkLog(NewCommand("UpsertVM", api.VM{ID: "high", Phase: "Pending", Resources: api.Resources{CPU: 800, Memory: 512}})))
+
+	f.Apply(mkLog(NewCommand("PreemptVM", api.Preemption{VMID: "high", NodeID: "n1", Victims: []string{"low", "gone"}})))
+	st := f.GetStateCopy()
+	if n := st.Nodes["n1"]; n.Allocated.CPU != 0 || n.Allocated.Memory != 0 {
+		t.Fatalf("victim allocation not released: %+v", n.Allocated)
+	}
+	if v := st.VMs["low"]; v.NodeID != "" || v.Phase != "Pending" || v.Status == nil || v.Status.Reason != api.VMReasonPreempted {
+		t.Fatalf("victim not evicted: %+v", v)
+	}
+	if vm := st.VMs["high"]; vm.NominatedNode != "n1" {
+		t.Fatalf("preemptor not nominated: %+v", vm)
+	}
+
+	f.Apply(mkLog(NewCommand("UpsertPriorityClass", api.PriorityClass{Name: "high", Value: 100})))
+	if pc := f.GetStateCopy().PriorityClasses["high"]; pc.Value != 100 {
+		t.Fatalf("priority class not stored: %+v", pc)
+	}
+	f.Apply(mkLog(NewCommand("DeletePriorityClass", "high")))
+	if len(f.GetStateCopy().PriorityClasses) != 0 {
+		t.Fatal("priority class not deleted")
+	}
+}
+
+func TestFSMBindVMRechecksNode(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}})))
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024},
+		Taints: []api.Taint{{Key: "gpu", Effect: api.TaintNoSchedule}}})))
+	f.Apply(mkLog(NewCommand("UpsertVM", api.VM{ID: "a", Phase: "Pending", Resources: api.Resources{CPU: 600, Memory: 512}})))
+	f.Apply(mkLog(NewCommand("UpsertVM", api.VM{ID: "b", Phase: "Pending", Resources: api.Resources{CPU: 600, Memory: 512}})))
+
+	// two schedulers working from the same snapshot both picked n1
+	a := api.VM{ID: "a", NodeID: "n1", Phase: "Scheduled", Resources: api.Resources{CPU: 600, Memory: 512}}
+	b := api.VM{ID: "b", NodeID: "n1", Phase: "Scheduled", Resources: api.Resources{CPU: 600, Memory: 512}}
+	if r := f.Apply(mkLog(NewCommand("BindVM", a))); r != nil {
+		t.Fatalf("bind a: %v", r)
+	}
+	err, _ := f.Apply(mkLog(NewCommand("BindVM", b))).(error)
+	if !errors.Is(err, ErrBindConflict) {
+		t.Fatalf("want a bind conflict, got %v", err)
+	}
+	st := f.GetStateCopy()
+	if st.VMs["b"].NodeID != "" || st.Nodes["n1"].Allocated.CPU != 600 {
+		t.Fatalf("rejected bind changed state: vm %+v, n1 %+v", st.VMs["b"], st.Nodes["n1"].Allocated)
+	}
+
+	// rebinding in place does not count the VM twice
+	a.Phase = "Running"
+	if r := f.Apply(mkLog(NewCommand("BindVM", a))); r != nil {
+		t.Fatalf("rebind a: %v", r)
+	}
+
+	// a batch binds what fits and reports the rest
+	b.NodeID = "n2"
+	c := api.VM{ID: "c", NodeID: "n1", Phase: "Scheduled", Resources: api.Resources{CPU: 300, Memory: 256}}
+	var conflicts BindConflicts
+	err, _ = f.Apply(mkLog(NewCommand("BindVMs", []api.VM{b, c}))).(error)
+	if !errors.As(err, &conflicts) || len(conflicts) != 1 || !strings.Contains(conflicts["b"], "untolerated taints") {
+		t.Fatalf("want b rejected for its taint, got %v", err)
+	}
+	if st := f.GetStateCopy(); st.VMs["c"].NodeID != "n1" || st.Nodes["n1"].Allocated.CPU != 900 {
+		t.Fatalf("c not bound: vm %+v, n1 %+v", st.VMs["c"], st.Nodes["n1"].Allocated)
+	}
+
+	// moving a VM releases its old node
+	a.NodeID = "n2"
+	a.Policy.Tolerations = []api.Toleration{{Key: "gpu", Operator: "Exists"}}
+	if r := f.Apply(mkLog(NewCommand("BindVM", a))); r != nil {
+		t.Fatalf("move a: %v", r)
+	}
+	st = f.GetStateCopy()
+	if st.Nodes["n1"].Allocated.CPU != 300 || st.Nodes["n2"].Allocated.CPU != 600 {
+		t.Fatalf("move did not shift allocation: n1 %+v, n2 %+v", st.Nodes["n1"].Allocated, st.Nodes["n2"].Allocated)
+	}
+}
+
+func TestFSMUpsertVMMovesAllocation(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}})))
+	vm := api.VM{ID: "a", NodeID: "n1", Resources: api.Resources{CPU: 600, Memory: 512}}
+	f.Apply(mkLog(NewCommand("UpsertVM", vm)))
+
+	// re-upserting a placed VM does not count it twice
+	vm.Resources.CPU = 400
+	f.Apply(mkLog(NewCommand("UpsertVM", vm)))
+	if a := f.GetStateCopy().Nodes["n1"].Allocated; a.CPU != 400 || a.Memory != 512 {
+		t.Fatalf("re-upsert allocated %+v", a)
+	}
+
+	// clearing the node releases the allocation
+	vm.NodeID = ""
+	f.Apply(mkLog(NewCommand("UpsertVM", vm)))
+	if a := f.GetStateCopy().Nodes["n1"].Allocated; a.CPU != 0 || a.Memory != 0 {
+		t.Fatalf("unplaced VM still allocated %+v", a)
+	}
+}
+
+func TestUpsertVMCommandOnlyBindsNewPlacements(t *testing.T) {
+	f := NewFSM()
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n1", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}})))
+	f.Apply(mkLog(NewCommand("UpsertNode", api.Node{ID: "n2", Status: "Alive", Capacity: api.Resources{CPU: 1000, Memory: 1024}})))
+	vm := api.VM{ID: "a", NodeID: "n1", Resources: api.Resources{CPU: 600, Memory: 512}}
+	if cmd := UpsertVMCommand(f.GetStateCopy(), vm); cmd.Type != "BindVM" {
+		t.Fatalf("a new VM with a node must be bound, got %s", cmd.Type)
+	}
+	f.Apply(mkLog(UpsertVMCommand(f.GetStateCopy(), vm)))
+
+	// n1 turns not ready: stopping a on it is an update, not a new placement
+	f.Apply(mkLog(NewCommand("SetNodeReadiness", api.NodeReadiness{NodeID: "n1", Ready: false, Reason: "HeartbeatStale", Time: time.Unix(1000, 0)})))
+	if len(f.GetStateCopy().Nodes["n1"].Taints) == 0 {
+		t.Fatal("not-ready node has no taint")
+	}
+	vm.DesiredState = "Stopped"
+	if r := f.Apply(mkLog(UpsertVMCommand(f.GetStateCopy(), vm))); r != nil {
+		t.Fatalf("stopping a vm on a sick node: %v", r)
+	}
+	if got := f.GetStateCopy().VMs["a"]; got.DesiredState != "Stopped" {
+		t.Fatalf("update not stored: %+v", got)
+	}
+
+	// moving it is checked against the new node
+	vm.NodeID, vm.Resources.CPU = "n2", 2000
+	err, _ := f.Apply(mkLog(UpsertVMCommand(f.GetStateCopy(), vm))).(error)
+	if !errors.Is(err, ErrBindConflict) {
+		t.Fatalf("want a bind conflict moving to a full node, got %v", err)
+	}
+}
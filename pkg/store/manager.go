@@ -35,6 +35,12 @@ func (m *Manager) Apply(ctx context.Context, cmd Command) error {
 		return err
 	}
 	metrics.IncCounter("raft_applies_total")
+	// the FSM rejects some commands, e.g. a BindVM whose node filled up
+	if err, ok := f.Response().(error); ok {
+		metrics.IncCounter("raft_apply_rejected_total")
+		m.audit.Add(AuditEvent{Type: cmd.Type, Info: "rejected: " + err.Error()})
+		return err
+	}
 	m.audit.Add(AuditEvent{Type: cmd.Type, Info: "ok"})
 	return nil
 }
@@ -52,6 +58,21 @@ func (m *Manager) SetFSM(fsm *FSM) {
 	m.fsm = fsm
 }
 
+// Watch registers fn with the FSM (see FSM.Watch), so it must be called after SetFSM.
+func (m *Manager) Watch(fn func(Command)) {
+	if m.fsm != nil {
+		m.fsm.Watch(fn)
+	}
+}
+
+// RecordAudit adds an event that did not go through Apply, such as an offline recovery.
+func (m *Manager) RecordAudit(ev AuditEvent) {
+	if m.audit == nil {
+		return
+	}
+	m.audit.Add(ev)
+}
+
 // Audit returns recent audit events from the ring buffer.
 func (m *Manager) Audit() []AuditEvent {
 	if m.audit == nil {